@@ -3,9 +3,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"sync"
@@ -14,36 +19,59 @@ import (
 
 	"github.com/pborman/getopt/v2"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	clientv3 "go.etcd.io/etcd/client/v3"
 
 	"sigsum.org/log-go/internal/config"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/leaf-tracker"
 	"sigsum.org/log-go/internal/metrics"
 	"sigsum.org/log-go/internal/node/primary"
 	rateLimit "sigsum.org/log-go/internal/rate-limit"
 	"sigsum.org/log-go/internal/state"
+	"sigsum.org/log-go/internal/tiles"
+	"sigsum.org/log-go/internal/token-verifier"
 	"sigsum.org/log-go/internal/version"
+	"sigsum.org/log-go/internal/witness"
 
-	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/client"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/key"
 	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/policy"
 	"sigsum.org/sigsum-go/pkg/server"
-	token "sigsum.org/sigsum-go/pkg/submit-token"
 )
 
+// How often rate limit state is saved, if enabled.
+const rateLimitStateInterval = time.Minute
+
 func ParseFlags(c *config.Config) {
 	help := false
 	versionFlag := false
 	getopt.SetParameters("")
 	getopt.FlagLong(&c.Primary.PolicyFile, "policy-file", 0, "Policy, if provided, defines the witnesses to query.")
 	getopt.FlagLong(&c.Primary.RateLimitFile, "rate-limit-file", 0, "Enable rate limiting, based on given config file.", "file")
+	getopt.FlagLong(&c.Primary.RateLimitMode, "rate-limit-mode", 0, "Either \"enforce\" (default), or \"shadow\" to only log and count requests that would be denied.", "mode")
+	getopt.FlagLong(&c.Primary.SubmitTokenKeyFile, "submit-token-key-file", 0, "File listing domains and submit token keys, consulted before DNS.", "file")
+	getopt.FlagLong(&c.Primary.SubmitTokenDns, "submit-token-dns", 0, "Look up submit token keys in DNS (default true).")
+	getopt.FlagLong(&c.Primary.SubmitTokenResolver, "submit-token-resolver", 0, "DNS server used to look up submit token keys (default system resolver).", "host:port")
+	getopt.FlagLong(&c.Primary.SubmitTokenCacheTTL, "submit-token-cache-ttl", 0, "How long verified submit tokens are cached (0 to disable).")
+	getopt.FlagLong(&c.Primary.SubmitTokenNegativeTTL, "submit-token-negative-ttl", 0, "How long invalid submit tokens are cached (0 to disable).")
+	getopt.FlagLong(&c.Primary.RateLimitStateFile, "rate-limit-state-file", 0, "File where rate limit counters are saved, to be restored on restart.", "file")
+	getopt.FlagLong(&c.Primary.RateLimitStore, "rate-limit-store", 0, "Either \"local\" (default), or \"etcd\" to share rate limit counters between frontends.", "store")
+	getopt.FlagLong(&c.Primary.RateLimitEtcdPrefix, "rate-limit-etcd-prefix", 0, "Prefix for etcd keys used for rate limit counters.", "prefix")
 	getopt.FlagLong(&c.Primary.AllowTestDomain, "allow-test-domain", 0, "Allow submit tokens from test.sigsum.org.")
 	getopt.FlagLong(&c.Primary.SecondaryURL, "secondary-url", 0, "Secondary node endpoint for fetching latest replicated tree head.", "url")
 	getopt.FlagLong(&c.Primary.SecondaryPubkeyFile, "secondary-pubkey-file", 0, "Public key for secondary node.", "file")
+	getopt.FlagLong(&c.Primary.ReplicationQuorum, "replication-quorum", 0, "Number of secondaries that must have replicated a tree head before it is signed (default all).")
 	getopt.FlagLong(&c.Primary.SthFile, "sth-file", 0, "File where latest published STH is being stored.", "file")
 	getopt.FlagLong(&c.Primary.MaxRange, "max-range", 0, "Maximum number of leaves that can be retrieved in a single request.")
+	getopt.FlagLong(&c.Primary.EnableTiles, "enable-tiles", 0, "Also serve the published tree as C2SP tlog-tiles.")
+	getopt.FlagLong(&c.Primary.AddLeafMaxWait, "add-leaf-max-wait", 0, "Longest time add-leaf waits for the leaf to be published, if requested by the client (0 to disable).")
+	getopt.FlagLong(&c.Primary.MaxMergeDelay, "max-merge-delay", 0, "Publish deadline promised in signed add-leaf receipts (0 to disable receipts).")
+	getopt.FlagLong(&c.Primary.LeafTrackerMaxLeaves, "leaf-tracker-max-leaves", 0, "Maximum number of accepted leaves tracked until published.")
+	getopt.FlagLong(&c.Primary.CosignatureMaxPast, "cosignature-max-past", 0, "Reject cosignatures with older timestamps, relative to rotation (0 for no bound).")
+	getopt.FlagLong(&c.Primary.CosignatureMaxFuture, "cosignature-max-future", 0, "Reject cosignatures with timestamps further in the future, relative to rotation (0 for no bound).")
+	getopt.FlagLong(&c.Primary.RequireWitnessQuorum, "require-witness-quorum", 0, "Publish a new tree head only when it has reached witness quorum.")
 	getopt.FlagLong(&help, "help", '?', "Display help.")
 	getopt.FlagLong(&versionFlag, "version", 0, "Display server version.")
 	getopt.Parse()
@@ -92,8 +120,13 @@ func main() {
 		log.Fatal("Failed witness configuration: %v", err)
 	}
 
+	readConfig, err := readLimitConfig(conf)
+	if err != nil {
+		log.Fatal("Failed read limit configuration: %v", err)
+	}
+
 	log.Debug("configuring log-go-primary")
-	node, publicKey, err := setupPrimaryFromFlags(conf)
+	node, publicKey, err := setupPrimaryFromFlags(conf, policy)
 	if err != nil {
 		log.Fatal("setup primary: %v", err)
 	}
@@ -115,6 +148,20 @@ func main() {
 		cancel() // must have state manager running
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		node.LeafTracker.Run(ctx, node.Stateman, node.DbClient, conf.Timeout)
+	}()
+
+	if limiter, ok := node.RateLimiter.(rateLimit.ConfiguredLimiter); ok && len(conf.Primary.RateLimitStateFile) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			saveRateLimitState(ctx, limiter, conf.Primary.RateLimitStateFile)
+		}()
+	}
+
 	externalMux := http.NewServeMux()
 	// Register HTTP endpoints.
 	log.Debug("adding external handler under prefix: %s", conf.Prefix)
@@ -125,11 +172,36 @@ func main() {
 	} else {
 		pattern = "/" + conf.Prefix + "/"
 	}
-	externalMux.Handle(pattern, server.NewLog(&server.Config{
-		Prefix:  conf.Prefix,
-		Timeout: conf.Timeout,
-		Metrics: metrics.NewServerMetrics(),
-	}, node))
+	serverMetrics := metrics.NewServerMetrics()
+	newLogHandler := func(timeout time.Duration) http.Handler {
+		// Wrapped to let add-leaf set rate limit headers, and
+		// see the client's wait preference.
+		return primary.WithResponseHeader(primary.WithWaitPreference(server.NewLog(&server.Config{
+			Prefix:  conf.Prefix,
+			Timeout: timeout,
+			Metrics: serverMetrics,
+		}, node)))
+	}
+	externalMux.Handle(pattern, newLogHandler(conf.Timeout))
+	if conf.Primary.AddLeafMaxWait > 0 {
+		// Leave room for add-leaf requests waiting for the
+		// leaf to be published, without raising the timeout
+		// for other requests.
+		externalMux.Handle("POST "+pattern+"add-leaf", primary.WithWaitTimeout(
+			newLogHandler(conf.Timeout),
+			newLogHandler(conf.Timeout+conf.Primary.AddLeafMaxWait)))
+		// Waiting requests don't load the backend, and are
+		// limited by the add-leaf rate limits, so they
+		// mustn't use up the in-flight slots.
+		readConfig.Exempt = func(r *http.Request) bool {
+			return r.Method == http.MethodPost && r.URL.Path == pattern+"add-leaf" && primary.IsWaitRequest(r)
+		}
+	}
+	externalMux.Handle("POST "+pattern+"add-leaves", primary.NewAddLeavesHandler(node, conf.Timeout))
+	if conf.Primary.EnableTiles {
+		log.Debug("adding tlog-tiles handler under prefix: %s", conf.Prefix)
+		tiles.NewServer(&publicKey, node.DbClient, node.Stateman, conf.Timeout).Register(externalMux, pattern)
+	}
 
 	infoPage := []byte(fmt.Sprintf(`
 <!DOCTYPE html>
@@ -153,7 +225,11 @@ func main() {
 			http.Redirect(w, r, conf.Prefix+"/", http.StatusMovedPermanently)
 		})
 	}
-	extserver := &http.Server{Addr: conf.ExternalEndpoint, Handler: externalMux}
+	var externalHandler http.Handler = externalMux
+	if readConfig.Rate > 0 || readConfig.MaxInFlight > 0 {
+		externalHandler = rateLimit.NewReadLimitHandler(externalMux, pattern, readConfig)
+	}
+	extserver := &http.Server{Addr: conf.ExternalEndpoint, Handler: externalHandler}
 
 	internalMux := http.NewServeMux()
 	log.Debug("adding internal handler under prefix: %s", conf.Prefix)
@@ -168,6 +244,42 @@ func main() {
 
 	log.Debug("adding prometheus handler to internal mux, on path: /metrics")
 	internalMux.Handle("/metrics", promhttp.Handler())
+	reloader := configReloader{conf: conf, node: node}
+	log.Debug("adding reload handler to internal mux, on path: /reload")
+	internalMux.HandleFunc("POST /reload", func(w http.ResponseWriter, _ *http.Request) {
+		if err := reloader.reload(); err != nil {
+			log.Warning("reloading configuration failed, keeping old configuration: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("reloaded\n"))
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				log.Info("received SIGHUP, reloading configuration")
+				if err := reloader.reload(); err != nil {
+					log.Warning("reloading configuration failed, keeping old configuration: %v", err)
+				}
+			}
+		}
+	}()
+
+	log.Debug("adding witness status handler to internal mux, on path: /witness-status")
+	internalMux.HandleFunc("GET /witness-status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(w).Encode(node.Stateman.WitnessStatus()); err != nil {
+			log.Warning("writing witness status failed: %v", err)
+		}
+	})
 	intserver := &http.Server{Addr: conf.InternalEndpoint, Handler: internalMux}
 
 	wg.Add(1)
@@ -185,7 +297,7 @@ func main() {
 	go func() {
 		defer wg.Done()
 		log.Info("serving clients on %v/%v", conf.ExternalEndpoint, conf.Prefix)
-		if err = extserver.ListenAndServe(); err != http.ErrServerClosed {
+		if err = listenAndServe(extserver, conf.Primary.ProxyProtocol, readConfig); err != http.ErrServerClosed {
 			log.Error("serve(server): %v", err)
 		}
 		log.Debug("public endpoints server shut down")
@@ -205,7 +317,7 @@ func main() {
 }
 
 // setupPrimaryFromFlags() sets up a new sigsum primary node from flags.
-func setupPrimaryFromFlags(conf *config.Config) (*primary.Primary, crypto.PublicKey, error) {
+func setupPrimaryFromFlags(conf *config.Config, policy *policy.Policy) (*primary.Primary, crypto.PublicKey, error) {
 	var p primary.Primary
 
 	// Setup logging configuration.
@@ -215,12 +327,24 @@ func setupPrimaryFromFlags(conf *config.Config) (*primary.Primary, crypto.Public
 	}
 	publicKey := signer.Public()
 	p.MaxRange = conf.MaxRange
+	p.MaxAddLeafWait = conf.Primary.AddLeafMaxWait
+	if conf.Primary.MaxMergeDelay > 0 {
+		p.ReceiptSigner = signer
+		p.MaxMergeDelay = conf.Primary.MaxMergeDelay
+	}
+	p.LeafTracker = leafTracker.New(conf.Primary.LeafTrackerMaxLeaves, metrics.NewLeafTrackerMetrics())
 
 	switch conf.Backend {
 	default:
-		return nil, crypto.PublicKey{}, fmt.Errorf("unknown backend %q, must be \"trillian\" (default) or \"ephemeral\"", conf.Backend)
+		return nil, crypto.PublicKey{}, fmt.Errorf("unknown backend %q, must be \"trillian\" (default), \"local\", or \"ephemeral\"", conf.Backend)
 	case "ephemeral":
 		p.DbClient = db.NewMemoryDb()
+	case "local":
+		localDb, err := db.OpenLocalDb(conf.LocalDbDir)
+		if err != nil {
+			return nil, crypto.PublicKey{}, fmt.Errorf("opening local database failed: %v", err)
+		}
+		p.DbClient = localDb
 	case "trillian":
 		trillianClient, err := db.DialTrillian(conf.TrillianRpcServer, conf.Timeout, db.PrimaryTree, conf.TrillianTreeIDFile)
 		if err != nil {
@@ -229,34 +353,71 @@ func setupPrimaryFromFlags(conf *config.Config) (*primary.Primary, crypto.Public
 		p.DbClient = trillianClient
 	}
 	// Setup secondary node configuration.
-	var secondary api.Secondary
-	var secondaryPub crypto.PublicKey
+	nodes := conf.Primary.Secondaries
 	if conf.Primary.SecondaryURL != "" && conf.Primary.SecondaryPubkeyFile != "" {
-		var err error
-		secondaryPub, err = key.ReadPublicKeyFile(conf.Primary.SecondaryPubkeyFile)
+		nodes = append([]config.SecondaryNode{{
+			Name:       "secondary",
+			URL:        conf.Primary.SecondaryURL,
+			PubkeyFile: conf.Primary.SecondaryPubkeyFile,
+		}}, nodes...)
+	}
+	var secondaries []state.Secondary
+	for _, node := range nodes {
+		secondaryPub, err := key.ReadPublicKeyFile(node.PubkeyFile)
 		if err != nil {
-			return nil, crypto.PublicKey{}, fmt.Errorf("failed to read secondary node pubkey: %v", err)
+			return nil, crypto.PublicKey{}, fmt.Errorf("failed to read pubkey for secondary node %q: %v", node.Name, err)
 		}
-		secondary = client.New(client.Config{URL: conf.Primary.SecondaryURL})
+		secondaries = append(secondaries, state.Secondary{
+			Name:      node.Name,
+			Client:    client.New(client.Config{URL: node.URL}),
+			PublicKey: secondaryPub,
+		})
+	}
+	quorum := conf.Primary.ReplicationQuorum
+	if quorum == 0 {
+		quorum = len(secondaries)
 	}
 
 	// Setup state manager.
 	p.Stateman, err = state.NewStateManagerSingle(p.DbClient, signer, conf.Timeout,
-		secondary, &secondaryPub, conf.Primary.SthFile)
+		secondaries, quorum, conf.Primary.SthFile, policy, conf.Primary.RequireWitnessQuorum,
+		witness.TimestampWindow{
+			MaxPast:   conf.Primary.CosignatureMaxPast,
+			MaxFuture: conf.Primary.CosignatureMaxFuture,
+		})
 	if err != nil {
 		return nil, crypto.PublicKey{}, fmt.Errorf("NewStateManagerSingle: %v", err)
 	}
 
-	p.TokenVerifier = token.NewDnsVerifier(&publicKey)
+	p.TokenVerifier, err = submitTokenVerifier(conf, &publicKey)
+	if err != nil {
+		return nil, crypto.PublicKey{}, err
+	}
 	if len(conf.Primary.RateLimitFile) > 0 {
-		f, err := os.Open(conf.Primary.RateLimitFile)
+		stores, err := rateLimitStores(conf)
 		if err != nil {
-			return nil, crypto.PublicKey{}, fmt.Errorf("opening rate limit config file failed: %v", err)
+			return nil, crypto.PublicKey{}, err
 		}
-		p.RateLimiter, err = rateLimit.NewLimiter(f, conf.Primary.AllowTestDomain)
+		limiter, err := rateLimit.NewLimiter(conf.Primary.RateLimitFile, conf.Primary.AllowTestDomain, stores)
 		if err != nil {
 			return nil, crypto.PublicKey{}, fmt.Errorf("initializing rate limiter failed: %v", err)
 		}
+		switch conf.Primary.RateLimitMode {
+		case "enforce":
+		case "shadow":
+			log.Info("rate limiting in shadow mode, requests are never denied")
+			limiter = rateLimit.NewShadowLimiter(limiter, metrics.NewRateLimitMetrics())
+		default:
+			return nil, crypto.PublicKey{}, fmt.Errorf("unknown rate limit mode %q, must be \"enforce\" (default) or \"shadow\"", conf.Primary.RateLimitMode)
+		}
+		if stateFile := conf.Primary.RateLimitStateFile; len(stateFile) > 0 {
+			if err := limiter.LoadState(stateFile); errors.Is(err, fs.ErrNotExist) {
+				log.Info("no rate limit state file %q, starting with full buckets", stateFile)
+			} else if err != nil {
+				log.Warning("restoring rate limit state failed, starting with full buckets: %v", err)
+			}
+		}
+		p.RateLimiter = limiter
 	} else {
 		p.RateLimiter = rateLimit.NoLimit{}
 	}
@@ -264,6 +425,149 @@ func setupPrimaryFromFlags(conf *config.Config) (*primary.Primary, crypto.Public
 	return &p, publicKey, nil
 }
 
+// Returns the factory for the configured rate limit counter store.
+func rateLimitStores(conf *config.Config) (rateLimit.StoreFactory, error) {
+	switch conf.Primary.RateLimitStore {
+	case "local":
+		return rateLimit.LocalStore, nil
+	case "etcd":
+		if len(conf.Primary.RateLimitEtcdEndpoints) == 0 {
+			return nil, fmt.Errorf("rate limit store etcd requires rate-limit-etcd-endpoints")
+		}
+		if len(conf.Primary.RateLimitStateFile) > 0 {
+			return nil, fmt.Errorf("rate limit state file can't be used with the etcd store")
+		}
+		client, err := clientv3.New(clientv3.Config{
+			Endpoints:   conf.Primary.RateLimitEtcdEndpoints,
+			DialTimeout: conf.Timeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("connecting to etcd failed: %v", err)
+		}
+		return rateLimit.NewEtcdStores(client, conf.Primary.RateLimitEtcdPrefix, conf.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q, must be \"local\" (default) or \"etcd\"", conf.Primary.RateLimitStore)
+	}
+}
+
+// Returns a verifier using the key file, if configured, and DNS,
+// unless disabled.
+func submitTokenVerifier(conf *config.Config, publicKey *crypto.PublicKey) (tokenVerifier.Verifier, error) {
+	var verifier tokenVerifier.Verifier
+	if conf.Primary.SubmitTokenDns {
+		verifier = tokenVerifier.NewCachingVerifier(
+			tokenVerifier.NewDnsVerifier(tokenVerifier.NewResolver(conf.Primary.SubmitTokenResolver),
+				publicKey, metrics.NewTokenMetrics()),
+			conf.Primary.SubmitTokenCacheTTL, conf.Primary.SubmitTokenNegativeTTL)
+	}
+	if len(conf.Primary.SubmitTokenKeyFile) == 0 {
+		if verifier == nil {
+			return nil, fmt.Errorf("submit token verification via DNS disabled, but no key file configured")
+		}
+		return verifier, nil
+	}
+	f, err := os.Open(conf.Primary.SubmitTokenKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("opening submit token key file failed: %v", err)
+	}
+	defer f.Close()
+	verifier, err = tokenVerifier.NewStaticVerifier(f, publicKey, verifier)
+	if err != nil {
+		return nil, fmt.Errorf("reading submit token key file %q failed: %v", conf.Primary.SubmitTokenKeyFile, err)
+	}
+	return verifier, nil
+}
+
+func readLimitConfig(conf *config.Config) (rateLimit.ReadConfig, error) {
+	readConfig := rateLimit.ReadConfig{
+		Rate:        conf.Primary.ReadRateLimit,
+		Burst:       conf.Primary.ReadRateLimitBurst,
+		MaxInFlight: conf.Primary.MaxInFlight,
+	}
+	for _, s := range conf.Primary.TrustedProxies {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			// Also accept a single address.
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				return rateLimit.ReadConfig{}, fmt.Errorf("invalid trusted proxy %q: %v", s, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		readConfig.TrustedProxies = append(readConfig.TrustedProxies, prefix.Masked())
+	}
+	if conf.Primary.ProxyProtocol && len(readConfig.TrustedProxies) == 0 {
+		return rateLimit.ReadConfig{}, fmt.Errorf("proxy protocol enabled, but no trusted proxies configured")
+	}
+	return readConfig, nil
+}
+
+// Like server.ListenAndServe, but optionally accepting the PROXY
+// protocol from trusted proxies.
+func listenAndServe(server *http.Server, proxyProtocol bool, readConfig rateLimit.ReadConfig) error {
+	if !proxyProtocol {
+		return server.ListenAndServe()
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(rateLimit.NewProxyListener(listener, readConfig))
+}
+
+// Periodically saves the rate limit state, and a final time when ctx
+// is cancelled.
+func saveRateLimitState(ctx context.Context, limiter rateLimit.ConfiguredLimiter, fileName string) {
+	ticker := time.NewTicker(rateLimitStateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := limiter.SaveState(fileName); err != nil {
+				log.Error("saving rate limit state failed: %v", err)
+			}
+			log.Debug("rate limit state saver shutdown")
+			return
+		case <-ticker.C:
+			if err := limiter.SaveState(fileName); err != nil {
+				log.Warning("saving rate limit state failed: %v", err)
+			}
+		}
+	}
+}
+
+// Reloads the policy and rate limit config files.
+type configReloader struct {
+	conf *config.Config
+	node *primary.Primary
+	// Serializes reloads.
+	sync.Mutex
+}
+
+// Either all files are reloaded, or, if any file fails to parse, the
+// old configuration is kept.
+func (r *configReloader) reload() error {
+	r.Lock()
+	defer r.Unlock()
+
+	policy, err := configuredPolicy(r.conf.PolicyFile)
+	if err != nil {
+		return fmt.Errorf("reading policy failed: %v", err)
+	}
+	if len(r.conf.Primary.RateLimitFile) > 0 {
+		limiter, ok := r.node.RateLimiter.(rateLimit.ConfiguredLimiter)
+		if !ok {
+			return fmt.Errorf("rate limiter can't be reloaded")
+		}
+		if err := limiter.Reload(r.conf.Primary.RateLimitFile); err != nil {
+			return fmt.Errorf("reloading rate limiter failed: %v", err)
+		}
+	}
+	r.node.Stateman.SetPolicy(policy)
+	log.Info("reloaded configuration")
+	return nil
+}
+
 func configuredPolicy(file string) (*policy.Policy, error) {
 	if len(file) == 0 {
 		return nil, nil
@@ -0,0 +1,217 @@
+// Package main provides a sigsum-log-promote binary, for promoting a
+// secondary node to become the primary, see doc/failover.md.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pborman/getopt/v2"
+
+	"sigsum.org/log-go/internal/config"
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/state"
+	"sigsum.org/log-go/internal/version"
+
+	"sigsum.org/sigsum-go/pkg/client"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/key"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+type settings struct {
+	treeHeadFile string
+	logURL       string
+}
+
+func ParseFlags(c *config.Config) settings {
+	var s settings
+	help := false
+	versionFlag := false
+	getopt.SetParameters("")
+	getopt.FlagLong(&s.treeHeadFile, "tree-head-file", 0, "File with the last known signed tree head, e.g., a copy of the old primary's sth file.", "file")
+	getopt.FlagLong(&s.logURL, "log-url", 0, "Log url, for fetching the last published tree head from the old primary, if still reachable.", "url")
+	getopt.FlagLong(&c.Primary.SthFile, "sth-file", 0, "File where latest published STH is to be stored.", "file")
+	getopt.FlagLong(&help, "help", '?', "Display help.")
+	getopt.FlagLong(&versionFlag, "version", 0, "Display version.")
+	c.ServerFlags(getopt.CommandLine)
+	getopt.Parse()
+	if help {
+		getopt.PrintUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if versionFlag {
+		fmt.Printf("log-go version: %s\n", version.ModuleVersion())
+		os.Exit(0)
+	}
+	if (s.treeHeadFile == "") == (s.logURL == "") {
+		log.Fatalf("exactly one of --tree-head-file and --log-url must be provided")
+	}
+	return s
+}
+
+func main() {
+	log.SetFlags(0)
+	var conf *config.Config
+	// Read default values from the Config struct
+	confFile, err := config.OpenConfigFile()
+	if err != nil {
+		log.Printf("didn't find configuration file, using defaults: %v", err)
+		conf = config.NewConfig()
+	} else {
+		conf, err = config.LoadConfig(confFile)
+		if err != nil {
+			log.Fatalf("failed to parse config file: %v", err)
+		}
+	}
+	settings := ParseFlags(conf)
+
+	// Step 0: Check that no previous state is in the way.
+	for _, file := range []string{conf.SthFile, conf.SthFile + state.StartupFileSuffix} {
+		if _, err := os.Stat(file); !errors.Is(err, fs.ErrNotExist) {
+			log.Fatalf("Unexpected file %q, refusing to promote a node that has already been configured as primary.", file)
+		}
+	}
+
+	// Step 1: The secondary server must be shut down.
+	if conn, err := net.DialTimeout("tcp", conf.InternalEndpoint, time.Second); err == nil {
+		conn.Close()
+		log.Fatalf("Internal endpoint %s is still accepting connections, shut down the secondary first.",
+			conf.InternalEndpoint)
+	}
+	log.Printf("ok: nothing is listening on internal endpoint %s", conf.InternalEndpoint)
+
+	// Step 2: The node must be configured with the log's signing
+	// key, which is used to verify the last known tree head.
+	publicKey, err := readLogPublicKey(conf.KeyFile)
+	if err != nil {
+		log.Fatalf("failed reading log's key: %v", err)
+	}
+	published, err := lastTreeHead(&settings, &publicKey, conf.Timeout)
+	if err != nil {
+		log.Fatalf("failed to get last known tree head: %v", err)
+	}
+	log.Printf("ok: last known tree head, size %d, is signed by the configured key %x",
+		published.Size, crypto.HashBytes(publicKey[:]))
+
+	// Step 3: The local tree must include the last known tree head.
+	if err := checkLocalTree(conf, &published); err != nil {
+		log.Fatalf("Refusing to promote: %v", err)
+	}
+
+	// Step 4: Convert the tree, if needed by the backend.
+	switch conf.Backend {
+	case "trillian":
+		if err := db.PromoteTrillianTree(conf.TrillianRpcServer, conf.Timeout, conf.TrillianTreeIDFile); err != nil {
+			log.Fatalf("converting trillian tree failed: %v", err)
+		}
+		log.Printf("ok: trillian tree converted to type LOG")
+	case "local":
+		// Same storage format for primary and secondary.
+	default:
+		log.Fatalf("backend %q can't be promoted", conf.Backend)
+	}
+
+	// Step 5: Tell the primary to start from the local tree.
+	if err := state.CreateStartupFile(conf.SthFile, state.StartupLocalTree); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("ok: created startup file %q", conf.SthFile+state.StartupFileSuffix)
+
+	log.Printf("Remaining steps: configure a new secondary node, start sigsum-log-primary, and update DNS records.")
+}
+
+// The key file is either a public key (private key accessed via
+// ssh-agent), or a private key; only the public key is needed here.
+func readLogPublicKey(file string) (crypto.PublicKey, error) {
+	if publicKey, err := key.ReadPublicKeyFile(file); err == nil {
+		return publicKey, nil
+	}
+	signer, err := key.ReadPrivateKeyFile(file)
+	if err != nil {
+		return crypto.PublicKey{}, err
+	}
+	return signer.Public(), nil
+}
+
+func lastTreeHead(s *settings, publicKey *crypto.PublicKey, timeout time.Duration) (types.TreeHead, error) {
+	var sth types.SignedTreeHead
+	if s.treeHeadFile != "" {
+		f, err := os.Open(s.treeHeadFile)
+		if err != nil {
+			return types.TreeHead{}, err
+		}
+		defer f.Close()
+		if err := sth.FromASCII(f); err != nil {
+			return types.TreeHead{}, err
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cth, err := client.New(client.Config{URL: s.logURL}).GetTreeHead(ctx)
+		if err != nil {
+			return types.TreeHead{}, err
+		}
+		sth = cth.SignedTreeHead
+	}
+	if !sth.Verify(publicKey) {
+		return types.TreeHead{}, fmt.Errorf("invalid tree head signature, is key-file configured with the log's key?")
+	}
+	return sth.TreeHead, nil
+}
+
+func checkLocalTree(conf *config.Config, published *types.TreeHead) error {
+	var tree db.Client
+	switch conf.Backend {
+	case "trillian":
+		trillianClient, err := db.DialTrillian(conf.TrillianRpcServer, conf.Timeout, db.PromotedTree, conf.TrillianTreeIDFile)
+		if err != nil {
+			return err
+		}
+		tree = trillianClient
+	case "local":
+		localDb, err := db.OpenLocalDb(conf.LocalDbDir)
+		if err != nil {
+			return err
+		}
+		defer localDb.Close()
+		tree = localDb
+	default:
+		return fmt.Errorf("backend %q can't be promoted", conf.Backend)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout)
+	defer cancel()
+
+	local, err := tree.GetTreeHead(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case local.Size < published.Size:
+		return fmt.Errorf("local tree is behind, size %d < %d", local.Size, published.Size)
+	case local.Size == published.Size:
+		if local.RootHash != published.RootHash {
+			return fmt.Errorf("local tree, size %d, has unexpected root hash", local.Size)
+		}
+	case published.Size > 0:
+		proof, err := tree.GetConsistencyProof(ctx, &requests.ConsistencyProof{
+			OldSize: published.Size,
+			NewSize: local.Size,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to get consistency proof from %d to %d: %v", published.Size, local.Size, err)
+		}
+		if err := proof.Verify(published, &local); err != nil {
+			return fmt.Errorf("local tree, size %d, is not consistent with last known tree head: %v", local.Size, err)
+		}
+	}
+	log.Printf("ok: local tree, size %d, is consistent with last known tree head", local.Size)
+	return nil
+}
@@ -158,9 +158,15 @@ func setupSecondaryFromFlags(conf *config.Config) (*secondary.Secondary, error)
 
 	switch conf.Backend {
 	default:
-		return nil, fmt.Errorf("unknown backend %q, must be \"trillian\" (default) or \"ephemeral\"", conf.Backend)
+		return nil, fmt.Errorf("unknown backend %q, must be \"trillian\" (default), \"local\", or \"ephemeral\"", conf.Backend)
 	case "ephemeral":
 		s.DbClient = db.NewMemoryDb()
+	case "local":
+		localDb, err := db.OpenLocalDb(conf.LocalDbDir)
+		if err != nil {
+			return nil, fmt.Errorf("opening local database failed: %v", err)
+		}
+		s.DbClient = localDb
 	case "trillian":
 		trillianClient, err := db.DialTrillian(conf.TrillianRpcServer, conf.Timeout, db.SecondaryTree, conf.TrillianTreeIDFile)
 		if err != nil {
@@ -71,13 +71,10 @@ func main() {
 		}
 		checkNotExists(startupFile)
 
-	case state.StartupEmpty:
-		checkNotExists(conf.SthFile)
-		writeStartupFile(startupFile, "empty")
-
-	case state.StartupLocalTree:
-		checkNotExists(conf.SthFile)
-		writeStartupFile(startupFile, "local-tree")
+	case state.StartupEmpty, state.StartupLocalTree:
+		if err := state.CreateStartupFile(conf.SthFile, startupMode); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
 
@@ -86,22 +83,3 @@ func checkNotExists(file string) {
 		log.Fatalf("Unexpected file %q, inconsistent with specified startup state.", file)
 	}
 }
-
-// Writing is not atomic, user is expected to not run this tool under
-// the feet of log server startup.
-func writeStartupFile(name string, mode string) {
-	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
-	if err != nil {
-		log.Fatalf("creating startup file failed: %v", err)
-	}
-	defer f.Close()
-	_, err = fmt.Fprintf(f, "startup=%s", mode)
-	// Explicit close, to catch errors.
-	if err == nil {
-		err = f.Close()
-	}
-	if err != nil {
-		log.Fatalf("writing startup file failed: %v", err)
-	}
-
-}
@@ -17,7 +17,7 @@ declare -r logc=conf/logc.config
 declare -r client=conf/client.config
 declare -r mysql_uri="${MYSQL_URI:-sigsum_test:zaphod@tcp(127.0.0.1:3306)/sigsum_test}"
 
-# Set based on --extended / --ephemeral options
+# Set based on --extended / --ephemeral / --local options
 testflavor=basic
 keep_running=false
 
@@ -30,6 +30,9 @@ function main() {
 			--ephemeral)
 				testflavor=ephemeral
 				;;
+			--local)
+				testflavor=local
+				;;
 			--keep-running)
 				keep_running=true
 				;;
@@ -59,16 +62,10 @@ EOF
 	nvars[$loga:ssrv_extra_args]+=" --secondary-pubkey-file=${nvars[$logb:log_dir]}/ssrv.key.pub"
 	nvars[$loga:ssrv_extra_args]+=" --rate-limit-file=rate-limit.cfg"
 	nvars[$loga:ssrv_extra_args]+=" --allow-test-domain=true"
-	if [[ "$testflavor" = ephemeral ]] ; then
-		nvars[$loga:ssrv_extra_args]+=" --backend ephemeral"
-	fi
 	node_start $loga
 
 	# Secondary
 	nvars[$logb:ssrv_extra_args]="--primary-url=http://${nvars[$loga:int_url]}"
-	if [[ "$testflavor" = ephemeral ]] ; then
-		nvars[$logb:ssrv_extra_args]+=" --backend ephemeral"
-	fi
 	node_start $logb
 
 	# Wait a bit to give time to the logs to be ready
@@ -111,11 +108,16 @@ EOF
 	fi
 }
 
+# True if the test flavor uses trillian (and mysql) as backend.
+function with_trillian() {
+	[[ "$testflavor" != ephemeral && "$testflavor" != local ]]
+}
+
 function install_go_deps() {
 	GOBIN=$(pwd)/bin go install sigsum.org/sigsum-go/cmd/...
 	GOBIN=$(pwd)/bin go install ../cmd/...
 	GOBIN=$(pwd)/bin go install sigsum.org/key-mgmt/cmd/sigsum-agent@v0.2.1
-	if [[ "$testflavor" != ephemeral ]] ; then
+	if with_trillian ; then
 		GOBIN=$(pwd)/bin go install github.com/google/trillian/cmd/...
 	fi
 }
@@ -138,7 +140,7 @@ function client_setup() {
 function node_setup() {
 	for i in $@; do
 		logdir_setup $i
-		if [[ "$testflavor" != ephemeral ]] ; then
+		if with_trillian ; then
 			trillian_setup $i
 		fi
 		sigsum_setup $i
@@ -148,7 +150,7 @@ function node_setup() {
 # node_start starts trillian and sigsum and creates new trees
 function node_start() {
 	for i in $@; do
-		if [[ "$testflavor" != ephemeral ]] ; then
+		if with_trillian ; then
 			trillian_start $i
 		fi
 		sigsum_create_tree $i
@@ -158,7 +160,7 @@ function node_start() {
 
 # node_start_* starts sequencer and sigsum but does not create new trees
 function node_start_fe() {
-	if [[ "$testflavor" != ephemeral ]] ; then
+	if with_trillian ; then
 		trillian_start_sequencer $@
 	fi
 	sigsum_start $@
@@ -171,18 +173,6 @@ function node_promote() {
 	[[ ${nvars[$prev_primary:ssrv_role]} == primary ]] || die "$prev_primary: not the primary node"
 
 	info "promoting secondary node to primary ($new_primary)"
-	local srv=${nvars[$new_primary:tsrv_rpc]}
-	local tree_id=$(cut -d= -f2 ${nvars[$new_primary:log_dir]}/tree-id)
-
-	# NOTE: updatetree doesn't seem to exit with !=0 when failing
-	# TODO: try combining the first two invocations into one
-	[[ $(./bin/updatetree --admin_server $srv -tree_id $tree_id -tree_state FROZEN -logtostderr=false -log_file=${nvars[$new_primary:log_dir]}/updatetree.log) == FROZEN ]] || \
-		die "unable to freeze tree $tree_id"
-	[[ $(./bin/updatetree --admin_server $srv -tree_id $tree_id -tree_type LOG     -logtostderr=false -log_file=${nvars[$new_primary:log_dir]}/updatetree.log) == FROZEN ]] || \
-		die "unable to change tree type to LOG for tree $tree_id"
-	[[ $(./bin/updatetree --admin_server $srv -tree_id $tree_id -tree_state ACTIVE -logtostderr=false -log_file=${nvars[$new_primary:log_dir]}/updatetree.log) == ACTIVE ]] || \
-		die "unable to unfreeze tree $tree_id"
-	info "tree $tree_id type changed from PREORDERED_LOG to LOG"
 
 	nvars[$new_primary:ssrv_role]=primary
 	nvars[$new_primary:ssrv_interval]=5 # FIXME: parameterize
@@ -194,8 +184,21 @@ function node_promote() {
 	nvars[$new_primary:token]=${nvars[$prev_primary:token]}
 	nvars[$new_primary:ssrv_agent]=${nvars[$prev_primary:ssrv_agent]}
 
-	info "creating sth startup=local-tree"
-	./bin/sigsum-mktree --mode=local-tree --sth-file=${nvars[$new_primary:log_dir]}/sth-store
+	local backend_args
+	if with_trillian ; then
+		backend_args="--trillian-rpc-server=${nvars[$new_primary:tsrv_rpc]}"
+		backend_args+=" --trillian-tree-id-file=${nvars[$new_primary:log_dir]}/tree-id"
+	else
+		backend_args="--backend local --local-db-dir=${nvars[$new_primary:log_dir]}/db"
+	fi
+	./bin/sigsum-log-promote $backend_args \
+		--tree-head-file=${nvars[$prev_primary:log_dir]}/sth-store \
+		--sth-file=${nvars[$new_primary:log_dir]}/sth-store \
+		--internal-endpoint=${nvars[$new_primary:ssrv_internal]} \
+		--key-file=${nvars[$new_primary:log_dir]}/ssrv.key.pub \
+		2>${nvars[$new_primary:log_dir]}/sigsum-log-promote.log || \
+		die "unable to promote $new_primary, see ${nvars[$new_primary:log_dir]}/sigsum-log-promote.log"
+	info "promoted $new_primary, created sth startup=local-tree"
 }
 
 function trillian_setup() {
@@ -322,6 +325,9 @@ function sigsum_start() {
 		fi
 		if [[ "$testflavor" = ephemeral ]] ; then
 			extra_args+=" --backend ephemeral"
+		elif [[ "$testflavor" = local ]] ; then
+			extra_args+=" --backend local"
+			extra_args+=" --local-db-dir=${nvars[$i:log_dir]}/db"
 		else
 			extra_args+=" --trillian-rpc-server=${nvars[$i:tsrv_rpc]}"
 			extra_args+=" --trillian-tree-id-file=${nvars[$i:log_dir]}/tree-id"
@@ -359,7 +365,7 @@ function node_stop() {
 
 # Delete log tree for, requires trillian server ("backend") to be running
 function node_destroy() {
-	if [[ "$testflavor" != ephemeral ]] ; then
+	if with_trillian ; then
 		for i in $@; do
 			local tree_id=$(cut -d= -f2 ${nvars[$i:log_dir]}/tree-id)
 			if ! ./bin/deletetree -admin_server=$tsrv_rpc -log_id=${tree_id} -logtostderr=false -log_file=${nvars[$i:log_dir]}/deletetree.log; then
@@ -375,7 +381,7 @@ function node_stop_fe() {
 	for i in $@; do
 
 		[[ -v nvars[$i:ssrv_pid] ]] && pp ${nvars[$i:ssrv_pid]} && kill ${nvars[$i:ssrv_pid]} # FIXME: why is SIGINT (often) not enough?
-		if [[ "$testflavor" != ephemeral ]] ; then
+		if with_trillian ; then
 			[[ -v nvars[$i:tseq_pid] ]] && pp ${nvars[$i:tseq_pid]} && kill -2 ${nvars[$i:tseq_pid]}
 			while :; do
 				sleep 1
@@ -393,7 +399,7 @@ function node_stop_fe() {
 }
 
 function node_stop_be() {
-	if [[ "$testflavor" != ephemeral ]] ; then
+	if with_trillian ; then
 		for i in $@; do
 			pp ${nvars[$i:tsrv_pid]} && kill ${nvars[$i:tsrv_pid]}
 			while :; do
@@ -434,7 +440,7 @@ function check_setup() {
 	sleep 3
 	for i in $@; do
 		info "checking setup for $i"
-		if [[ "$testflavor" != ephemeral ]] ; then
+		if with_trillian ; then
 			if [[ ${nvars[$i:ssrv_role]} == primary ]]; then
 				[[ -v nvars[$i:tseq_pid] ]] && pp ${nvars[$i:tseq_pid]} || die "must have Trillian log sequencer ($i)"
 			fi
@@ -12,13 +12,84 @@ import (
 
 // Primary Config
 type Primary struct {
-	PolicyFile          string `toml:"policy-file"`
-	RateLimitFile       string `toml:"rate-limit-file"`
-	AllowTestDomain     bool   `toml:"allow-test-domain"`
-	SecondaryURL        string `toml:"secondary-url"`
-	SecondaryPubkeyFile string `toml:"secondary-pubkey-file"`
-	SthFile             string `toml:"sth-file"`
-	MaxRange            int    `toml:"max-range"`
+	PolicyFile    string `toml:"policy-file"`
+	RateLimitFile string `toml:"rate-limit-file"`
+	// Either "enforce" (default), or "shadow", meaning that
+	// requests that would be denied are only logged and counted.
+	RateLimitMode string `toml:"rate-limit-mode"`
+	// Requests per minute and source address allowed to
+	// get-leaves and the proof endpoints. Zero means no limit.
+	ReadRateLimit      int `toml:"read-rate-limit"`
+	ReadRateLimitBurst int `toml:"read-rate-limit-burst"`
+	// Maximum number of concurrent requests to the external
+	// endpoint; further requests get a 503 response. Zero means
+	// no limit. Add-leaf requests waiting for publication are
+	// not counted.
+	MaxInFlight int `toml:"max-in-flight"`
+	// Address prefixes of proxies trusted to report the original
+	// source address, in X-Forwarded-For headers, or using the
+	// PROXY protocol, if enabled.
+	TrustedProxies []string `toml:"trusted-proxies"`
+	ProxyProtocol  bool     `toml:"proxy-protocol"`
+	// File listing domains and their submit token keys. Consulted
+	// before DNS, for the domains listed.
+	SubmitTokenKeyFile string `toml:"submit-token-key-file"`
+	// If false, keys are never looked up in DNS.
+	SubmitTokenDns bool `toml:"submit-token-dns"`
+	// DNS server (host:port) used to look up submit token keys.
+	// Empty means the system resolver.
+	SubmitTokenResolver string `toml:"submit-token-resolver"`
+	// How long results of submit token verification are cached,
+	// for valid and invalid tokens, respectively. Zero disables
+	// caching.
+	SubmitTokenCacheTTL    time.Duration `toml:"submit-token-cache-ttl"`
+	SubmitTokenNegativeTTL time.Duration `toml:"submit-token-negative-ttl"`
+	// If set, rate limit counters are saved to this file, and
+	// restored on restart.
+	RateLimitStateFile string `toml:"rate-limit-state-file"`
+	// Either "local" (default), or "etcd", to share rate limit
+	// counters between all frontends using the same etcd
+	// cluster and key prefix.
+	RateLimitStore         string   `toml:"rate-limit-store"`
+	RateLimitEtcdEndpoints []string `toml:"rate-limit-etcd-endpoints"`
+	RateLimitEtcdPrefix    string   `toml:"rate-limit-etcd-prefix"`
+	AllowTestDomain        bool     `toml:"allow-test-domain"`
+	SecondaryURL           string   `toml:"secondary-url"`
+	SecondaryPubkeyFile    string   `toml:"secondary-pubkey-file"`
+	// Additional secondaries, besides the one configured by
+	// secondary-url and secondary-pubkey-file.
+	Secondaries []SecondaryNode `toml:"secondaries"`
+	// Number of secondaries that must have replicated a tree
+	// head, before it is signed. Zero means all secondaries.
+	ReplicationQuorum int    `toml:"replication-quorum"`
+	SthFile           string `toml:"sth-file"`
+	MaxRange          int    `toml:"max-range"`
+	EnableTiles       bool   `toml:"enable-tiles"`
+	// Upper bound on how long an add-leaf request may wait for
+	// the leaf to be published, if the client asks to wait using
+	// a "Prefer: wait=<seconds>" header. Zero disables waiting.
+	AddLeafMaxWait time.Duration `toml:"add-leaf-max-wait"`
+	// If non-zero, add-leaf responses with status 202 include a
+	// receipt signed by the log key, promising that the leaf is
+	// published within this time.
+	MaxMergeDelay time.Duration `toml:"max-merge-delay"`
+	// Maximum number of accepted leaves tracked until they are
+	// published, for pending-leaf and receipt deadline metrics.
+	LeafTrackerMaxLeaves int `toml:"leaf-tracker-max-leaves"`
+	// If set, a new tree head is published only once it has
+	// cosignatures satisfying the policy's witness quorum.
+	RequireWitnessQuorum bool `toml:"require-witness-quorum"`
+	// Bounds on cosignature timestamps, relative to the time of
+	// rotation. Zero means no bound.
+	CosignatureMaxPast   time.Duration `toml:"cosignature-max-past"`
+	CosignatureMaxFuture time.Duration `toml:"cosignature-max-future"`
+}
+
+// Secondary node, as seen by the primary.
+type SecondaryNode struct {
+	Name       string `toml:"name"`
+	URL        string `toml:"url"`
+	PubkeyFile string `toml:"pubkey-file"`
 }
 
 // Secondary Config
@@ -37,6 +108,7 @@ type Config struct {
 	TrillianRpcServer  string        `toml:"trillian-rpc-server"`
 	Backend            string        `toml:"backend"`
 	TrillianTreeIDFile string        `toml:"trillian-tree-id-file"`
+	LocalDbDir         string        `toml:"local-db-dir"`
 	KeyFile            string        `toml:"key-file"`
 	Primary            `toml:"primary"`
 	Secondary          `toml:"secondary"`
@@ -51,19 +123,41 @@ func NewConfig() *Config {
 		Backend:            "trillian",
 		Prefix:             "",
 		TrillianTreeIDFile: "/var/lib/sigsum-log/tree-id",
+		LocalDbDir:         "/var/lib/sigsum-log/db",
 		Timeout:            time.Second * 10,
 		KeyFile:            "",
 		Interval:           time.Second * 10,
 		LogFile:            "",
 		LogLevel:           "info",
 		Primary: Primary{
-			PolicyFile:          "",
-			RateLimitFile:       "",
-			AllowTestDomain:     false,
-			SecondaryURL:        "",
-			SecondaryPubkeyFile: "",
-			SthFile:             "/var/lib/sigsum-log/sth",
-			MaxRange:            512,
+			PolicyFile:             "",
+			RateLimitFile:          "",
+			RateLimitMode:          "enforce",
+			RateLimitStateFile:     "",
+			RateLimitStore:         "local",
+			RateLimitEtcdPrefix:    "/sigsum-log/rate-limit/",
+			ReadRateLimit:          0,
+			ReadRateLimitBurst:     0,
+			MaxInFlight:            0,
+			ProxyProtocol:          false,
+			SubmitTokenKeyFile:     "",
+			SubmitTokenDns:         true,
+			SubmitTokenResolver:    "",
+			SubmitTokenCacheTTL:    10 * time.Minute,
+			SubmitTokenNegativeTTL: time.Minute,
+			AllowTestDomain:        false,
+			SecondaryURL:           "",
+			SecondaryPubkeyFile:    "",
+			ReplicationQuorum:      0,
+			SthFile:                "/var/lib/sigsum-log/sth",
+			MaxRange:               512,
+			EnableTiles:            false,
+			AddLeafMaxWait:         0,
+			MaxMergeDelay:          0,
+			LeafTrackerMaxLeaves:   100000,
+			RequireWitnessQuorum:   false,
+			CosignatureMaxPast:     10 * time.Minute,
+			CosignatureMaxFuture:   10 * time.Minute,
 		},
 		Secondary: Secondary{
 			PrimaryURL: "",
@@ -106,9 +200,10 @@ func (c *Config) ServerFlags(set *getopt.Set) {
 	set.FlagLong(&c.ExternalEndpoint, "external-endpoint", 0, "TCP listen port for serving clients.", "host:port")
 	set.FlagLong(&c.InternalEndpoint, "internal-endpoint", 0, "Internal TCP listen port, for metrics and replication with other nodes.", "host:port")
 	set.FlagLong(&c.TrillianRpcServer, "trillian-rpc-server", 0, "TCP port for Trillian backend server.", "host:port")
-	set.FlagLong(&c.Backend, "backend", 0, "Either \"trillian\" (connect to an external Trillian server) or \"ephemeral\" (use in-memory backend, with NO persistent storage.")
+	set.FlagLong(&c.Backend, "backend", 0, "One of \"trillian\" (connect to an external Trillian server), \"local\" (store the tree in local files), or \"ephemeral\" (use in-memory backend, with NO persistent storage).")
 	set.FlagLong(&c.Prefix, "url-prefix", 0, "Optional URL prefix, preceding endpoint names such as /get-tree-head.", "string")
 	set.FlagLong(&c.TrillianTreeIDFile, "trillian-tree-id-file", 0, "Trillian backend tree identifier.", "file")
+	set.FlagLong(&c.LocalDbDir, "local-db-dir", 0, "Directory where the local backend stores the tree.", "directory")
 	set.FlagLong(&c.Timeout, "timeout", 0, "Timeout for outgoing requests.")
 	set.FlagLong(&c.KeyFile, "key-file", 0, "Key file (openssh format), either an unencrypted private key, or a public key (accessed via ssh-agent).", "file")
 	set.FlagLong(&c.Interval, "interval", 0, "Interval used to rotate the log's cosigned tree head.")
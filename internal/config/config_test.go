@@ -27,6 +27,18 @@ secondary-url = ""
 secondary-pubkey-file = ""
 sth-file = "/var/lib/sigsum-log/sth"
 
+replication-quorum = 2
+
+[[primary.secondaries]]
+name = "sec-a"
+url = "http://localhost:9092"
+pubkey-file = "/etc/sigsum/sec-a.pub"
+
+[[primary.secondaries]]
+name = "sec-b"
+url = "http://localhost:9093"
+pubkey-file = "/etc/sigsum/sec-b.pub"
+
 [secondary]
 primary-url = "http://localhost:9091"
 `
@@ -43,6 +55,10 @@ func TestReadConfig(t *testing.T) {
 	if conf.Secondary.PrimaryURL != "http://localhost:9091" {
 		t.Fatalf("Failed to parse primary configuration")
 	}
+	if conf.Primary.ReplicationQuorum != 2 || len(conf.Primary.Secondaries) != 2 ||
+		conf.Primary.Secondaries[1] != (SecondaryNode{Name: "sec-b", URL: "http://localhost:9093", PubkeyFile: "/etc/sigsum/sec-b.pub"}) {
+		t.Fatalf("Failed to parse secondaries configuration: %v", conf.Primary)
+	}
 }
 
 func TestReadExampleConfigFile(t *testing.T) {
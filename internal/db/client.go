@@ -11,6 +11,8 @@ import (
 type AddLeafStatus struct {
 	AlreadyExists bool
 	IsSequenced   bool
+	// Set by AddLeaves, if this leaf couldn't be added.
+	Err error
 }
 
 var ErrNotIncluded = errors.New("not included")
@@ -18,6 +20,11 @@ var ErrNotIncluded = errors.New("not included")
 // Client is an interface that interacts with a log's database backend
 type Client interface {
 	AddLeaf(context.Context, *types.Leaf, uint64) (AddLeafStatus, error)
+	// Adds a batch of leaves, returning the status of each, as
+	// for AddLeaf. Leaves repeated within the batch are reported
+	// as already existing. Backends that add leaves one at a
+	// time report failures per leaf, in the status Err field.
+	AddLeaves(context.Context, []types.Leaf, uint64) ([]AddLeafStatus, error)
 	AddSequencedLeaves(ctx context.Context, leaves []types.Leaf, index int64) error
 	GetTreeHead(context.Context) (types.TreeHead, error)
 	GetConsistencyProof(context.Context, *requests.ConsistencyProof) (types.ConsistencyProof, error)
@@ -0,0 +1,544 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	localLeafFile    = "leaves"
+	localHashFileFmt = "hashes-%02d"
+	// Enough levels for any tree with size < 2^64.
+	localMaxLevels = 64
+)
+
+// LocalDb implements the Client interface using plain files in a
+// local directory, with no external services. Leaves are stored in
+// an append-only file of fixed-size records. For each level of the
+// tree, the hashes of all complete subtrees at that level are stored
+// in a separate append-only file, where level 0 holds the leaf
+// hashes. Leaves are sequenced immediately when added.
+//
+// The leaf file is the authoritative state. The hash files are
+// derived data; on startup, any hashes missing due to a crash are
+// recomputed, and any partially written records are truncated. If
+// adding leaves fails, the files are truncated back to their previous
+// size.
+type LocalDb struct {
+	mu     sync.RWMutex
+	dir    string
+	leaves localFile
+	// Indexed by level.
+	hashes []localFile
+	size   uint64
+	// Maps leaf hash to leaf index.
+	index map[crypto.Hash]uint64
+	// Set if a failed write couldn't be rolled back. Then the
+	// files may be inconsistent with the in-memory state, and
+	// further writes are refused until the database is reopened.
+	failed error
+}
+
+// The file operations used by LocalDb, implemented by *os.File.
+type localFile interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Name() string
+	Stat() (fs.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OpenLocalDb opens the database stored in dir, creating the
+// directory and an empty tree if needed.
+func OpenLocalDb(dir string) (*LocalDb, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db := LocalDb{dir: dir, index: make(map[crypto.Hash]uint64)}
+	var err error
+	db.leaves, err = os.OpenFile(filepath.Join(dir, localLeafFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.recover(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Info("opened local database %q, tree size %d", dir, db.size)
+	return &db, nil
+}
+
+func (db *LocalDb) Close() error {
+	var err error
+	for _, f := range append(db.hashes, db.leaves) {
+		if f != nil {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+func (db *LocalDb) hashFile(level int) (localFile, error) {
+	for len(db.hashes) <= level {
+		f, err := os.OpenFile(filepath.Join(db.dir, fmt.Sprintf(localHashFileFmt, len(db.hashes))),
+			os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		db.hashes = append(db.hashes, f)
+	}
+	return db.hashes[level], nil
+}
+
+// Returns number of complete records in the file, after truncating
+// any trailing partial record.
+func truncateToRecords(f localFile, recordSize int64) (uint64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	count := info.Size() / recordSize
+	if info.Size() != count*recordSize {
+		log.Warning("truncating partial record at end of %q", f.Name())
+		if err := f.Truncate(count * recordSize); err != nil {
+			return 0, err
+		}
+	}
+	return uint64(count), nil
+}
+
+// Brings hash files in sync with the leaf file, and populates the
+// leaf index.
+func (db *LocalDb) recover() error {
+	size, err := truncateToRecords(db.leaves, int64(len(leafBlob{})))
+	if err != nil {
+		return err
+	}
+	db.size = size
+	for level := 0; level < localMaxLevels; level++ {
+		want := size >> level
+		if want == 0 {
+			// Remove files for levels that shouldn't exist.
+			name := filepath.Join(db.dir, fmt.Sprintf(localHashFileFmt, level))
+			if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		f, err := db.hashFile(level)
+		if err != nil {
+			return err
+		}
+		have, err := truncateToRecords(f, crypto.HashSize)
+		if err != nil {
+			return err
+		}
+		if have > want {
+			log.Warning("discarding %d extra hashes at level %d", have-want, level)
+			if err := f.Truncate(int64(want) * crypto.HashSize); err != nil {
+				return err
+			}
+			have = want
+		}
+		for i := have; i < want; i++ {
+			var h crypto.Hash
+			if level == 0 {
+				blob, err := db.readLeafBlob(i)
+				if err != nil {
+					return err
+				}
+				h = merkle.HashLeafNode(blob[:])
+			} else {
+				left, err := db.readHash(level-1, 2*i)
+				if err != nil {
+					return err
+				}
+				right, err := db.readHash(level-1, 2*i+1)
+				if err != nil {
+					return err
+				}
+				h = merkle.HashInteriorNode(&left, &right)
+			}
+			if _, err := f.WriteAt(h[:], int64(i)*crypto.HashSize); err != nil {
+				return err
+			}
+		}
+		if have < want {
+			log.Info("recomputed %d hashes at level %d", want-have, level)
+			if err := f.Sync(); err != nil {
+				return err
+			}
+		}
+	}
+	for i := uint64(0); i < size; i++ {
+		h, err := db.readHash(0, i)
+		if err != nil {
+			return err
+		}
+		if _, ok := db.index[h]; ok {
+			return fmt.Errorf("corrupt database, duplicate leaf at index %d", i)
+		}
+		db.index[h] = i
+	}
+	return nil
+}
+
+func (db *LocalDb) readLeafBlob(i uint64) (leafBlob, error) {
+	var blob leafBlob
+	if _, err := db.leaves.ReadAt(blob[:], int64(i)*int64(len(blob))); err != nil {
+		return leafBlob{}, fmt.Errorf("reading leaf %d failed: %v", i, err)
+	}
+	return blob, nil
+}
+
+func (db *LocalDb) readHash(level int, i uint64) (crypto.Hash, error) {
+	var h crypto.Hash
+	if level >= len(db.hashes) {
+		return crypto.Hash{}, fmt.Errorf("internal error, no hashes at level %d", level)
+	}
+	if _, err := db.hashes[level].ReadAt(h[:], int64(i)*crypto.HashSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return crypto.Hash{}, fmt.Errorf("reading hash %d at level %d failed: %v", i, level, err)
+	}
+	return h, nil
+}
+
+// Appends leaves, which must already be checked for duplicates. On
+// failure, the database is rolled back to its previous size. Must be
+// called with write lock held.
+func (db *LocalDb) appendLeaves(blobs []leafBlob) error {
+	if db.failed != nil {
+		return fmt.Errorf("database must be reopened, after failed rollback: %v", db.failed)
+	}
+	size := db.size
+	if err := db.writeLeaves(blobs); err != nil {
+		if rollbackErr := db.rollback(size, blobs); rollbackErr != nil {
+			log.Error("rolling back local database to size %d failed: %v", size, rollbackErr)
+			db.failed = rollbackErr
+			return fmt.Errorf("%v, and rollback failed: %v", err, rollbackErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Restores the database to the given size, after blobs were partially
+// appended by writeLeaves. Since the hash files hold exactly the
+// complete subtrees, their sizes follow from the tree size.
+func (db *LocalDb) rollback(size uint64, blobs []leafBlob) error {
+	for _, blob := range blobs[:db.size-size] {
+		delete(db.index, merkle.HashLeafNode(blob[:]))
+	}
+	db.size = size
+	var err error
+	truncate := func(f localFile, fileSize int64) {
+		if err == nil {
+			err = f.Truncate(fileSize)
+		}
+		if err == nil {
+			err = f.Sync()
+		}
+	}
+	truncate(db.leaves, int64(size)*int64(len(leafBlob{})))
+	for level, f := range db.hashes {
+		truncate(f, int64(size>>level)*crypto.HashSize)
+	}
+	return err
+}
+
+// Writes the leaves, and updates size and index. The leaf data is
+// synced to disk before any hashes are written.
+func (db *LocalDb) writeLeaves(blobs []leafBlob) error {
+	buf := make([]byte, 0, len(blobs)*len(leafBlob{}))
+	for _, blob := range blobs {
+		buf = append(buf, blob[:]...)
+	}
+	if _, err := db.leaves.WriteAt(buf, int64(db.size)*int64(len(leafBlob{}))); err != nil {
+		return err
+	}
+	if err := db.leaves.Sync(); err != nil {
+		return err
+	}
+	maxLevel := 0
+	for _, blob := range blobs {
+		h := merkle.HashLeafNode(blob[:])
+		i := db.size
+		for level := 0; ; level++ {
+			f, err := db.hashFile(level)
+			if err != nil {
+				return err
+			}
+			if _, err := f.WriteAt(h[:], int64(i)*crypto.HashSize); err != nil {
+				return err
+			}
+			if level > maxLevel {
+				maxLevel = level
+			}
+			if i&1 == 0 {
+				break
+			}
+			left, err := db.readHash(level, i-1)
+			if err != nil {
+				return err
+			}
+			h = merkle.HashInteriorNode(&left, &h)
+			i >>= 1
+		}
+		db.index[merkle.HashLeafNode(blob[:])] = db.size
+		db.size++
+	}
+	for level := 0; level <= maxLevel; level++ {
+		if err := db.hashes[level].Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *LocalDb) AddLeaf(_ context.Context, leaf *types.Leaf, treeSize uint64) (AddLeafStatus, error) {
+	var blob leafBlob
+	copy(blob[:], leaf.ToBinary())
+	h := merkle.HashLeafNode(blob[:])
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if i, ok := db.index[h]; ok {
+		return AddLeafStatus{
+			AlreadyExists: true,
+			IsSequenced:   i < treeSize,
+		}, nil
+	}
+	if err := db.appendLeaves([]leafBlob{blob}); err != nil {
+		return AddLeafStatus{}, fmt.Errorf("adding leaf failed: %v", err)
+	}
+	return AddLeafStatus{}, nil
+}
+
+// AddLeaves appends all new leaves in a single write.
+func (db *LocalDb) AddLeaves(_ context.Context, leaves []types.Leaf, treeSize uint64) ([]AddLeafStatus, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	statuses := make([]AddLeafStatus, len(leaves))
+	var blobs []leafBlob
+	seen := make(map[crypto.Hash]bool)
+	for i, leaf := range leaves {
+		var blob leafBlob
+		copy(blob[:], leaf.ToBinary())
+		h := merkle.HashLeafNode(blob[:])
+		if index, ok := db.index[h]; ok {
+			statuses[i] = AddLeafStatus{
+				AlreadyExists: true,
+				IsSequenced:   index < treeSize,
+			}
+			continue
+		}
+		if seen[h] {
+			statuses[i] = AddLeafStatus{AlreadyExists: true}
+			continue
+		}
+		seen[h] = true
+		blobs = append(blobs, blob)
+	}
+	if len(blobs) > 0 {
+		if err := db.appendLeaves(blobs); err != nil {
+			return nil, fmt.Errorf("adding leaves failed: %v", err)
+		}
+	}
+	return statuses, nil
+}
+
+func (db *LocalDb) AddSequencedLeaves(_ context.Context, leaves []types.Leaf, index int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.size != uint64(index) {
+		return fmt.Errorf("incorrect index %d, tree size %d", index, db.size)
+	}
+	blobs := make([]leafBlob, len(leaves))
+	seen := make(map[crypto.Hash]bool)
+	for i, leaf := range leaves {
+		copy(blobs[i][:], leaf.ToBinary())
+		h := merkle.HashLeafNode(blobs[i][:])
+		if _, ok := db.index[h]; ok || seen[h] {
+			return fmt.Errorf("unexpected duplicate at index %d", index+int64(i))
+		}
+		seen[h] = true
+	}
+	return db.appendLeaves(blobs)
+}
+
+// Returns the root hash of the subtree of leaves in the range [start,
+// end), using stored hashes for complete subtrees.
+func (db *LocalDb) subtreeHash(start, end uint64) (crypto.Hash, error) {
+	n := end - start
+	if n == 0 {
+		return merkle.HashEmptyTree(), nil
+	}
+	if n&(n-1) == 0 && start%n == 0 {
+		level := bits.TrailingZeros64(n)
+		return db.readHash(level, start>>level)
+	}
+	k := splitPoint(n)
+	left, err := db.subtreeHash(start, start+k)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	right, err := db.subtreeHash(start+k, end)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	return merkle.HashInteriorNode(&left, &right), nil
+}
+
+// Largest power of two less than n, for n > 1.
+func splitPoint(n uint64) uint64 {
+	return uint64(1) << (bits.Len64(n-1) - 1)
+}
+
+// Inclusion path for leaf index in the range [start, end), ordered
+// from leaf to root, as specified in RFC 9162.
+func (db *LocalDb) inclusionPath(index, start, end uint64) ([]crypto.Hash, error) {
+	if end-start <= 1 {
+		return []crypto.Hash{}, nil
+	}
+	k := splitPoint(end - start)
+	var path []crypto.Hash
+	var sibling crypto.Hash
+	var err error
+	if index < start+k {
+		path, err = db.inclusionPath(index, start, start+k)
+		if err == nil {
+			sibling, err = db.subtreeHash(start+k, end)
+		}
+	} else {
+		path, err = db.inclusionPath(index, start+k, end)
+		if err == nil {
+			sibling, err = db.subtreeHash(start, start+k)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(path, sibling), nil
+}
+
+// Consistency subproof of the old size m, for the range [start,
+// end), as specified in RFC 9162.
+func (db *LocalDb) consistencyPath(m, start, end uint64, complete bool) ([]crypto.Hash, error) {
+	if m == end {
+		if complete {
+			return []crypto.Hash{}, nil
+		}
+		h, err := db.subtreeHash(start, end)
+		if err != nil {
+			return nil, err
+		}
+		return []crypto.Hash{h}, nil
+	}
+	k := splitPoint(end - start)
+	var path []crypto.Hash
+	var sibling crypto.Hash
+	var err error
+	if m <= start+k {
+		path, err = db.consistencyPath(m, start, start+k, complete)
+		if err == nil {
+			sibling, err = db.subtreeHash(start+k, end)
+		}
+	} else {
+		path, err = db.consistencyPath(m, start+k, end, false)
+		if err == nil {
+			sibling, err = db.subtreeHash(start, start+k)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(path, sibling), nil
+}
+
+func (db *LocalDb) GetTreeHead(_ context.Context) (types.TreeHead, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	rootHash, err := db.subtreeHash(0, db.size)
+	if err != nil {
+		return types.TreeHead{}, err
+	}
+	return types.TreeHead{
+		Size:     db.size,
+		RootHash: rootHash,
+	}, nil
+}
+
+func (db *LocalDb) GetConsistencyProof(_ context.Context, req *requests.ConsistencyProof) (types.ConsistencyProof, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if req.OldSize > req.NewSize || req.NewSize > db.size {
+		return types.ConsistencyProof{}, fmt.Errorf("invalid consistency proof request: old size %d, new size %d, tree size %d",
+			req.OldSize, req.NewSize, db.size)
+	}
+	if req.OldSize == 0 || req.OldSize == req.NewSize {
+		return types.ConsistencyProof{}, nil
+	}
+	path, err := db.consistencyPath(req.OldSize, 0, req.NewSize, true)
+	if err != nil {
+		return types.ConsistencyProof{}, err
+	}
+	return types.ConsistencyProof{Path: path}, nil
+}
+
+func (db *LocalDb) GetInclusionProof(_ context.Context, req *requests.InclusionProof) (types.InclusionProof, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	index, ok := db.index[req.LeafHash]
+	if !ok || index >= req.Size {
+		return types.InclusionProof{}, ErrNotIncluded
+	}
+	if req.Size > db.size {
+		return types.InclusionProof{}, fmt.Errorf("tree size %d exceeds local size %d", req.Size, db.size)
+	}
+	path, err := db.inclusionPath(index, 0, req.Size)
+	if err != nil {
+		return types.InclusionProof{}, err
+	}
+	return types.InclusionProof{
+		LeafIndex: index,
+		Path:      path,
+	}, nil
+}
+
+func (db *LocalDb) GetLeaves(_ context.Context, req *requests.Leaves) ([]types.Leaf, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if req.StartIndex >= db.size || req.EndIndex > db.size || req.StartIndex >= req.EndIndex {
+		return nil, fmt.Errorf("out of range request: start %d, end %d, size %d",
+			req.StartIndex, req.EndIndex, db.size)
+	}
+	buf := make([]byte, (req.EndIndex-req.StartIndex)*uint64(len(leafBlob{})))
+	if _, err := db.leaves.ReadAt(buf, int64(req.StartIndex)*int64(len(leafBlob{}))); err != nil {
+		return nil, fmt.Errorf("reading leaves failed: %v", err)
+	}
+	list := make([]types.Leaf, req.EndIndex-req.StartIndex)
+	for i := range list {
+		if err := list[i].FromBinary(buf[i*len(leafBlob{}) : (i+1)*len(leafBlob{})]); err != nil {
+			return nil, fmt.Errorf("invalid leaf %d: %v", req.StartIndex+uint64(i), err)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,317 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+func mustOpenLocalDb(t *testing.T, dir string) *LocalDb {
+	t.Helper()
+	db, err := OpenLocalDb(dir)
+	if err != nil {
+		t.Fatalf("OpenLocalDb failed: %v", err)
+	}
+	return db
+}
+
+func TestLocalAddLeaf(t *testing.T) {
+	leaves := newLeaves(2)
+	db := mustOpenLocalDb(t, t.TempDir())
+	defer db.Close()
+
+	for _, table := range []struct {
+		desc string
+		leaf *types.Leaf
+		size uint64
+		want AddLeafStatus
+	}{
+		{"new leaf", &leaves[0], 0, AddLeafStatus{}},
+		{"existing leaf", &leaves[0], 0, AddLeafStatus{AlreadyExists: true}},
+		{"sequenced leaf", &leaves[0], 1, AddLeafStatus{AlreadyExists: true, IsSequenced: true}},
+		// Corner case; this backend sequences leaves immediately.
+		{"second leaf", &leaves[1], 1, AddLeafStatus{}},
+	} {
+		status, err := db.AddLeaf(nil, table.leaf, table.size)
+		if err != nil {
+			t.Fatalf("AddLeaf failed in test: %q: %v", table.desc, err)
+		}
+		if status != table.want {
+			t.Errorf("got status %#v, wanted %#v in test: %q", status, table.want, table.desc)
+		}
+	}
+}
+
+func TestLocalAddLeaves(t *testing.T) {
+	leaves := newLeaves(3)
+	db := mustOpenLocalDb(t, t.TempDir())
+	defer db.Close()
+
+	for _, table := range []struct {
+		desc   string
+		leaves []types.Leaf
+		size   uint64
+		want   []AddLeafStatus
+	}{
+		{"duplicate in batch", []types.Leaf{leaves[0], leaves[1], leaves[0]}, 0,
+			[]AddLeafStatus{{}, {}, {AlreadyExists: true}}},
+		{"existing and new", []types.Leaf{leaves[1], leaves[0], leaves[2]}, 1,
+			[]AddLeafStatus{{AlreadyExists: true}, {AlreadyExists: true, IsSequenced: true}, {}}},
+	} {
+		statuses, err := db.AddLeaves(nil, table.leaves, table.size)
+		if err != nil {
+			t.Fatalf("AddLeaves failed in test: %q: %v", table.desc, err)
+		}
+		if !slices.Equal(statuses, table.want) {
+			t.Errorf("got statuses %#v, wanted %#v in test: %q", statuses, table.want, table.desc)
+		}
+	}
+	th, err := db.GetTreeHead(nil)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if th.Size != 3 {
+		t.Errorf("unexpected tree size %d, wanted 3", th.Size)
+	}
+}
+
+func TestLocalAddSequencedLeaves(t *testing.T) {
+	leaves := newLeaves(5)
+	db := mustOpenLocalDb(t, t.TempDir())
+	defer db.Close()
+	if _, err := db.AddLeaf(nil, &leaves[0], 0); err != nil {
+		t.Fatalf("AddLeaf of initial leaf failed: %v", err)
+	}
+	if err := db.AddSequencedLeaves(nil, leaves[1:], 0); err == nil {
+		t.Fatalf("AddSequencedLeaves with bad index unexpectedly succeeded")
+	}
+	if err := db.AddSequencedLeaves(nil, []types.Leaf{leaves[1], leaves[1]}, 1); err == nil {
+		t.Fatalf("AddSequencedLeaves with duplicate leaves unexpectedly succeeded")
+	}
+	if err := db.AddSequencedLeaves(nil, leaves[1:], 1); err != nil {
+		t.Fatalf("AddSequencedLeaves (1:5) failed: %v", err)
+	}
+	res, err := db.GetLeaves(nil, &requests.Leaves{StartIndex: 0, EndIndex: 5})
+	if err != nil {
+		t.Fatalf("GetLeaves failed: %v", err)
+	}
+	for i := range leaves {
+		if res[i] != leaves[i] {
+			t.Errorf("wrong leaf data for leaf %d: got %#v, wanted: %#v", i, res[i], leaves[i])
+		}
+	}
+}
+
+// Checks tree heads and proofs against the in-memory reference
+// implementation.
+func TestLocalProofs(t *testing.T) {
+	const n = 37
+	leaves := newLeaves(n)
+	db := mustOpenLocalDb(t, t.TempDir())
+	defer db.Close()
+	ref := NewMemoryDb()
+
+	treeHeads := []types.TreeHead{{Size: 0, RootHash: merkle.HashEmptyTree()}}
+	for i, leaf := range leaves {
+		if _, err := db.AddLeaf(nil, &leaf, 0); err != nil {
+			t.Fatalf("AddLeaf failed of leaf %d failed: %v", i, err)
+		}
+		if _, err := ref.AddLeaf(nil, &leaf, 0); err != nil {
+			t.Fatalf("AddLeaf to reference failed of leaf %d failed: %v", i, err)
+		}
+		th, err := db.GetTreeHead(nil)
+		if err != nil {
+			t.Fatalf("GetTreeHead failed after leaf %d: %v", i, err)
+		}
+		if refTh, _ := ref.GetTreeHead(nil); th != refTh {
+			t.Fatalf("unexpected tree head after leaf %d, got %v, wanted %v", i, th, refTh)
+		}
+		treeHeads = append(treeHeads, th)
+	}
+	for size := uint64(1); size <= n; size++ {
+		for i := uint64(0); i < size; i++ {
+			leafHash := merkle.HashLeafNode(leaves[i].ToBinary())
+			proof, err := db.GetInclusionProof(nil, &requests.InclusionProof{
+				LeafHash: leafHash,
+				Size:     size,
+			})
+			if err != nil {
+				t.Errorf("GetInclusionProof for leaf %d, size %d failed: %v", i, size, err)
+			} else if proof.LeafIndex != i {
+				t.Errorf("GetInclusionProof index: got %d, wanted %d", proof.LeafIndex, i)
+			} else if err := proof.Verify(&leafHash, &treeHeads[size]); err != nil {
+				t.Errorf("inclusion path for leaf %d, size %d is invalid: %v", i, size, err)
+			}
+		}
+	}
+	for oldSize := 0; oldSize <= n; oldSize++ {
+		for newSize := oldSize; newSize <= n; newSize++ {
+			proof, err := db.GetConsistencyProof(nil, &requests.ConsistencyProof{
+				OldSize: uint64(oldSize),
+				NewSize: uint64(newSize),
+			})
+			if err != nil {
+				t.Errorf("GetConsistencyProof failed for oldSize %d, newSize %d: %v", oldSize, newSize, err)
+			} else if err := proof.Verify(&treeHeads[oldSize], &treeHeads[newSize]); err != nil {
+				t.Errorf("consistent path for oldSize %d, newSize %d is invalid: %v", oldSize, newSize, err)
+			}
+		}
+	}
+}
+
+func TestLocalRecovery(t *testing.T) {
+	const n = 13
+	dir := t.TempDir()
+	leaves := newLeaves(n + 1)
+	db := mustOpenLocalDb(t, dir)
+	if err := db.AddSequencedLeaves(nil, leaves[:n], 0); err != nil {
+		t.Fatalf("AddSequencedLeaves failed: %v", err)
+	}
+	want, err := db.GetTreeHead(nil)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Simulate a crash after writing a partial leaf, and before
+	// writing all hashes.
+	f, err := os.OpenFile(filepath.Join(dir, localLeafFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(leaves[n].ToBinary()[:50]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := os.Truncate(filepath.Join(dir, "hashes-00"), 5*32+7); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "hashes-02")); err != nil {
+		t.Fatal(err)
+	}
+
+	db = mustOpenLocalDb(t, dir)
+	defer db.Close()
+	got, err := db.GetTreeHead(nil)
+	if err != nil {
+		t.Fatalf("GetTreeHead after reopen failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("unexpected tree head after reopen, got %v, wanted %v", got, want)
+	}
+	status, err := db.AddLeaf(nil, &leaves[3], n)
+	if err != nil || status != (AddLeafStatus{AlreadyExists: true, IsSequenced: true}) {
+		t.Errorf("leaf 3 not found after reopen, status %#v, err %v", status, err)
+	}
+	if _, err := db.AddLeaf(nil, &leaves[n], n); err != nil {
+		t.Fatalf("AddLeaf after reopen failed: %v", err)
+	}
+	res, err := db.GetLeaves(nil, &requests.Leaves{StartIndex: n, EndIndex: n + 1})
+	if err != nil {
+		t.Fatalf("GetLeaves after reopen failed: %v", err)
+	}
+	if res[0] != leaves[n] {
+		t.Errorf("unexpected leaf after reopen, got %#v, wanted %#v", res[0], leaves[n])
+	}
+}
+
+// Wraps a file, failing writes after a given number of writes, and
+// optionally all truncations.
+type failingFile struct {
+	localFile
+	writes       int
+	failTruncate bool
+}
+
+func (f *failingFile) WriteAt(b []byte, off int64) (int, error) {
+	if f.writes == 0 {
+		return 0, errors.New("injected write failure")
+	}
+	f.writes--
+	return f.localFile.WriteAt(b, off)
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.failTruncate {
+		return errors.New("injected truncate failure")
+	}
+	return f.localFile.Truncate(size)
+}
+
+func TestLocalRollback(t *testing.T) {
+	leaves := newLeaves(5)
+	for _, failTruncate := range []bool{false, true} {
+		dir := t.TempDir()
+		db := mustOpenLocalDb(t, dir)
+		if err := db.AddSequencedLeaves(nil, leaves[:3], 0); err != nil {
+			t.Fatalf("AddSequencedLeaves failed: %v", err)
+		}
+		want, err := db.GetTreeHead(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Leaf 3 is written completely, including a new hash
+		// file at level 2, and then writing leaf 4's hash
+		// fails.
+		hashes := db.hashes[0]
+		db.hashes[0] = &failingFile{localFile: hashes, writes: 1, failTruncate: failTruncate}
+		if _, err := db.AddLeaves(nil, leaves[3:], 3); err == nil {
+			t.Fatalf("failTruncate %v: AddLeaves succeeded, despite write failure", failTruncate)
+		}
+		db.hashes[0] = hashes
+
+		if failTruncate {
+			if _, err := db.AddLeaf(nil, &leaves[4], 3); err == nil {
+				t.Errorf("AddLeaf succeeded, after failed rollback")
+			}
+		} else {
+			if got, err := db.GetTreeHead(nil); err != nil || got != want {
+				t.Errorf("unexpected tree head after rollback, got %v, err %v, wanted %v", got, err, want)
+			}
+			if _, err := db.GetInclusionProof(nil, &requests.InclusionProof{
+				Size: 5, LeafHash: merkle.HashLeafNode(leaves[3].ToBinary()),
+			}); err != ErrNotIncluded {
+				t.Errorf("unexpected result for rolled back leaf, err %v", err)
+			}
+			for _, name := range []string{localLeafFile, "hashes-00", "hashes-01", "hashes-02"} {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				wantSize := map[string]int64{localLeafFile: 3 * int64(len(leafBlob{})), "hashes-00": 3 * 32, "hashes-01": 32, "hashes-02": 0}[name]
+				if info.Size() != wantSize {
+					t.Errorf("unexpected size %d of %q after rollback, wanted %d", info.Size(), name, wantSize)
+				}
+			}
+			if _, err := db.AddLeaves(nil, leaves[3:], 3); err != nil {
+				t.Fatalf("AddLeaves after rollback failed: %v", err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// After reopen, the database is consistent, and
+		// accepts writes.
+		db = mustOpenLocalDb(t, dir)
+		if _, err := db.AddLeaves(nil, leaves[3:], 3); err != nil {
+			t.Fatalf("failTruncate %v: AddLeaves after reopen failed: %v", failTruncate, err)
+		}
+		got, err := db.GetTreeHead(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Size != 5 {
+			t.Errorf("failTruncate %v: unexpected size %d after reopen", failTruncate, got.Size)
+		}
+		db.Close()
+	}
+}
@@ -40,6 +40,27 @@ func (db *MemoryDb) AddLeaf(_ context.Context, leaf *types.Leaf, treeSize uint64
 	return AddLeafStatus{}, nil
 }
 
+func (db *MemoryDb) AddLeaves(_ context.Context, leaves []types.Leaf, treeSize uint64) ([]AddLeafStatus, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	statuses := make([]AddLeafStatus, len(leaves))
+	for i, leaf := range leaves {
+		var blob leafBlob
+		copy(blob[:], leaf.ToBinary())
+		h := merkle.HashLeafNode(blob[:])
+		if !db.tree.AddLeafHash(&h) {
+			index, err := db.tree.GetLeafIndex(&h)
+			statuses[i] = AddLeafStatus{
+				AlreadyExists: true,
+				IsSequenced:   err == nil && index < treeSize,
+			}
+			continue
+		}
+		db.leafs = append(db.leafs, blob)
+	}
+	return statuses, nil
+}
+
 func (db *MemoryDb) AddSequencedLeaves(_ context.Context, leaves []types.Leaf, index int64) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
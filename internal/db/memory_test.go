@@ -2,6 +2,7 @@ package db
 
 import (
 	"encoding/binary"
+	"slices"
 	"testing"
 
 	"sigsum.org/sigsum-go/pkg/crypto"
@@ -36,6 +37,38 @@ func TestMemoryAddLeaf(t *testing.T) {
 	}
 }
 
+func TestMemoryAddLeaves(t *testing.T) {
+	leaves := newLeaves(3)
+	db := NewMemoryDb()
+
+	for _, table := range []struct {
+		desc   string
+		leaves []types.Leaf
+		size   uint64
+		want   []AddLeafStatus
+	}{
+		{"duplicate in batch", []types.Leaf{leaves[0], leaves[1], leaves[0]}, 0,
+			[]AddLeafStatus{{}, {}, {AlreadyExists: true}}},
+		{"existing and new", []types.Leaf{leaves[1], leaves[0], leaves[2]}, 1,
+			[]AddLeafStatus{{AlreadyExists: true}, {AlreadyExists: true, IsSequenced: true}, {}}},
+	} {
+		statuses, err := db.AddLeaves(nil, table.leaves, table.size)
+		if err != nil {
+			t.Fatalf("AddLeaves failed in test: %q: %v", table.desc, err)
+		}
+		if !slices.Equal(statuses, table.want) {
+			t.Errorf("got statuses %#v, wanted %#v in test: %q", statuses, table.want, table.desc)
+		}
+	}
+	th, err := db.GetTreeHead(nil)
+	if err != nil {
+		t.Fatalf("GetTreeHead failed: %v", err)
+	}
+	if th.Size != 3 {
+		t.Errorf("unexpected tree size %d, wanted 3", th.Size)
+	}
+}
+
 func TestMemoryAddSequencedLeaves(t *testing.T) {
 	leaves := newLeaves(5)
 	db := NewMemoryDb()
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/google/trillian"
@@ -35,6 +36,9 @@ type TreeType int
 const (
 	PrimaryTree TreeType = iota
 	SecondaryTree
+	// A secondary tree being promoted to primary, which may
+	// already have been converted by an interrupted promotion.
+	PromotedTree
 )
 
 // This is an error if it happens for a get-inclusion-proof request
@@ -42,6 +46,9 @@ const (
 // an inclusion proof for the very first leaf.
 var errEmptyInclusionProof = errors.New("not an inclusion proof: empty")
 
+// Maximum number of concurrent QueueLeaf requests for a batch.
+const maxConcurrentQueueLeaf = 16
+
 func (treeType TreeType) checkTrillianTreeType(trillianType trillian.TreeType) error {
 	switch treeType {
 	case PrimaryTree:
@@ -54,6 +61,11 @@ func (treeType TreeType) checkTrillianTreeType(trillianType trillian.TreeType) e
 			return fmt.Errorf("trillian tree of type %s, but must be of type PREORDERED_LOG for a Sigsum secondary",
 				trillianType.String())
 		}
+	case PromotedTree:
+		if trillianType != trillian.TreeType_PREORDERED_LOG && trillianType != trillian.TreeType_LOG {
+			return fmt.Errorf("trillian tree of type %s, but must be of type PREORDERED_LOG or LOG for promotion",
+				trillianType.String())
+		}
 	default:
 		panic(fmt.Sprintf("internal error, invalid tree type %d", treeType))
 	}
@@ -134,6 +146,31 @@ func (c *TrillianClient) AddLeaf(ctx context.Context, leaf *types.Leaf, treeSize
 	}
 }
 
+// AddLeaves queues each leaf using concurrent requests, since
+// Trillian's api has no batch version of QueueLeaf. A failed request
+// is reported in the status of that leaf only, since other leaves
+// may already have been queued.
+func (c *TrillianClient) AddLeaves(ctx context.Context, leaves []types.Leaf, treeSize uint64) ([]AddLeafStatus, error) {
+	statuses := make([]AddLeafStatus, len(leaves))
+	sem := make(chan struct{}, maxConcurrentQueueLeaf)
+	var wg sync.WaitGroup
+	for i := range leaves {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			status, err := c.AddLeaf(ctx, &leaves[i], treeSize)
+			if err != nil {
+				status = AddLeafStatus{Err: err}
+			}
+			statuses[i] = status
+		}()
+	}
+	wg.Wait()
+	return statuses, nil
+}
+
 // AddSequencedLeaves adds a set of already sequenced leaves to the tree.
 func (c *TrillianClient) AddSequencedLeaves(ctx context.Context, leaves []types.Leaf, index int64) error {
 	trilLeaves := make([]*trillian.LogLeaf, len(leaves))
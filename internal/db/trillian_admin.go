@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/trillian"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// PromoteTrillianTree converts the secondary's Trillian tree, of type
+// PREORDERED_LOG, to a tree of type LOG, as needed for a primary. The
+// tree is frozen while changing the type, and then made active
+// again. Each step is verified by reading back the tree, and steps
+// already done by an interrupted promotion are skipped.
+func PromoteTrillianTree(target string, timeout time.Duration, treeIdFile string) error {
+	treeId, err := readTreeId(treeIdFile)
+	if err != nil {
+		return fmt.Errorf("failed to read tree id: %v", err)
+	}
+	conn, err := grpc.Dial(target,
+		grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithTimeout(timeout))
+	if err != nil {
+		return fmt.Errorf("connection to trillian failed: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return promoteTree(ctx, trillian.NewTrillianAdminClient(conn), int64(treeId))
+}
+
+func promoteTree(ctx context.Context, admin trillian.TrillianAdminClient, treeId int64) error {
+	tree, err := admin.GetTree(ctx, &trillian.GetTreeRequest{TreeId: treeId})
+	if err != nil {
+		return err
+	}
+	if err := PromotedTree.checkTrillianTreeType(tree.TreeType); err != nil {
+		return err
+	}
+	converted := tree.TreeType == trillian.TreeType_LOG
+	switch tree.TreeState {
+	case trillian.TreeState_ACTIVE:
+		if converted {
+			// E.g., only the response to the final
+			// update was lost.
+			log.Info("trillian tree %d already promoted", treeId)
+			return nil
+		}
+		if err := updateTree(ctx, admin, &trillian.Tree{TreeId: treeId, TreeState: trillian.TreeState_FROZEN}, "tree_state"); err != nil {
+			return fmt.Errorf("freezing tree failed: %v", err)
+		}
+	case trillian.TreeState_FROZEN:
+		// Left frozen by a previous attempt.
+	default:
+		return fmt.Errorf("unexpected trillian tree state %s", tree.TreeState.String())
+	}
+	if converted {
+		log.Info("trillian tree %d already of type LOG", treeId)
+	} else if err := updateTree(ctx, admin, &trillian.Tree{TreeId: treeId, TreeType: trillian.TreeType_LOG}, "tree_type"); err != nil {
+		return fmt.Errorf("changing tree type failed: %v", err)
+	}
+	if err := updateTree(ctx, admin, &trillian.Tree{TreeId: treeId, TreeState: trillian.TreeState_ACTIVE}, "tree_state"); err != nil {
+		return fmt.Errorf("unfreezing tree failed: %v", err)
+	}
+	return nil
+}
+
+// Updates the single field identified by path, and checks that the
+// tree read back has the new value.
+func updateTree(ctx context.Context, admin trillian.TrillianAdminClient, update *trillian.Tree, path string) error {
+	if _, err := admin.UpdateTree(ctx, &trillian.UpdateTreeRequest{
+		Tree:       update,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{path}},
+	}); err != nil {
+		return err
+	}
+	tree, err := admin.GetTree(ctx, &trillian.GetTreeRequest{TreeId: update.TreeId})
+	if err != nil {
+		return err
+	}
+	switch path {
+	case "tree_state":
+		if tree.TreeState != update.TreeState {
+			return fmt.Errorf("tree state is %s after update, expected %s",
+				tree.TreeState.String(), update.TreeState.String())
+		}
+	case "tree_type":
+		if tree.TreeType != update.TreeType {
+			return fmt.Errorf("tree type is %s after update, expected %s",
+				tree.TreeType.String(), update.TreeType.String())
+		}
+	default:
+		panic(fmt.Sprintf("internal error, unexpected update path %q", path))
+	}
+	log.Info("updated trillian tree %d: %s", update.TreeId, path)
+	return nil
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/trillian"
+	"google.golang.org/grpc"
+)
+
+// Fake admin client, keeping a single tree. Methods not needed for
+// promotion are left unimplemented.
+type fakeAdminClient struct {
+	trillian.TrillianAdminClient
+	treeId    int64
+	treeType  trillian.TreeType
+	treeState trillian.TreeState
+	updates   []string
+	// If non-zero, the number of the update to fail, counting
+	// from 1, and whether or not it's applied before failing.
+	fail    int
+	applied bool
+}
+
+func (c *fakeAdminClient) GetTree(_ context.Context, req *trillian.GetTreeRequest, _ ...grpc.CallOption) (*trillian.Tree, error) {
+	if req.TreeId != c.treeId {
+		return nil, fmt.Errorf("unknown tree %d", req.TreeId)
+	}
+	return &trillian.Tree{TreeId: c.treeId, TreeType: c.treeType, TreeState: c.treeState}, nil
+}
+
+func (c *fakeAdminClient) UpdateTree(_ context.Context, req *trillian.UpdateTreeRequest, _ ...grpc.CallOption) (*trillian.Tree, error) {
+	if c.fail > 0 {
+		c.fail--
+		if c.fail == 0 && !c.applied {
+			return nil, fmt.Errorf("update failed")
+		}
+	}
+	for _, path := range req.UpdateMask.Paths {
+		switch path {
+		case "tree_state":
+			c.treeState = req.Tree.TreeState
+		case "tree_type":
+			if c.treeState != trillian.TreeState_FROZEN {
+				return nil, fmt.Errorf("tree type can only be changed on a frozen tree")
+			}
+			c.treeType = req.Tree.TreeType
+		default:
+			return nil, fmt.Errorf("unsupported update path %q", path)
+		}
+		c.updates = append(c.updates, path)
+	}
+	if c.fail == 0 && c.applied {
+		// Response lost.
+		c.applied = false
+		return nil, fmt.Errorf("update failed")
+	}
+	return c.GetTree(nil, &trillian.GetTreeRequest{TreeId: c.treeId})
+}
+
+func TestPromoteTree(t *testing.T) {
+	for _, table := range []struct {
+		desc      string
+		treeType  trillian.TreeType
+		treeState trillian.TreeState
+		wantErr   bool
+		updates   int
+	}{
+		{"active", trillian.TreeType_PREORDERED_LOG, trillian.TreeState_ACTIVE, false, 3},
+		{"frozen", trillian.TreeType_PREORDERED_LOG, trillian.TreeState_FROZEN, false, 2},
+		{"frozen and converted", trillian.TreeType_LOG, trillian.TreeState_FROZEN, false, 1},
+		{"already promoted", trillian.TreeType_LOG, trillian.TreeState_ACTIVE, false, 0},
+		{"draining", trillian.TreeType_PREORDERED_LOG, trillian.TreeState_DRAINING, true, 0},
+	} {
+		admin := fakeAdminClient{treeId: 17, treeType: table.treeType, treeState: table.treeState}
+		err := promoteTree(context.Background(), &admin, 17)
+		if table.wantErr {
+			if err == nil {
+				t.Errorf("%s: unexpected success", table.desc)
+			}
+		} else if err != nil {
+			t.Errorf("%s: failed: %v", table.desc, err)
+		} else if admin.treeType != trillian.TreeType_LOG || admin.treeState != trillian.TreeState_ACTIVE {
+			t.Errorf("%s: unexpected tree after promotion: type %s, state %s", table.desc,
+				admin.treeType.String(), admin.treeState.String())
+		}
+		if got := len(admin.updates); got != table.updates {
+			t.Errorf("%s: unexpected number of updates, got %d (%v), wanted %d", table.desc, got, admin.updates, table.updates)
+		}
+	}
+}
+
+func TestPromoteTreeResume(t *testing.T) {
+	for fail := 1; fail <= 3; fail++ {
+		for _, applied := range []bool{false, true} {
+			admin := fakeAdminClient{
+				treeId:    17,
+				treeType:  trillian.TreeType_PREORDERED_LOG,
+				treeState: trillian.TreeState_ACTIVE,
+				fail:      fail,
+				applied:   applied,
+			}
+			if err := promoteTree(context.Background(), &admin, 17); err == nil {
+				t.Errorf("fail %d, applied %v: unexpected success", fail, applied)
+				continue
+			}
+			if err := promoteTree(context.Background(), &admin, 17); err != nil {
+				t.Errorf("fail %d, applied %v: resuming failed: %v", fail, applied, err)
+			} else if admin.treeType != trillian.TreeType_LOG || admin.treeState != trillian.TreeState_ACTIVE {
+				t.Errorf("fail %d, applied %v: unexpected tree after promotion: type %s, state %s", fail, applied,
+					admin.treeType.String(), admin.treeState.String())
+			}
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/google/trillian"
 	ttypes "github.com/google/trillian/types"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	mocksTrillian "sigsum.org/log-go/internal/mocks/trillian"
@@ -89,6 +90,40 @@ func TestAddLeaf(t *testing.T) {
 	}
 }
 
+func TestAddLeaves(t *testing.T) {
+	leaves := newLeaves(3)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	logClient := mocksTrillian.NewMockTrillianLogClient(ctrl)
+	// Second leaf fails, others are queued.
+	logClient.EXPECT().QueueLeaf(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, req *trillian.QueueLeafRequest, _ ...grpc.CallOption) (*trillian.QueueLeafResponse, error) {
+			if bytes.Equal(req.Leaf.LeafValue, leaves[1].ToBinary()) {
+				return nil, fmt.Errorf("something went wrong")
+			}
+			return nil, nil
+		}).Times(len(leaves))
+	logClient.EXPECT().GetInclusionProofByHash(gomock.Any(), gomock.Any()).Return(
+		nil, status.Error(codes.NotFound, "not found")).Times(len(leaves) - 1)
+	client := TrillianClient{logClient: logClient}
+
+	statuses, err := client.AddLeaves(context.Background(), leaves, 1)
+	if err != nil {
+		t.Fatalf("AddLeaves failed: %v", err)
+	}
+	if len(statuses) != len(leaves) {
+		t.Fatalf("got %d statuses, wanted %d", len(statuses), len(leaves))
+	}
+	for i, status := range statuses {
+		if got, want := status.Err != nil, i == 1; got != want {
+			t.Errorf("unexpected error status for leaf %d: %v", i, status.Err)
+		}
+		if status.AlreadyExists || status.IsSequenced {
+			t.Errorf("unexpected status for leaf %d: %#v", i, status)
+		}
+	}
+}
+
 func TestGetTreeHead(t *testing.T) {
 	// valid root
 	root := &ttypes.LogRootV1{
@@ -0,0 +1,209 @@
+// Package leafTracker keeps track of accepted leaves until they are
+// included in a published tree head.
+package leafTracker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/state"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+)
+
+// Number of leaves to request at a time, when looking for newly
+// published leaves.
+const getLeavesChunk = 512
+
+// How often pending leaves are recorded, also when no tree heads
+// are published.
+const metricsInterval = 10 * time.Second
+
+type Metrics interface {
+	// Records a leaf that wasn't published before its deadline.
+	// Called at most once per leaf.
+	RecordDeadlineViolation()
+	// Records the time from a leaf was first accepted until it
+	// was published.
+	RecordPublished(delay time.Duration)
+	// Records the number of leaves not yet published, and the
+	// time since the oldest of them was accepted.
+	RecordPending(count int, oldest time.Duration)
+}
+
+type entry struct {
+	accepted time.Time
+	// Zero if no deadline was promised.
+	deadline time.Time
+	// Set when the deadline violation has been recorded.
+	overdue bool
+}
+
+type Tracker struct {
+	maxLeaves int
+	metrics   Metrics
+
+	// Protects leaves.
+	sync.Mutex
+	leaves map[crypto.Hash]*entry
+}
+
+// New creates a tracker for at most maxLeaves unpublished leaves at
+// a time. Leaves added when the tracker is full are not tracked.
+func New(maxLeaves int, metrics Metrics) *Tracker {
+	return &Tracker{
+		maxLeaves: maxLeaves,
+		metrics:   metrics,
+		leaves:    make(map[crypto.Hash]*entry),
+	}
+}
+
+// Add starts tracking a leaf, accepted at the given time, that
+// should be published before the deadline, if non-zero. To not miss
+// its publication, the leaf must be added before it is queued. A
+// leaf already tracked keeps its original times, and gets the
+// deadline only if it had none. Returns nil if the tracker is full,
+// otherwise a function to stop tracking the leaf, if it turns out
+// not to be queued, or to be published already; it does nothing if
+// the leaf was already tracked.
+func (t *Tracker) Add(leafHash *crypto.Hash, accepted, deadline time.Time) func() {
+	t.Lock()
+	defer t.Unlock()
+	if e, ok := t.leaves[*leafHash]; ok {
+		if e.deadline.IsZero() {
+			e.deadline = deadline
+		}
+		return func() {}
+	}
+	if len(t.leaves) >= t.maxLeaves {
+		return nil
+	}
+	e := &entry{accepted: accepted, deadline: deadline}
+	h := *leafHash
+	t.leaves[h] = e
+	return func() { t.forget(h, e) }
+}
+
+// Lookup returns the time a tracked leaf was first accepted, and
+// its deadline, if any.
+func (t *Tracker) Lookup(leafHash *crypto.Hash) (accepted, deadline time.Time, ok bool) {
+	t.Lock()
+	defer t.Unlock()
+	e, ok := t.leaves[*leafHash]
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return e.accepted, e.deadline, true
+}
+
+func (t *Tracker) forget(leafHash crypto.Hash, e *entry) {
+	t.Lock()
+	defer t.Unlock()
+	if t.leaves[leafHash] == e {
+		delete(t.leaves, leafHash)
+	}
+}
+
+func (t *Tracker) checkDeadline(e *entry, now time.Time) {
+	if !e.deadline.IsZero() && now.After(e.deadline) && !e.overdue {
+		e.overdue = true
+		t.metrics.RecordDeadlineViolation()
+	}
+}
+
+// Stops tracking leaves that are now published, at the given time.
+func (t *Tracker) published(leafHashes []crypto.Hash, now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	for _, h := range leafHashes {
+		e, ok := t.leaves[h]
+		if !ok {
+			continue
+		}
+		t.checkDeadline(e, now)
+		t.metrics.RecordPublished(now.Sub(e.accepted))
+		delete(t.leaves, h)
+	}
+}
+
+// Records violations for leaves that are past their deadline, but
+// not yet published.
+func (t *Tracker) checkDeadlines(now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	for _, e := range t.leaves {
+		t.checkDeadline(e, now)
+	}
+}
+
+// Records number and age of pending leaves.
+func (t *Tracker) recordPending(now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	var oldest time.Duration
+	for _, e := range t.leaves {
+		oldest = max(oldest, now.Sub(e.accepted))
+	}
+	t.metrics.RecordPending(len(t.leaves), oldest)
+}
+
+// Returns the hashes of the leaves in the range [start, end).
+func leafHashes(ctx context.Context, client db.Client, start, end uint64) ([]crypto.Hash, error) {
+	var hashes []crypto.Hash
+	for start < end {
+		leaves, err := client.GetLeaves(ctx, &requests.Leaves{
+			StartIndex: start,
+			EndIndex:   min(end, start+getLeavesChunk),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, leaf := range leaves {
+			hashes = append(hashes, merkle.HashLeafNode(leaf.ToBinary()))
+		}
+		start += uint64(len(leaves))
+	}
+	return hashes, nil
+}
+
+// Run watches tree heads published by the state manager, and stops
+// tracking leaves as they are published, until ctx is cancelled.
+func (t *Tracker) Run(ctx context.Context, sm state.StateManager, client db.Client, timeout time.Duration) {
+	ticker := time.NewTicker(metricsInterval)
+	defer ticker.Stop()
+
+	next := sm.NextPublish()
+	size := sm.CosignedTreeHead().Size
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.checkDeadlines(now)
+			t.recordPending(now)
+			continue
+		case <-next:
+		}
+		next = sm.NextPublish()
+		newSize := sm.CosignedTreeHead().Size
+		now := time.Now()
+		if newSize > size {
+			getCtx, cancel := context.WithTimeout(ctx, timeout)
+			hashes, err := leafHashes(getCtx, client, size, newSize)
+			cancel()
+			if err != nil {
+				// Retried at next publish.
+				log.Warning("failed to get published leaves: %v", err)
+			} else {
+				t.published(hashes, now)
+				size = newSize
+			}
+		}
+		t.checkDeadlines(now)
+		t.recordPending(now)
+	}
+}
@@ -0,0 +1,175 @@
+package leafTracker
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"sigsum.org/log-go/internal/db"
+	mocksState "sigsum.org/log-go/internal/mocks/state"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+type countingMetrics struct {
+	violations int
+	delays     []time.Duration
+	pending    int
+	oldest     time.Duration
+}
+
+func (m *countingMetrics) RecordDeadlineViolation() {
+	m.violations++
+}
+
+func (m *countingMetrics) RecordPublished(delay time.Duration) {
+	m.delays = append(m.delays, delay)
+}
+
+func (m *countingMetrics) RecordPending(count int, oldest time.Duration) {
+	m.pending, m.oldest = count, oldest
+}
+
+func (t *Tracker) size() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.leaves)
+}
+
+func TestTracker(t *testing.T) {
+	var metrics countingMetrics
+	tracker := New(3, &metrics)
+	now := time.Unix(1000, 0)
+	for i := byte(1); i <= 3; i++ {
+		if tracker.Add(&crypto.Hash{i}, now, now.Add(time.Duration(i)*time.Minute)) == nil {
+			t.Fatalf("adding leaf %d failed", i)
+		}
+	}
+	if tracker.Add(&crypto.Hash{4}, now, now.Add(time.Minute)) != nil {
+		t.Errorf("leaf added to full tracker")
+	}
+	// Already tracked, keeps original deadline.
+	forget := tracker.Add(&crypto.Hash{1}, now, now.Add(time.Hour))
+	if forget == nil {
+		t.Fatalf("adding tracked leaf failed")
+	}
+	if accepted, deadline, ok := tracker.Lookup(&crypto.Hash{1}); !ok || accepted != now || deadline != now.Add(time.Minute) {
+		t.Errorf("unexpected lookup result: %v, %v, %v", accepted, deadline, ok)
+	}
+	// Doesn't forget the original entry.
+	forget()
+	if got := tracker.size(); got != 3 {
+		t.Errorf("unexpected number of tracked leaves, got %d, wanted 3", got)
+	}
+
+	for _, table := range []struct {
+		desc       string
+		delay      time.Duration
+		published  []crypto.Hash
+		violations int
+		size       int
+	}{
+		{"in time", 30 * time.Second, []crypto.Hash{{1}, {4}}, 0, 2},
+		{"overdue", 130 * time.Second, nil, 1, 2},
+		{"published late", 150 * time.Second, []crypto.Hash{{2}}, 1, 1},
+		{"published late, not seen overdue", 200 * time.Second, []crypto.Hash{{3}}, 2, 0},
+	} {
+		at := now.Add(table.delay)
+		tracker.published(table.published, at)
+		tracker.checkDeadlines(at)
+		if metrics.violations != table.violations {
+			t.Errorf("%s: unexpected violations, got %d, wanted %d", table.desc, metrics.violations, table.violations)
+		}
+		if got := tracker.size(); got != table.size {
+			t.Errorf("%s: unexpected number of tracked leaves, got %d, wanted %d", table.desc, got, table.size)
+		}
+	}
+}
+
+func TestTrackerForget(t *testing.T) {
+	tracker := New(1, nil)
+	now := time.Unix(1000, 0)
+	forget := tracker.Add(&crypto.Hash{1}, now, time.Time{})
+	forget()
+	if got := tracker.size(); got != 0 {
+		t.Errorf("forgotten leaf still tracked")
+	}
+	// Must not forget a new entry for the same leaf.
+	tracker.Add(&crypto.Hash{1}, now, time.Time{})
+	forget()
+	if got := tracker.size(); got != 1 {
+		t.Errorf("leaf forgotten by stale function")
+	}
+}
+
+func TestTrackerPending(t *testing.T) {
+	var metrics countingMetrics
+	tracker := New(10, &metrics)
+	now := time.Unix(1000, 0)
+	tracker.Add(&crypto.Hash{1}, now, time.Time{})
+	tracker.Add(&crypto.Hash{2}, now.Add(time.Minute), time.Time{})
+	// Deadline is set, if the leaf had none.
+	tracker.Add(&crypto.Hash{2}, now.Add(2*time.Minute), now.Add(3*time.Minute))
+
+	tracker.recordPending(now.Add(5 * time.Minute))
+	if metrics.pending != 2 || metrics.oldest != 5*time.Minute {
+		t.Errorf("unexpected pending leaves, got %d, oldest %v", metrics.pending, metrics.oldest)
+	}
+	tracker.published([]crypto.Hash{{1}, {2}}, now.Add(10*time.Minute))
+	if !slices.Equal(metrics.delays, []time.Duration{10 * time.Minute, 9 * time.Minute}) {
+		t.Errorf("unexpected time to publish: %v", metrics.delays)
+	}
+	if metrics.violations != 1 {
+		t.Errorf("unexpected violations, got %d, wanted 1", metrics.violations)
+	}
+	tracker.recordPending(now.Add(10 * time.Minute))
+	if metrics.pending != 0 || metrics.oldest != 0 {
+		t.Errorf("unexpected pending leaves, got %d, oldest %v", metrics.pending, metrics.oldest)
+	}
+}
+
+func TestRun(t *testing.T) {
+	client := db.NewMemoryDb()
+	leaf := types.Leaf{Checksum: crypto.Hash{1}}
+	if _, err := client.AddLeaf(context.Background(), &leaf, 0); err != nil {
+		t.Fatal(err)
+	}
+	leafHash := merkle.HashLeafNode(leaf.ToBinary())
+
+	var metrics countingMetrics
+	tracker := New(10, &metrics)
+	tracker.Add(&leafHash, time.Now(), time.Now().Add(time.Hour))
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	published := make(chan struct{})
+	close(published)
+	stateman := mocksState.NewMockStateManager(ctrl)
+	stateman.EXPECT().NextPublish().Return(published)
+	stateman.EXPECT().NextPublish().Return(make(chan struct{})).AnyTimes()
+	stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{})
+	stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{
+		SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 1}},
+	}).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tracker.Run(ctx, stateman, client, time.Second)
+		close(done)
+	}()
+	for i := 0; tracker.size() > 0; i++ {
+		if i == 100 {
+			t.Fatalf("published leaf still tracked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if metrics.violations != 0 {
+		t.Errorf("unexpected deadline violations: %d", metrics.violations)
+	}
+}
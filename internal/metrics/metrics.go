@@ -10,6 +10,9 @@ import (
 	"github.com/google/trillian/monitoring"
 	"github.com/google/trillian/monitoring/prometheus"
 
+	"sigsum.org/log-go/internal/leaf-tracker"
+	"sigsum.org/log-go/internal/rate-limit"
+	"sigsum.org/log-go/internal/token-verifier"
 	"sigsum.org/log-go/internal/witness"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/server"
@@ -56,6 +59,8 @@ type witnessMetrics struct {
 	checkpointLatency  monitoring.Histogram // latency of successful checkpoint requests (200; 200 after 409 retry)
 	quorum             monitoring.Counter   // number of witness quorum attempts (grouped by success/failure)
 	quorumLatency      monitoring.Histogram // latency to reach quorum (not recorded if quorum is not reached)
+	publishedAge       monitoring.Gauge     // time since the published cosigned tree head was published
+	health             monitoring.Gauge     // health of each witness (0 healthy, 1 degraded, 2 down)
 }
 
 func (m *witnessMetrics) RecordCheckpointRequest(witnessID string, retried bool, err error, elapsed time.Duration) {
@@ -63,7 +68,9 @@ func (m *witnessMetrics) RecordCheckpointRequest(witnessID string, retried bool,
 	status := "200"
 	if err != nil {
 		var apiErr *api.Error
-		if errors.As(err, &apiErr) {
+		if errors.Is(err, witness.ErrCosignatureTimestamp) {
+			status = "bad-timestamp"
+		} else if errors.As(err, &apiErr) {
 			status = strconv.Itoa(apiErr.StatusCode())
 		} else {
 			status = "other"
@@ -83,6 +90,15 @@ func (m *witnessMetrics) RecordQuorum(haveQuorum bool, d time.Duration) {
 	}
 }
 
+func (m *witnessMetrics) RecordPublishedAge(age time.Duration) {
+	m.publishedAge.Set(age.Seconds())
+}
+
+func (m *witnessMetrics) RecordWitnessHealth(witnessID string, health witness.Health) {
+	name := strings.TrimPrefix(strings.TrimPrefix(witnessID, "https://"), "http://")
+	m.health.Set(float64(health), name)
+}
+
 func NewWitnessMetrics() witness.WitnessMetrics {
 	mf := newMetricFactory()
 	// Interval 1ms to 10s, with thresholds roughly a factor
@@ -94,5 +110,78 @@ func NewWitnessMetrics() witness.WitnessMetrics {
 		checkpointLatency:  mf.NewHistogramWithBuckets("witness_checkpoint_request_latency", "witness add-checkpoint latency on success", buckets, "witness"),
 		quorum:             mf.NewCounter("witness_quorum_total", "number of witness quorum attempts", "status"),
 		quorumLatency:      mf.NewHistogramWithBuckets("witness_quorum_latency", "witness quorum latency", buckets),
+		health:             mf.NewGauge("witness_health", "witness health: 0 healthy, 1 degraded, 2 down", "witness"),
+		publishedAge:       mf.NewGauge("cosigned_tree_head_age_seconds", "time since the published cosigned tree head was published"),
+	}
+}
+
+type rateLimitMetrics struct {
+	shadowDenied monitoring.Counter // number of requests that would have been denied (grouped by bucket type and name)
+}
+
+func (m *rateLimitMetrics) RecordShadowDenied(bucket rateLimit.BucketType, name string) {
+	m.shadowDenied.Inc(bucket.String(), name)
+}
+
+func NewRateLimitMetrics() rateLimit.Metrics {
+	mf := newMetricFactory()
+	return &rateLimitMetrics{
+		shadowDenied: mf.NewCounter("rate_limit_shadow_denied_total", "number of add-leaf requests that would have been denied, in shadow mode", "bucket", "name"),
+	}
+}
+
+type tokenMetrics struct {
+	lookups       monitoring.Counter   // number of DNS lookups (grouped by result)
+	lookupLatency monitoring.Histogram // latency of DNS lookups (grouped by result)
+}
+
+func (m *tokenMetrics) RecordLookup(result string, latency time.Duration) {
+	m.lookups.Inc(result)
+	m.lookupLatency.Observe(latency.Seconds(), result)
+}
+
+func NewTokenMetrics() tokenVerifier.Metrics {
+	mf := newMetricFactory()
+	// Interval 1ms to 10s, with thresholds roughly a factor
+	// 10^{1/4} \appr 1.8 apart.
+	buckets := []float64{1e-3, 2e-3, 3e-3, 6e-3, 10e-3, 20e-3, 30e-3, 60e-3, 0.1, 0.2, 0.3, 0.6, 1, 2, 3, 6, 10}
+
+	return &tokenMetrics{
+		lookups:       mf.NewCounter("submit_token_lookups_total", "number of submit token DNS lookups", "result"),
+		lookupLatency: mf.NewHistogramWithBuckets("submit_token_lookup_latency", "submit token DNS lookup latency", buckets, "result"),
+	}
+}
+
+type leafTrackerMetrics struct {
+	deadlineViolations monitoring.Counter   // number of leaves not published before the promised deadline
+	timeToPublish      monitoring.Histogram // time from a leaf is accepted until it is published
+	pending            monitoring.Gauge     // number of accepted leaves not yet published
+	oldestPending      monitoring.Gauge     // age of the oldest accepted leaf not yet published
+}
+
+func (m *leafTrackerMetrics) RecordDeadlineViolation() {
+	m.deadlineViolations.Inc()
+}
+
+func (m *leafTrackerMetrics) RecordPublished(delay time.Duration) {
+	m.timeToPublish.Observe(delay.Seconds())
+}
+
+func (m *leafTrackerMetrics) RecordPending(count int, oldest time.Duration) {
+	m.pending.Set(float64(count))
+	m.oldestPending.Set(oldest.Seconds())
+}
+
+func NewLeafTrackerMetrics() leafTracker.Metrics {
+	mf := newMetricFactory()
+	// Interval 1s to 1h, with thresholds roughly a factor
+	// 10^{1/4} \appr 1.8 apart.
+	buckets := []float64{1, 2, 3, 6, 10, 20, 30, 60, 100, 200, 300, 600, 1000, 2000, 3600}
+
+	return &leafTrackerMetrics{
+		deadlineViolations: mf.NewCounter("add_leaf_deadline_violations_total", "number of accepted leaves not published before the deadline in their receipt"),
+		timeToPublish:      mf.NewHistogramWithBuckets("leaf_time_to_publish_seconds", "time from a leaf is accepted until it is included in a published tree head", buckets),
+		pending:            mf.NewGauge("pending_leaves", "number of accepted leaves not yet included in a published tree head"),
+		oldestPending:      mf.NewGauge("oldest_pending_leaf_age_seconds", "time since the oldest pending leaf was accepted"),
 	}
 }
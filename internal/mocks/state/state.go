@@ -52,6 +52,20 @@ func (mr *MockStateManagerMockRecorder) CosignedTreeHead() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CosignedTreeHead", reflect.TypeOf((*MockStateManager)(nil).CosignedTreeHead))
 }
 
+// NextPublish mocks base method.
+func (m *MockStateManager) NextPublish() <-chan struct{} {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NextPublish")
+	ret0, _ := ret[0].(<-chan struct{})
+	return ret0
+}
+
+// NextPublish indicates an expected call of NextPublish.
+func (mr *MockStateManagerMockRecorder) NextPublish() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextPublish", reflect.TypeOf((*MockStateManager)(nil).NextPublish))
+}
+
 // Run mocks base method.
 func (m *MockStateManager) Run(arg0 context.Context, arg1 *policy.Policy, arg2 time.Duration, arg3 witness.WitnessMetrics) {
 	m.ctrl.T.Helper()
@@ -64,6 +78,18 @@ func (mr *MockStateManagerMockRecorder) Run(arg0, arg1, arg2, arg3 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Run", reflect.TypeOf((*MockStateManager)(nil).Run), arg0, arg1, arg2, arg3)
 }
 
+// SetPolicy mocks base method.
+func (m *MockStateManager) SetPolicy(arg0 *policy.Policy) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetPolicy", arg0)
+}
+
+// SetPolicy indicates an expected call of SetPolicy.
+func (mr *MockStateManagerMockRecorder) SetPolicy(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPolicy", reflect.TypeOf((*MockStateManager)(nil).SetPolicy), arg0)
+}
+
 // SignedTreeHead mocks base method.
 func (m *MockStateManager) SignedTreeHead() types.SignedTreeHead {
 	m.ctrl.T.Helper()
@@ -77,3 +103,17 @@ func (mr *MockStateManagerMockRecorder) SignedTreeHead() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SignedTreeHead", reflect.TypeOf((*MockStateManager)(nil).SignedTreeHead))
 }
+
+// WitnessStatus mocks base method.
+func (m *MockStateManager) WitnessStatus() []witness.Status {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WitnessStatus")
+	ret0, _ := ret[0].([]witness.Status)
+	return ret0
+}
+
+// WitnessStatus indicates an expected call of WitnessStatus.
+func (mr *MockStateManagerMockRecorder) WitnessStatus() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WitnessStatus", reflect.TypeOf((*MockStateManager)(nil).WitnessStatus))
+}
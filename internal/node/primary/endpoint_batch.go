@@ -0,0 +1,188 @@
+package primary
+
+// This file implements the add-leaves endpoint, for submitting a batch
+// of leaves in a single request. It is not part of the Sigsum log api,
+// so it is served by a separate handler.
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"sigsum.org/log-go/internal/rate-limit"
+	"sigsum.org/sigsum-go/pkg/api"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Maximum number of leaves in an add-leaves request.
+const maxAddLeavesBatch = 1000
+
+// AddLeaves adds a batch of leaves, all submitted using the same
+// token. Each leaf is checked and charged to the rate limit
+// separately. Returns the status of each leaf, as the HTTP status
+// code add-leaf would have used, or 503 if the backend failed to add
+// that leaf.
+func (p Primary) AddLeaves(ctx context.Context, reqs []requests.Leaf, t *token.SubmitHeader) ([]int, error) {
+	log.Debug("handling add-leaves request, %d leaves", len(reqs))
+	domain, err := p.verifySubmitToken(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]int, len(reqs))
+	// Leaves to add, with corresponding index into reqs, and
+	// function to return the rate limit token.
+	var leaves []types.Leaf
+	var indices []int
+	var relaxes []func()
+	var quota rateLimit.Quota
+	denied := false
+	for i := range reqs {
+		leaf, err := reqs[i].Verify()
+		if err != nil {
+			statuses[i] = http.StatusForbidden
+			continue
+		}
+		keyHash := crypto.HashBytes(reqs[i].PublicKey[:])
+		var relax func()
+		relax, quota = p.RateLimiter.AccessAllowed(domain, &keyHash)
+		if relax == nil {
+			statuses[i] = http.StatusTooManyRequests
+			denied = true
+			continue
+		}
+		leaves = append(leaves, leaf)
+		indices = append(indices, i)
+		relaxes = append(relaxes, relax)
+	}
+	// Describes the bucket as of the last leaf.
+	if h := responseHeader(ctx); h != nil {
+		setRateLimitHeaders(h, &quota, denied)
+	}
+	if len(leaves) == 0 {
+		return statuses, nil
+	}
+
+	accepted := time.Now()
+	forgets := make([]func(), len(leaves))
+	for j := range leaves {
+		leafHash := merkle.HashLeafNode(leaves[j].ToBinary())
+		forgets[j] = p.trackLeaf(&leafHash, accepted)
+	}
+	cth := p.Stateman.CosignedTreeHead()
+	results, err := p.DbClient.AddLeaves(ctx, leaves, cth.Size)
+	if err == nil && len(results) != len(leaves) {
+		err = fmt.Errorf("internal error, backend returned %d statuses for %d leaves", len(results), len(leaves))
+	}
+	if err != nil {
+		for _, forget := range forgets {
+			forget()
+		}
+		return nil, err
+	}
+	for j, status := range results {
+		if status.Err != nil {
+			log.Debug("adding leaf %d failed: %v", indices[j], status.Err)
+			relaxes[j]()
+			forgets[j]()
+			statuses[indices[j]] = http.StatusServiceUnavailable
+			continue
+		}
+		if status.AlreadyExists {
+			relaxes[j]()
+		}
+		if status.IsSequenced {
+			forgets[j]()
+			statuses[indices[j]] = http.StatusOK
+		} else {
+			statuses[indices[j]] = http.StatusAccepted
+		}
+	}
+	return statuses, nil
+}
+
+// Reads leaves in the same format as the add-leaf request, i.e.,
+// lines message=, signature= and public_key=, repeated for each
+// leaf.
+func parseLeaves(r io.Reader, maxLeaves int) ([]requests.Leaf, error) {
+	var leaves []requests.Leaf
+	var leaf requests.Leaf
+	keys := []string{"message", "signature", "public_key"}
+	lineno := 0
+	scanner := bufio.NewScanner(r)
+	for ; scanner.Scan(); lineno++ {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if want := keys[lineno%len(keys)]; !ok || key != want {
+			return nil, fmt.Errorf("line %d: expected %s=<value>", lineno+1, want)
+		}
+		var err error
+		switch key {
+		case "message":
+			if len(leaves) == maxLeaves {
+				return nil, fmt.Errorf("too many leaves, max %d", maxLeaves)
+			}
+			leaf.Message, err = crypto.HashFromHex(value)
+		case "signature":
+			leaf.Signature, err = crypto.SignatureFromHex(value)
+		case "public_key":
+			leaf.PublicKey, err = crypto.PublicKeyFromHex(value)
+			leaves = append(leaves, leaf)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s: %v", lineno+1, key, err)
+		}
+	}
+	// E.g., a too long line; don't accept a truncated batch.
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: reading request failed: %v", lineno+1, err)
+	}
+	if lineno%len(keys) != 0 {
+		return nil, fmt.Errorf("truncated leaf at end of request")
+	}
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("no leaves in request")
+	}
+	return leaves, nil
+}
+
+// NewAddLeavesHandler returns a handler for the add-leaves endpoint.
+// The response has one status=<code> line per leaf, in order.
+func NewAddLeavesHandler(p *Primary, timeout time.Duration) http.Handler {
+	return WithResponseHeader(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		var submitHeader *token.SubmitHeader
+		if value := r.Header.Get("Sigsum-Token"); len(value) > 0 {
+			var h token.SubmitHeader
+			if err := h.FromHeader(value); err != nil {
+				http.Error(w, fmt.Sprintf("invalid Sigsum-Token header: %v", err), http.StatusBadRequest)
+				return
+			}
+			submitHeader = &h
+		}
+		reqs, err := parseLeaves(r.Body, maxAddLeavesBatch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		statuses, err := p.AddLeaves(ctx, reqs, submitHeader)
+		if err != nil {
+			log.Debug("add-leaves failed: %v", err)
+			http.Error(w, err.Error(), api.ErrorStatusCode(err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, status := range statuses {
+			fmt.Fprintf(w, "status=%d\n", status)
+		}
+	}))
+}
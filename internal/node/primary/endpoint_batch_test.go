@@ -0,0 +1,164 @@
+package primary
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"sigsum.org/log-go/internal/db"
+	mocksDB "sigsum.org/log-go/internal/mocks/db"
+	mocksState "sigsum.org/log-go/internal/mocks/state"
+	"sigsum.org/log-go/internal/rate-limit"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+// Limiter allowing a fixed number of requests, and counting
+// returned tokens.
+type countingLimiter struct {
+	tokens   int
+	returned int
+}
+
+func (l *countingLimiter) AccessAllowed(_ *string, _ *crypto.Hash) (func(), rateLimit.Quota) {
+	if l.tokens == 0 {
+		return nil, rateLimit.Quota{}
+	}
+	l.tokens--
+	return func() { l.returned++ }, rateLimit.Quota{}
+}
+
+func TestAddLeaves(t *testing.T) {
+	reqs := []requests.Leaf{
+		mustLeaf(t, crypto.Hash{1}, true),
+		mustLeaf(t, crypto.Hash{2}, false),
+		mustLeaf(t, crypto.Hash{3}, true),
+		mustLeaf(t, crypto.Hash{4}, true),
+		mustLeaf(t, crypto.Hash{5}, true),
+		mustLeaf(t, crypto.Hash{6}, true),
+	}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := mocksDB.NewMockClient(ctrl)
+	// Leaves 0, 2, 3 and 4 pass signature check and rate limit.
+	client.EXPECT().AddLeaves(gomock.Any(), gomock.Any(), uint64(5)).DoAndReturn(
+		func(_ context.Context, leaves []types.Leaf, _ uint64) ([]db.AddLeafStatus, error) {
+			if len(leaves) != 4 {
+				t.Fatalf("unexpected number of leaves to add: %d", len(leaves))
+			}
+			return []db.AddLeafStatus{
+				{},
+				{AlreadyExists: true},
+				{AlreadyExists: true, IsSequenced: true},
+				{Err: fmt.Errorf("mock failure")},
+			}, nil
+		})
+	stateman := mocksState.NewMockStateManager(ctrl)
+	stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{
+		SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 5}},
+	})
+	limiter := countingLimiter{tokens: 4}
+	node := Primary{
+		DbClient:    client,
+		Stateman:    stateman,
+		RateLimiter: &limiter,
+	}
+	statuses, err := node.AddLeaves(context.Background(), reqs, nil)
+	if err != nil {
+		t.Fatalf("AddLeaves failed: %v", err)
+	}
+	if want := []int{http.StatusAccepted, http.StatusForbidden, http.StatusAccepted, http.StatusOK,
+		http.StatusServiceUnavailable, http.StatusTooManyRequests}; !slices.Equal(statuses, want) {
+		t.Errorf("unexpected statuses, got %v, wanted %v", statuses, want)
+	}
+	// Existing and failed leaves are not charged.
+	if limiter.returned != 3 {
+		t.Errorf("unexpected number of returned rate limit tokens, got %d, wanted 3", limiter.returned)
+	}
+}
+
+func leafLines(req *requests.Leaf) string {
+	return fmt.Sprintf("message=%x\nsignature=%x\npublic_key=%x\n", req.Message, req.Signature, req.PublicKey)
+}
+
+func TestParseLeaves(t *testing.T) {
+	req1 := mustLeaf(t, crypto.Hash{1}, true)
+	req2 := mustLeaf(t, crypto.Hash{2}, true)
+	leaves, err := parseLeaves(bytes.NewBufferString(leafLines(&req1)+leafLines(&req2)), 2)
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	if !slices.Equal(leaves, []requests.Leaf{req1, req2}) {
+		t.Errorf("unexpected leaves: %v", leaves)
+	}
+
+	for _, table := range []struct {
+		desc  string
+		input string
+	}{
+		{"empty", ""},
+		{"too many", leafLines(&req1) + leafLines(&req2) + leafLines(&req1)},
+		{"truncated", leafLines(&req1) + fmt.Sprintf("message=%x\n", req2.Message)},
+		{"wrong order", fmt.Sprintf("signature=%x\nmessage=%x\npublic_key=%x\n", req1.Signature, req1.Message, req1.PublicKey)},
+		{"bad hex", "message=xx\n"},
+		{"too long line", leafLines(&req1) + "message=" + strings.Repeat("0", 100000) + "\n"},
+	} {
+		if _, err := parseLeaves(bytes.NewBufferString(table.input), 2); err == nil {
+			t.Errorf("%s: unexpected success", table.desc)
+		}
+	}
+	// Read error at leaf boundary.
+	if _, err := parseLeaves(io.MultiReader(bytes.NewBufferString(leafLines(&req1)),
+		iotest.ErrReader(fmt.Errorf("mock failure"))), 2); err == nil {
+		t.Errorf("unexpected success, after read error")
+	}
+}
+
+func TestAddLeavesHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := mocksDB.NewMockClient(ctrl)
+	client.EXPECT().AddLeaves(gomock.Any(), gomock.Any(), gomock.Any()).Return([]db.AddLeafStatus{{}}, nil)
+	stateman := mocksState.NewMockStateManager(ctrl)
+	stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{})
+	handler := NewAddLeavesHandler(&Primary{
+		DbClient:    client,
+		Stateman:    stateman,
+		RateLimiter: rateLimit.NoLimit{},
+	}, time.Minute)
+
+	req := mustLeaf(t, crypto.Hash{1}, true)
+	for _, table := range []struct {
+		desc     string
+		body     string
+		header   string
+		wantCode int
+		wantBody string
+	}{
+		{"valid", leafLines(&req), "", http.StatusOK, "status=202\n"},
+		{"bad body", "foo\n", "", http.StatusBadRequest, ""},
+		{"bad token header", leafLines(&req), "example.org", http.StatusBadRequest, ""},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/add-leaves", strings.NewReader(table.body))
+		if table.header != "" {
+			r.Header.Set("Sigsum-Token", table.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != table.wantCode {
+			t.Errorf("%s: unexpected status %d, wanted %d", table.desc, w.Code, table.wantCode)
+		} else if table.wantBody != "" && w.Body.String() != table.wantBody {
+			t.Errorf("%s: unexpected body %q, wanted %q", table.desc, w.Body.String(), table.wantBody)
+		}
+	}
+}
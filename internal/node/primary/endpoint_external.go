@@ -4,30 +4,61 @@ package primary
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/rate-limit"
+	"sigsum.org/log-go/internal/token-verifier"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
 	"sigsum.org/sigsum-go/pkg/requests"
 	"sigsum.org/sigsum-go/pkg/submit-token"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
+var (
+	// The submit token doesn't match any key registered for the
+	// domain.
+	errInvalidToken = api.NewError(http.StatusUnauthorized, nil)
+	// Keys for the domain couldn't be looked up, the client may
+	// retry later.
+	errTokenLookup = api.NewError(http.StatusServiceUnavailable, nil)
+)
+
+// Returns the submitter's domain, or nil if there's no valid token.
+func (p Primary) verifySubmitToken(ctx context.Context, t *token.SubmitHeader) (*string, error) {
+	if t == nil || p.TokenVerifier == nil {
+		return nil, nil
+	}
+	if err := p.TokenVerifier.Verify(ctx, t); err != nil {
+		if errors.Is(err, tokenVerifier.ErrInvalidToken) {
+			return nil, errInvalidToken.WithError(err)
+		}
+		return nil, errTokenLookup.WithError(err)
+	}
+	return &t.Domain, nil
+}
+
 func (p Primary) AddLeaf(ctx context.Context, req requests.Leaf, t *token.SubmitHeader) (bool, error) {
 	log.Debug("handling add-leaf request")
-	var domain *string
-	if t != nil && p.TokenVerifier != nil {
-		// TODO: Return more appropriate errors from TokenVerifier?
-		if err := p.TokenVerifier.Verify(ctx, t); err != nil {
-			return false, api.ErrBadRequest.WithError(err)
-		}
-		domain = &t.Domain
+	domain, err := p.verifySubmitToken(ctx, t)
+	if err != nil {
+		return false, err
 	}
 	keyHash := crypto.HashBytes(req.PublicKey[:])
-	relax := p.RateLimiter.AccessAllowed(domain, &keyHash)
+	relax, quota := p.RateLimiter.AccessAllowed(domain, &keyHash)
+	if h := responseHeader(ctx); h != nil {
+		setRateLimitHeaders(h, &quota, relax == nil)
+	}
 	if relax == nil {
+		if quota.Bucket != rateLimit.BucketNone {
+			return false, api.ErrTooManyRequests.WithError(fmt.Errorf("rate-limit for %s %q exceeded", quota.Bucket, quota.Name))
+		}
 		if domain == nil {
 			return false, api.ErrTooManyRequests.WithError(fmt.Errorf("rate-limit for unknown domain exceeded"))
 		}
@@ -38,17 +69,37 @@ func (p Primary) AddLeaf(ctx context.Context, req requests.Leaf, t *token.Submit
 		return false, api.ErrForbidden.WithError(err)
 	}
 
-	sth := p.Stateman.SignedTreeHead()
+	wait := min(waitPreference(ctx), p.MaxAddLeafWait)
+	var published <-chan struct{}
+	if wait > 0 {
+		published = p.Stateman.NextPublish()
+	}
+
+	leafHash := merkle.HashLeafNode(leaf.ToBinary())
+	forget := p.trackLeaf(&leafHash, time.Now())
+
+	// Sequenced means included in the published tree head, which
+	// lags behind the signed tree head while waiting for witnesses.
+	cth := p.Stateman.CosignedTreeHead()
 	status, err := p.DbClient.AddLeaf(ctx,
-		&leaf, sth.Size)
+		&leaf, cth.Size)
 	log.Debug("status: %#v, err: %v", status, err)
 	if err != nil {
+		forget()
 		return false, err
 	}
 	if status.AlreadyExists {
 		relax()
 	}
-	return status.IsSequenced, nil
+	if status.IsSequenced {
+		forget()
+		return true, nil
+	}
+	if wait > 0 && p.waitForInclusion(ctx, &leafHash, published, wait) {
+		return true, nil
+	}
+	p.issueReceipt(ctx, &leafHash)
+	return false, nil
 }
 
 func (p Primary) GetTreeHead(_ context.Context) (types.CosignedTreeHead, error) {
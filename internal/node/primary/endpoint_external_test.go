@@ -4,21 +4,32 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/leaf-tracker"
 	mocksDB "sigsum.org/log-go/internal/mocks/db"
 	mocksState "sigsum.org/log-go/internal/mocks/state"
 	"sigsum.org/log-go/internal/rate-limit"
+	"sigsum.org/log-go/internal/receipt"
+	"sigsum.org/log-go/internal/state"
+	"sigsum.org/log-go/internal/token-verifier"
+	"sigsum.org/log-go/internal/witness"
 	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/policy"
 	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/submit-token"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
 func TestAddLeaf(t *testing.T) {
-	// TODO: Add tests for rate limiting.
 	for _, table := range []struct {
 		description string
 		req         requests.Leaf
@@ -57,7 +68,7 @@ func TestAddLeaf(t *testing.T) {
 			client.EXPECT().AddLeaf(gomock.Any(), gomock.Any(), gomock.Any()).Return(table.leafStatus, table.errTrillian).AnyTimes()
 
 			stateman := mocksState.NewMockStateManager(ctrl)
-			stateman.EXPECT().SignedTreeHead().Return(types.SignedTreeHead{}).AnyTimes()
+			stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{}).AnyTimes()
 			node := Primary{
 				DbClient:    client,
 				Stateman:    stateman,
@@ -74,6 +85,339 @@ func TestAddLeaf(t *testing.T) {
 	}
 }
 
+// Limiter returning a fixed quota.
+type fixedLimiter struct {
+	allow bool
+	quota rateLimit.Quota
+}
+
+func (l fixedLimiter) AccessAllowed(_ *string, _ *crypto.Hash) (func(), rateLimit.Quota) {
+	if !l.allow {
+		return nil, l.quota
+	}
+	return func() {}, l.quota
+}
+
+func TestAddLeafRateLimit(t *testing.T) {
+	quota := rateLimit.Quota{
+		Bucket:    rateLimit.BucketDomain,
+		Name:      "example.com",
+		Limit:     rateLimit.Limit{Rate: 24, Burst: 5},
+		Remaining: 0,
+		Reset:     90*time.Minute + 500*time.Millisecond,
+	}
+	for _, table := range []struct {
+		description string
+		limiter     rateLimit.Limiter
+		wantCode    int
+		wantHeader  map[string]string
+	}{
+		{
+			description: "allowed",
+			limiter:     fixedLimiter{allow: true, quota: quota},
+			wantHeader: map[string]string{
+				"RateLimit-Bucket":    "domain=example.com",
+				"RateLimit-Limit":     "24",
+				"RateLimit-Policy":    "24;w=86400",
+				"RateLimit-Remaining": "0",
+				"RateLimit-Reset":     "5401",
+				"Retry-After":         "",
+			},
+		},
+		{
+			description: "denied",
+			limiter: fixedLimiter{quota: func() rateLimit.Quota {
+				q := quota
+				q.RetryAfter = 30 * time.Minute
+				return q
+			}()},
+			wantCode: http.StatusTooManyRequests,
+			wantHeader: map[string]string{
+				"RateLimit-Bucket": "domain=example.com",
+				"Retry-After":      "1800",
+			},
+		},
+		{
+			description: "denied, no bucket",
+			limiter:     fixedLimiter{},
+			wantCode:    http.StatusTooManyRequests,
+			wantHeader: map[string]string{
+				"RateLimit-Bucket": "",
+				"Retry-After":      "",
+			},
+		},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			client := mocksDB.NewMockClient(ctrl)
+			client.EXPECT().AddLeaf(gomock.Any(), gomock.Any(), gomock.Any()).Return(db.AddLeafStatus{}, nil).AnyTimes()
+
+			stateman := mocksState.NewMockStateManager(ctrl)
+			stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{}).AnyTimes()
+			node := Primary{
+				DbClient:    client,
+				Stateman:    stateman,
+				RateLimiter: table.limiter,
+			}
+			header := make(http.Header)
+			ctx := context.WithValue(context.Background(), responseHeaderKey{}, header)
+			_, err := node.AddLeaf(ctx, mustLeaf(t, crypto.Hash{}, true), nil)
+			if err := checkError(err, table.wantCode); err != nil {
+				t.Errorf("in test %q: %v", table.description, err)
+			}
+			for name, value := range table.wantHeader {
+				if got := header.Get(name); got != value {
+					t.Errorf("in test %q: unexpected %s header, got %q, wanted %q",
+						table.description, name, got, value)
+				}
+			}
+		}()
+	}
+}
+
+// Verifier returning a fixed error.
+type fixedVerifier struct {
+	err error
+}
+
+func (v fixedVerifier) Verify(_ context.Context, _ *token.SubmitHeader) error {
+	return v.err
+}
+
+func TestAddLeafTokenVerification(t *testing.T) {
+	for _, table := range []struct {
+		description string
+		err         error
+		wantCode    int
+	}{
+		{"valid token", nil, 0},
+		{"invalid token", fmt.Errorf("%w: no key", tokenVerifier.ErrInvalidToken), http.StatusUnauthorized},
+		{"lookup failure", fmt.Errorf("timeout"), http.StatusServiceUnavailable},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			client := mocksDB.NewMockClient(ctrl)
+			client.EXPECT().AddLeaf(gomock.Any(), gomock.Any(), gomock.Any()).Return(db.AddLeafStatus{}, nil).AnyTimes()
+
+			stateman := mocksState.NewMockStateManager(ctrl)
+			stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{}).AnyTimes()
+			node := Primary{
+				DbClient:      client,
+				Stateman:      stateman,
+				TokenVerifier: fixedVerifier{table.err},
+				RateLimiter:   rateLimit.NoLimit{},
+			}
+			_, err := node.AddLeaf(context.Background(), mustLeaf(t, crypto.Hash{}, true),
+				&token.SubmitHeader{Domain: "example.org"})
+			if err := checkError(err, table.wantCode); err != nil {
+				t.Errorf("in test %q: %v", table.description, err)
+			}
+		}()
+	}
+}
+
+func TestAddLeafWait(t *testing.T) {
+	req := mustLeaf(t, crypto.Hash{}, true)
+	leaf, err := req.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafHash := merkle.HashLeafNode(leaf.ToBinary())
+
+	for _, table := range []struct {
+		description string
+		maxWait     time.Duration
+		wait        time.Duration
+		included    bool
+		committed   bool
+	}{
+		{"no preference", time.Minute, 0, true, false},
+		{"waiting disabled", 0, time.Minute, true, false},
+		{"included", time.Minute, time.Minute, true, true},
+		{"timeout", 50 * time.Millisecond, time.Minute, false, false},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			client := mocksDB.NewMockClient(ctrl)
+			client.EXPECT().AddLeaf(gomock.Any(), gomock.Any(), gomock.Any()).Return(db.AddLeafStatus{}, nil).AnyTimes()
+			client.EXPECT().GetInclusionProof(gomock.Any(), gomock.Any()).DoAndReturn(
+				func(_ context.Context, req *requests.InclusionProof) (types.InclusionProof, error) {
+					if req.LeafHash != leafHash || req.Size != 5 {
+						t.Errorf("in test %q: unexpected inclusion proof request: %v", table.description, req)
+					}
+					if !table.included {
+						return types.InclusionProof{}, db.ErrNotIncluded
+					}
+					return types.InclusionProof{LeafIndex: 4}, nil
+				}).AnyTimes()
+
+			// First channel is closed, as if a tree head
+			// was published right after the leaf was added.
+			published := make(chan struct{})
+			close(published)
+			stateman := mocksState.NewMockStateManager(ctrl)
+			stateman.EXPECT().NextPublish().Return(published).MaxTimes(1)
+			stateman.EXPECT().NextPublish().Return(make(chan struct{})).AnyTimes()
+			stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{
+				SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: 5}},
+			}).AnyTimes()
+			node := Primary{
+				DbClient:       client,
+				Stateman:       stateman,
+				RateLimiter:    rateLimit.NoLimit{},
+				MaxAddLeafWait: table.maxWait,
+			}
+			ctx := context.WithValue(context.Background(), waitKey{}, table.wait)
+			committed, err := node.AddLeaf(ctx, req, nil)
+			if err != nil {
+				t.Errorf("in test %q: unexpected error: %v", table.description, err)
+			} else if committed != table.committed {
+				t.Errorf("in test %q: unexpected commit status, got %v, wanted %v", table.description, committed, table.committed)
+			}
+		}()
+	}
+}
+
+func TestParseWaitPreference(t *testing.T) {
+	for _, table := range []struct {
+		values []string
+		wait   time.Duration
+		ok     bool
+	}{
+		{nil, 0, false},
+		{[]string{"wait=10"}, 10 * time.Second, true},
+		{[]string{"respond-async, Wait=\"5\""}, 5 * time.Second, true},
+		{[]string{"return=minimal", "wait=3; foo=bar"}, 3 * time.Second, true},
+		{[]string{"wait=-1"}, 0, false},
+		{[]string{"wait"}, 0, false},
+	} {
+		wait, ok := parseWaitPreference(table.values)
+		if wait != table.wait || ok != table.ok {
+			t.Errorf("unexpected result for %q, got %v, %v, wanted %v, %v",
+				table.values, wait, ok, table.wait, table.ok)
+		}
+	}
+}
+
+func TestWithWaitTimeout(t *testing.T) {
+	handler := WithWaitTimeout(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }),
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusAccepted) }))
+	for _, table := range []struct {
+		prefer string
+		want   int
+	}{
+		{"", http.StatusOK},
+		{"wait=0", http.StatusOK},
+		{"wait=x", http.StatusOK},
+		{"respond-async", http.StatusOK},
+		{"wait=10", http.StatusAccepted},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/add-leaf", nil)
+		if table.prefer != "" {
+			req.Header.Set("Prefer", table.prefer)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != table.want {
+			t.Errorf("unexpected handler for prefer %q, got status %d, wanted %d", table.prefer, w.Code, table.want)
+		}
+	}
+}
+
+func TestAddLeafReceipt(t *testing.T) {
+	req := mustLeaf(t, crypto.Hash{}, true)
+	leaf, err := req.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafHash := merkle.HashLeafNode(leaf.ToBinary())
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	earlier := time.Unix(time.Now().Add(-10*time.Minute).Unix(), 0)
+
+	for _, table := range []struct {
+		description string
+		signer      crypto.Signer
+		leafStatus  db.AddLeafStatus
+		// Leaf already tracked.
+		tracked      *crypto.Hash
+		wantReceipt  bool
+		wantAccepted time.Time // Zero for now.
+	}{
+		{"disabled", nil, db.AddLeafStatus{}, nil, false, time.Time{}},
+		{"accepted", signer, db.AddLeafStatus{}, nil, true, time.Time{}},
+		{"already accepted", signer, db.AddLeafStatus{AlreadyExists: true}, &leafHash, true, earlier},
+		{"sequenced", signer, db.AddLeafStatus{AlreadyExists: true, IsSequenced: true}, nil, false, time.Time{}},
+		{"tracker full", signer, db.AddLeafStatus{}, &crypto.Hash{1}, false, time.Time{}},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			client := mocksDB.NewMockClient(ctrl)
+			client.EXPECT().AddLeaf(gomock.Any(), gomock.Any(), gomock.Any()).Return(table.leafStatus, nil)
+			stateman := mocksState.NewMockStateManager(ctrl)
+			stateman.EXPECT().CosignedTreeHead().Return(types.CosignedTreeHead{})
+			// Tracker has room for a single leaf.
+			tracker := leafTracker.New(1, nil)
+			if table.tracked != nil {
+				tracker.Add(table.tracked, earlier, earlier.Add(time.Hour))
+			}
+			node := Primary{
+				DbClient:      client,
+				Stateman:      stateman,
+				RateLimiter:   rateLimit.NoLimit{},
+				ReceiptSigner: table.signer,
+				MaxMergeDelay: time.Hour,
+				LeafTracker:   tracker,
+			}
+			h := http.Header{}
+			start := time.Now()
+			if _, err := node.AddLeaf(context.WithValue(context.Background(), responseHeaderKey{}, h), req, nil); err != nil {
+				t.Fatalf("in test %q: unexpected error: %v", table.description, err)
+			}
+			if table.tracked == nil {
+				if tracked := tracker.Add(&crypto.Hash{1}, start, time.Time{}) == nil; tracked != !table.leafStatus.IsSequenced {
+					t.Errorf("in test %q: unexpected tracking status %v", table.description, tracked)
+				}
+			}
+			value := h.Get(receipt.HeaderName)
+			if !table.wantReceipt {
+				if value != "" {
+					t.Errorf("in test %q: unexpected receipt: %q", table.description, value)
+				}
+				return
+			}
+			var r receipt.Receipt
+			if err := r.FromHeader(value); err != nil {
+				t.Fatalf("in test %q: invalid receipt header %q: %v", table.description, value, err)
+			}
+			if !r.Verify(&pub) {
+				t.Errorf("in test %q: invalid receipt signature", table.description)
+			}
+			if r.LeafHash != leafHash {
+				t.Errorf("in test %q: unexpected receipt leaf hash %x", table.description, r.LeafHash)
+			}
+			if table.wantAccepted.IsZero() {
+				if r.Accepted < uint64(start.Unix()) {
+					t.Errorf("in test %q: unexpected receipt accepted time %d", table.description, r.Accepted)
+				}
+			} else if r.Accepted != uint64(table.wantAccepted.Unix()) {
+				t.Errorf("in test %q: unexpected receipt accepted time %d, wanted %d",
+					table.description, r.Accepted, table.wantAccepted.Unix())
+			}
+			if r.Deadline < r.Accepted+3600 || r.Deadline > r.Accepted+3602 {
+				t.Errorf("in test %q: unexpected receipt times, accepted %d, deadline %d", table.description, r.Accepted, r.Deadline)
+			}
+		}()
+	}
+}
+
 func TestGetTreeHead(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -381,3 +725,82 @@ func checkError(err error, code int) error {
 	}
 	return nil
 }
+
+type noWitnessMetrics struct{}
+
+func (noWitnessMetrics) RecordCheckpointRequest(_ string, _ bool, _ error, _ time.Duration) {}
+func (noWitnessMetrics) RecordQuorum(_ bool, _ time.Duration)                               {}
+func (noWitnessMetrics) RecordPublishedAge(_ time.Duration)                                 {}
+func (noWitnessMetrics) RecordWitnessHealth(_ string, _ witness.Health)                     {}
+
+// A leaf in the signed, but not published, tree head must not be
+// reported as included.
+func TestAddLeafNoQuorum(t *testing.T) {
+	_, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wPub, _, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Witness that never cosigns.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	p, err := policy.ParseConfig(strings.NewReader(fmt.Sprintf("witness W %x %s\nquorum W\n", wPub, server.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sthFileName := filepath.Join(t.TempDir(), "sth")
+	if err := state.CreateStartupFile(sthFileName, state.StartupEmpty); err != nil {
+		t.Fatal(err)
+	}
+	client := db.NewMemoryDb()
+	req := mustLeaf(t, crypto.Hash{}, true)
+	leaf, err := req.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AddLeaf(context.Background(), &leaf, 0); err != nil {
+		t.Fatal(err)
+	}
+	stateman, err := state.NewStateManagerSingle(client, signer, time.Second, nil, 0, sthFileName, p, true, witness.TimestampWindow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		stateman.Run(ctx, p, time.Minute, noWitnessMetrics{})
+		close(done)
+	}()
+	// Wait for the first rotation to sign the new tree head.
+	for i := 0; stateman.SignedTreeHead().Size == 0; i++ {
+		if i == 100 {
+			t.Fatalf("tree head not signed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	node := Primary{
+		DbClient:    client,
+		Stateman:    stateman,
+		RateLimiter: rateLimit.NoLimit{},
+	}
+	committed, err := node.AddLeaf(context.Background(), req, nil)
+	cancel()
+	<-done
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size := stateman.CosignedTreeHead().Size; size != 0 {
+		t.Fatalf("unexpected published tree head size %d", size)
+	}
+	if committed {
+		t.Errorf("leaf reported as included, but not in published tree head")
+	}
+}
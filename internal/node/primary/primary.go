@@ -1,17 +1,31 @@
 package primary
 
 import (
+	"time"
+
 	"sigsum.org/log-go/internal/db"
+	"sigsum.org/log-go/internal/leaf-tracker"
 	"sigsum.org/log-go/internal/rate-limit"
 	"sigsum.org/log-go/internal/state"
-	"sigsum.org/sigsum-go/pkg/submit-token"
+	"sigsum.org/log-go/internal/token-verifier"
+	"sigsum.org/sigsum-go/pkg/crypto"
 )
 
 // Primary is an instance of the log's primary node
 type Primary struct {
-	MaxRange      int                // Maximum number of leaves per get-leaves request
-	DbClient      db.Client          // provides access to the backend, usually Trillian
-	Stateman      state.StateManager // coordinates access to (co)signed tree heads
-	TokenVerifier *token.DnsVerifier // checks if domain name knows a public key
+	MaxRange      int                    // Maximum number of leaves per get-leaves request
+	DbClient      db.Client              // provides access to the backend, usually Trillian
+	Stateman      state.StateManager     // coordinates access to (co)signed tree heads
+	TokenVerifier tokenVerifier.Verifier // checks if domain name knows a public key
 	RateLimiter   rateLimit.Limiter
+	// Upper bound on how long add-leaf waits for inclusion, when
+	// requested by the client. Zero means never wait.
+	MaxAddLeafWait time.Duration
+	// If both are set, add-leaf responses with status 202 carry
+	// a receipt signed by ReceiptSigner, promising publication
+	// within MaxMergeDelay. Receipts require a LeafTracker.
+	ReceiptSigner crypto.Signer
+	MaxMergeDelay time.Duration
+	// If set, accepted leaves are tracked until published.
+	LeafTracker *leafTracker.Tracker
 }
@@ -0,0 +1,64 @@
+package primary
+
+import (
+	"context"
+	"time"
+
+	"sigsum.org/log-go/internal/receipt"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// Returns the deadline promised in receipts for a leaf accepted at
+// the given time, or zero if receipts aren't enabled.
+func (p Primary) receiptDeadline(accepted time.Time) time.Time {
+	if p.ReceiptSigner == nil || p.MaxMergeDelay == 0 {
+		return time.Time{}
+	}
+	// Rounded up, so the promise is no stricter than MaxMergeDelay.
+	return time.Unix(accepted.Add(p.MaxMergeDelay+time.Second-1).Unix(), 0)
+}
+
+// Records a leaf as pending, until it is published. Must be called
+// before the leaf is queued. Returns a function to call if the leaf
+// isn't queued after all, or is already published.
+func (p Primary) trackLeaf(leafHash *crypto.Hash, accepted time.Time) func() {
+	if p.LeafTracker == nil {
+		return func() {}
+	}
+	forget := p.LeafTracker.Add(leafHash, accepted, p.receiptDeadline(accepted))
+	if forget == nil {
+		log.Debug("leaf tracker full, not tracking accepted leaf")
+		return func() {}
+	}
+	return forget
+}
+
+// Signs a receipt for an accepted leaf, and attaches it to the
+// response. The receipt states the time the leaf was first
+// accepted, and the deadline tracked since then, so that resubmitting
+// the leaf doesn't move the deadline. Does nothing if receipts aren't
+// enabled, or if the leaf isn't tracked, e.g., because the tracker is
+// full, since the deadline couldn't be monitored.
+func (p Primary) issueReceipt(ctx context.Context, leafHash *crypto.Hash) {
+	h := responseHeader(ctx)
+	if p.ReceiptSigner == nil || p.MaxMergeDelay == 0 || p.LeafTracker == nil || h == nil {
+		return
+	}
+	accepted, deadline, ok := p.LeafTracker.Lookup(leafHash)
+	if !ok || deadline.IsZero() {
+		log.Debug("not issuing receipt for untracked leaf")
+		return
+	}
+	r := receipt.Receipt{
+		LeafHash: *leafHash,
+		Accepted: uint64(accepted.Unix()),
+		Deadline: uint64(deadline.Unix()),
+	}
+	if err := r.Sign(p.ReceiptSigner); err != nil {
+		// The leaf is accepted regardless.
+		log.Error("signing add-leaf receipt failed: %v", err)
+		return
+	}
+	h.Set(receipt.HeaderName, r.ToHeader())
+}
@@ -0,0 +1,51 @@
+package primary
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sigsum.org/log-go/internal/rate-limit"
+)
+
+type responseHeaderKey struct{}
+
+// WithResponseHeader wraps a handler, making the response header
+// available to endpoint callbacks via the request context. Used for
+// headers that can't be expressed by returning an api.Error.
+func WithResponseHeader(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), responseHeaderKey{}, w.Header())
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Returns nil if the context wasn't set up by WithResponseHeader.
+func responseHeader(ctx context.Context) http.Header {
+	h, _ := ctx.Value(responseHeaderKey{}).(http.Header)
+	return h
+}
+
+// Rounds up to whole seconds.
+func headerSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// Sets RateLimit-* headers describing the bucket that was applied,
+// and for denied requests, Retry-After if the bucket will be
+// refilled.
+func setRateLimitHeaders(h http.Header, quota *rateLimit.Quota, denied bool) {
+	if quota.Bucket == rateLimit.BucketNone {
+		return
+	}
+	h.Set("RateLimit-Bucket", fmt.Sprintf("%s=%s", quota.Bucket, quota.Name))
+	h.Set("RateLimit-Limit", strconv.Itoa(quota.Limit.Rate))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", quota.Limit.Rate, int64(quota.Limit.RefillPeriod()/time.Second)))
+	h.Set("RateLimit-Remaining", strconv.Itoa(quota.Remaining))
+	h.Set("RateLimit-Reset", headerSeconds(quota.Reset))
+	if denied && quota.RetryAfter > 0 {
+		h.Set("Retry-After", headerSeconds(quota.RetryAfter))
+	}
+}
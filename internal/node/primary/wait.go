@@ -0,0 +1,119 @@
+package primary
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/requests"
+)
+
+type waitKey struct{}
+
+// WithWaitPreference wraps a handler, making the wait time requested
+// with a "Prefer: wait=<seconds>" header (RFC 7240) available to
+// endpoint callbacks via the request context.
+func WithWaitPreference(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wait, ok := parseWaitPreference(r.Header.Values("Prefer")); ok {
+			r = r.WithContext(context.WithValue(r.Context(), waitKey{}, wait))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// IsWaitRequest reports whether the request asks to wait, with a
+// non-zero wait preference.
+func IsWaitRequest(r *http.Request) bool {
+	wait, ok := parseWaitPreference(r.Header.Values("Prefer"))
+	return ok && wait > 0
+}
+
+// WithWaitTimeout returns a handler passing requests that ask to wait
+// to waitHandler, and all other requests to handler. The two handlers
+// are intended to differ only in their timeout, so that the longer
+// timeout needed for waiting applies only to requests that asked for
+// it.
+func WithWaitTimeout(handler, waitHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsWaitRequest(r) {
+			waitHandler.ServeHTTP(w, r)
+		} else {
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Returns the first wait preference, ignoring any other preferences.
+func parseWaitPreference(values []string) (time.Duration, bool) {
+	for _, value := range values {
+		for _, pref := range strings.Split(value, ",") {
+			// Drop any parameters.
+			pref, _, _ = strings.Cut(pref, ";")
+			name, arg, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "wait") {
+				continue
+			}
+			seconds, err := strconv.ParseUint(strings.Trim(strings.TrimSpace(arg), "\""), 10, 32)
+			if err != nil {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+// Returns zero if the client didn't ask to wait.
+func waitPreference(ctx context.Context) time.Duration {
+	wait, _ := ctx.Value(waitKey{}).(time.Duration)
+	return wait
+}
+
+// Checks if the leaf is included in the tree of the given size.
+func (p Primary) isIncluded(ctx context.Context, leafHash *crypto.Hash, size uint64, rootHash *crypto.Hash) (bool, error) {
+	switch size {
+	case 0:
+		return false, nil
+	case 1:
+		// The backend can't produce an empty inclusion proof.
+		return *leafHash == *rootHash, nil
+	}
+	_, err := p.DbClient.GetInclusionProof(ctx, &requests.InclusionProof{Size: size, LeafHash: *leafHash})
+	if err == db.ErrNotIncluded {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Waits until the leaf is included in a newly published tree head,
+// or until wait expires. The published channel must be obtained
+// before the leaf is added, to not miss any tree head. Returns
+// true if the leaf was included.
+func (p Primary) waitForInclusion(ctx context.Context, leafHash *crypto.Hash, published <-chan struct{}, wait time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-published:
+		}
+		published = p.Stateman.NextPublish()
+		cth := p.Stateman.CosignedTreeHead()
+		included, err := p.isIncluded(ctx, leafHash, cth.Size, &cth.RootHash)
+		if err != nil {
+			// Client can retry the usual way.
+			log.Debug("checking inclusion of waiting leaf failed: %v", err)
+			return false
+		}
+		if included {
+			return true
+		}
+	}
+}
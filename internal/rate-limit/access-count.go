@@ -2,43 +2,157 @@ package rateLimit
 
 import (
 	"sync"
+	"time"
 )
 
-// A synchronized map of access counts.
+// Buckets are refilled at the configured rate per this period,
+// unless the limit specifies a different period.
+const refillPeriod = 24 * time.Hour
+
+// How often buckets that are full, and hence equivalent to new
+// buckets, are deleted.
+const prunePeriod = time.Hour
+
+// A token bucket. The number of tokens is fractional, with the
+// bucket refilled continuously.
+type bucket struct {
+	limit  Limit
+	tokens float64
+	// Time when tokens was last updated.
+	last time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(float64(b.limit.Burst),
+			b.tokens+elapsed.Seconds()*float64(b.limit.Rate)/b.limit.RefillPeriod().Seconds())
+		b.last = now
+	}
+}
+
+// Changes the limit, keeping the number of tokens consumed from
+// the bucket.
+func (b *bucket) setLimit(limit Limit) {
+	if limit == b.limit {
+		return
+	}
+	b.tokens = max(0, min(float64(limit.Burst),
+		b.tokens+float64(limit.Burst-b.limit.Burst)))
+	b.limit = limit
+}
+
+// Time until the bucket has the given number of tokens.
+func (b *bucket) timeUntil(tokens float64) time.Duration {
+	if b.tokens >= tokens {
+		return 0
+	}
+	return time.Duration(float64(b.limit.RefillPeriod()) * (tokens - b.tokens) / float64(b.limit.Rate))
+}
+
+// Consumes a token, if available.
+func (b *bucket) take() bool {
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *bucket) quota(denied bool) Quota {
+	q := Quota{
+		Limit:     b.limit,
+		Remaining: int(b.tokens),
+	}
+	if b.limit.Rate > 0 {
+		q.Reset = b.timeUntil(float64(b.limit.Burst))
+		if denied {
+			q.RetryAfter = b.timeUntil(1)
+		}
+	}
+	return q
+}
+
+// A synchronized map of token buckets, representing access counts.
+// Implements CounterStore.
 type accessCounts struct {
-	// Protects the counts mapping.
+	// Protects the buckets mapping.
 	sync.Mutex
-	counts map[string]int
+	buckets   map[string]*bucket
+	nextPrune time.Time
 }
 
-func (c *accessCounts) GetAccessCount(key string) int {
+// Returns the number of tokens in the bucket, or -1 if there's no
+// bucket for the key.
+func (c *accessCounts) GetTokens(key string, now time.Time) float64 {
 	c.Lock()
 	defer c.Unlock()
-	return c.counts[key]
+	b, ok := c.buckets[key]
+	if !ok {
+		return -1
+	}
+	b.refill(now)
+	return b.tokens
 }
 
-func (c *accessCounts) AccessAllowed(key string, limit int) func() {
+// Consumes a token, if available. Returns a function to return the
+// token, or nil if access is denied, and the state of the bucket. The
+// Bucket and Name of the quota are left for the caller to fill in.
+func (c *accessCounts) AccessAllowed(key string, limit Limit, now time.Time) (func(), Quota) {
 	c.Lock()
 	defer c.Unlock()
-	if c.counts[key] >= limit {
-		return nil
+	c.prune(now)
+	b, ok := c.buckets[key]
+	if ok {
+		b.refill(now)
+		b.setLimit(limit)
+	} else {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		c.buckets[key] = b
+	}
+	if !b.take() {
+		return nil, b.quota(true)
 	}
-	c.counts[key]++
-	return func() { c.accessRelax(key) }
+	return func() { c.accessRelax(key) }, b.quota(false)
 }
 
 func (c *accessCounts) accessRelax(key string) {
 	c.Lock()
 	defer c.Unlock()
-	// Non-zero count is the expeced case, except if there were a
-	// Reset call between AccessAllowed and AccessRelax.
-	if c.counts[key] > 0 {
-		c.counts[key]--
+	// Bucket may be missing, if there were a Reset call, or the
+	// bucket was pruned, between AccessAllowed and AccessRelax.
+	if b, ok := c.buckets[key]; ok {
+		b.tokens = min(float64(b.limit.Burst), b.tokens+1)
+	}
+}
+
+// Deletes full buckets, at most once per prunePeriod. Must be
+// called with lock held.
+func (c *accessCounts) prune(now time.Time) {
+	if now.Before(c.nextPrune) {
+		return
+	}
+	for key, b := range c.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(c.buckets, key)
+		}
+	}
+	c.nextPrune = now.Add(prunePeriod)
+}
+
+// Keeps only the buckets for keys for which keep returns true.
+func (c *accessCounts) Retain(keep func(key string) bool) {
+	c.Lock()
+	defer c.Unlock()
+	for key := range c.buckets {
+		if !keep(key) {
+			delete(c.buckets, key)
+		}
 	}
 }
 
 func (c *accessCounts) Reset() {
 	c.Lock()
 	defer c.Unlock()
-	c.counts = make(map[string]int)
+	c.buckets = make(map[string]*bucket)
 }
@@ -2,31 +2,108 @@ package rateLimit
 
 import (
 	"testing"
+	"time"
 )
 
 func TestAccessAllowed(t *testing.T) {
 	m := accessCounts{}
 	m.Reset()
+	now := time.Unix(1000, 0)
+	// Refilled with one token per hour.
+	limit := Limit{Rate: 24, Burst: 2}
 
-	checkCount := func(domain string, expected int) {
-		if c := m.GetAccessCount(domain); c != expected {
-			t.Errorf("expected access count (%q) = %d, got %d",
+	checkTokens := func(domain string, expected float64) {
+		if c := m.GetTokens(domain, now); c != expected {
+			t.Errorf("expected tokens (%q) = %v, got %v",
 				domain, expected, c)
 		}
 	}
-	checkAccess := func(desc, domain string, limit int, expected bool) {
-		if res := m.AccessAllowed(domain, limit); (res != nil) != expected {
-			t.Errorf("%v: unexpected access (%q, %d), got %v, expected %v, count = %d",
-				desc, domain, limit, res != nil, expected, m.GetAccessCount(domain))
+	checkAccess := func(desc, domain string, limit Limit, expected bool) {
+		if res, _ := m.AccessAllowed(domain, limit, now); (res != nil) != expected {
+			t.Errorf("%v: unexpected access (%q, %v), got %v, expected %v, tokens = %v",
+				desc, domain, limit, res != nil, expected, m.GetTokens(domain, now))
 		}
 	}
-	checkCount("foo", 0)
-	checkAccess("first", "foo", 2, true)
-	checkCount("foo", 1)
-	checkAccess("second", "foo", 2, true)
-	checkAccess("third", "foo", 2, false)
-	checkCount("foo", 2)
-
-	checkCount("bar", 0)
-	checkAccess("other domain", "bar", 2, true)
+	checkTokens("foo", -1)
+	checkAccess("first", "foo", limit, true)
+	checkTokens("foo", 1)
+	checkAccess("second", "foo", limit, true)
+	checkAccess("third", "foo", limit, false)
+	checkTokens("foo", 0)
+
+	checkTokens("bar", -1)
+	checkAccess("other domain", "bar", limit, true)
+
+	now = now.Add(30 * time.Minute)
+	checkTokens("foo", 0.5)
+	checkAccess("half refilled", "foo", limit, false)
+	now = now.Add(30 * time.Minute)
+	checkAccess("refilled", "foo", limit, true)
+	checkAccess("empty", "foo", limit, false)
+
+	// Raising the burst size keeps the number of consumed tokens.
+	checkAccess("raised burst", "foo", Limit{Rate: 24, Burst: 3}, true)
+	checkTokens("foo", 0)
+
+	// Full buckets are pruned.
+	now = now.Add(prunePeriod + 3*time.Hour)
+	checkAccess("other domain", "bar", limit, true)
+	checkTokens("foo", -1)
+}
+
+func TestAccessRelax(t *testing.T) {
+	m := accessCounts{}
+	m.Reset()
+	now := time.Unix(1000, 0)
+	limit := Limit{Rate: 1, Burst: 1}
+
+	relax, _ := m.AccessAllowed("foo", limit, now)
+	if relax == nil {
+		t.Fatalf("access denied")
+	}
+	if relax, _ := m.AccessAllowed("foo", limit, now); relax != nil {
+		t.Fatalf("access allowed, despite empty bucket")
+	}
+	relax()
+	if relax, _ := m.AccessAllowed("foo", limit, now); relax == nil {
+		t.Errorf("access denied, after returned token")
+	}
+}
+
+func TestAccessQuota(t *testing.T) {
+	m := accessCounts{}
+	m.Reset()
+	now := time.Unix(1000, 0)
+	// Refilled with one token per hour.
+	limit := Limit{Rate: 24, Burst: 2}
+
+	for _, table := range []struct {
+		desc      string
+		delay     time.Duration
+		allowed   bool
+		remaining int
+		reset     time.Duration
+		retry     time.Duration
+	}{
+		{"first", 0, true, 1, time.Hour, 0},
+		{"second", 0, true, 0, 2 * time.Hour, 0},
+		{"empty", 0, false, 0, 2 * time.Hour, time.Hour},
+		{"partially refilled", 15 * time.Minute, false, 0, 105 * time.Minute, 45 * time.Minute},
+		{"refilled", 45 * time.Minute, true, 0, 2 * time.Hour, 0},
+	} {
+		now = now.Add(table.delay)
+		relax, quota := m.AccessAllowed("foo", limit, now)
+		if got := relax != nil; got != table.allowed {
+			t.Errorf("%s: unexpected access, got %v, wanted %v", table.desc, got, table.allowed)
+		}
+		if quota.Limit != limit || quota.Remaining != table.remaining ||
+			quota.Reset != table.reset || quota.RetryAfter != table.retry {
+			t.Errorf("%s: unexpected quota %#v", table.desc, quota)
+		}
+	}
+
+	// A bucket that is never refilled.
+	if _, quota := m.AccessAllowed("bar", Limit{}, now); quota.Reset != 0 || quota.RetryAfter != 0 {
+		t.Errorf("unexpected quota for zero limit: %#v", quota)
+	}
 }
@@ -2,28 +2,65 @@ package rateLimit
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	submitToken "sigsum.org/sigsum-go/pkg/submit-token"
 )
 
+// Limit on the rate of added leaves, enforced using a token bucket.
+type Limit struct {
+	// Number of leaves per Period, i.e., the rate at which the
+	// bucket is refilled.
+	Rate int
+	// Size of the bucket, i.e., the max number of leaves that can
+	// be added in a burst. Defaults to Rate.
+	Burst int
+	// Zero means the default, 24 hours.
+	Period time.Duration
+	// If non-zero, the entry doesn't apply from this time on.
+	Until time.Time
+}
+
+// Returns the period over which Rate tokens are added to the bucket.
+func (l *Limit) RefillPeriod() time.Duration {
+	if l.Period == 0 {
+		return refillPeriod
+	}
+	return l.Period
+}
+
+func (l *Limit) expired(now time.Time) bool {
+	return !l.Until.IsZero() && !now.Before(l.Until)
+}
+
 type Config struct {
-	// Allowlists, and their daily request limit.
-	AllowedKeys      map[string]int // map key is the binary key hash.
-	AllowedDomains   map[string]int // map key lowercase domain.
-	AllowPublic      int
+	// Allowlists, and their limits.
+	AllowedKeys      map[string]Limit // map key is the binary key hash.
+	AllowedDomains   map[string]Limit // map key lowercase domain.
+	AllowPublic      Limit
 	PublicSuffixFile string
 }
 
 // Config file syntax is
-//   key <hash> <limit>
-//   domain <name> <limit>
-//   public <suffix file> <limit>
-// with # used for comments.
+//   key <hash> <limit> [<attribute>=<value> ...]
+//   domain <name> <limit> [<attribute>=<value> ...]
+//   public <suffix file> <limit> [<attribute>=<value> ...]
+//   include <file>
+// with # used for comments, and file names optionally in double
+// quotes. Attributes are
+//   burst=<burst>
+//   per=hour|day|month
+//   until=<YYYY-MM-DD>
+
+// Max nesting of include lines, to catch include loops.
+const maxIncludeDepth = 10
 
 // The type of config lines. None represent an empty or comment-only line.
 type configToken int
@@ -33,24 +70,73 @@ const (
 	configKey
 	configDomain
 	configPublic
+	configInclude
 )
 
-func parseToken(s []byte) (configToken, error) {
-	switch {
-	case bytes.Equal(s, []byte("key")):
+func parseToken(s string) (configToken, error) {
+	switch s {
+	case "key":
 		return configKey, nil
-	case bytes.Equal(s, []byte("domain")):
+	case "domain":
 		return configDomain, nil
-	case bytes.Equal(s, []byte("public")):
+	case "public":
 		return configPublic, nil
+	case "include":
+		return configInclude, nil
 	default:
 		return configNone, fmt.Errorf("unknown config keyword %q", s)
 	}
 }
 
-func parseLimit(s []byte) (int, error) {
+// Splits a line into white-space separated fields, stopping at
+// comments. A field in double quotes can contain white space and #;
+// within quotes, backslash escapes the next character.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if len(line) == 0 || line[0] == '#' {
+			return fields, nil
+		}
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t\r#")
+			if end < 0 {
+				end = len(line)
+			}
+			if strings.Contains(line[:end], "\"") {
+				return nil, fmt.Errorf("unexpected quote in %q", line[:end])
+			}
+			fields = append(fields, line[:end])
+			line = line[end:]
+			continue
+		}
+		var field strings.Builder
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' {
+				i++
+				if i == len(line) {
+					break
+				}
+			}
+			field.WriteByte(line[i])
+		}
+		if i >= len(line) {
+			return nil, fmt.Errorf("unterminated quoted string")
+		}
+		// Skip closing quote, which must be followed by white
+		// space or end of line.
+		line = line[i+1:]
+		if len(line) > 0 && !strings.ContainsAny(line[:1], " \t\r#") {
+			return nil, fmt.Errorf("missing space after quoted string")
+		}
+		fields = append(fields, field.String())
+	}
+}
+
+func parseLimit(s string) (int, error) {
 	// Use ParseUint, to not accept leading +/-.
-	i, err := strconv.ParseUint(string(s), 10, 32)
+	i, err := strconv.ParseUint(s, 10, 32)
 	if err != nil {
 		return 0, err
 	}
@@ -61,40 +147,107 @@ func parseLimit(s []byte) (int, error) {
 	return int(i), nil
 }
 
-func parseLine(line []byte) (configToken, string, int, error) {
-	if comment := bytes.Index(line, []byte{'#'}); comment >= 0 {
-		line = line[:comment]
+func parsePeriod(s string) (time.Duration, error) {
+	switch s {
+	case "hour":
+		return time.Hour, nil
+	case "day":
+		return 24 * time.Hour, nil
+	case "month":
+		return 30 * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid period %q, must be hour, day or month", s)
 	}
-	// TODO: Support quoted file name for public.
-	fields := bytes.Fields(line)
-	if len(fields) == 0 {
-		return configNone, "", 0, nil
+}
+
+// Parses optional attributes, of the form name=value, following the
+// limit.
+func parseAttributes(limit *Limit, attributes []string) error {
+	seen := make(map[string]bool)
+	for _, attribute := range attributes {
+		name, value, ok := strings.Cut(attribute, "=")
+		if !ok {
+			return fmt.Errorf("invalid attribute %q", attribute)
+		}
+		if seen[name] {
+			return fmt.Errorf("invalid multiple %s attributes", name)
+		}
+		seen[name] = true
+		switch name {
+		case "burst":
+			burst, err := parseLimit(value)
+			if err != nil {
+				return err
+			}
+			if burst == 0 && limit.Rate > 0 {
+				return fmt.Errorf("burst must be positive, for positive limit")
+			}
+			limit.Burst = burst
+		case "per":
+			period, err := parsePeriod(value)
+			if err != nil {
+				return err
+			}
+			// Leave default period as zero.
+			if period != refillPeriod {
+				limit.Period = period
+			}
+		case "until":
+			until, err := time.Parse(time.DateOnly, value)
+			if err != nil {
+				return fmt.Errorf("invalid date %q: %v", value, err)
+			}
+			limit.Until = until
+		default:
+			return fmt.Errorf("unknown attribute %q", name)
+		}
 	}
+	return nil
+}
 
-	if len(fields) != 3 {
-		return 0, "", 0, fmt.Errorf("invalid config line %q", line)
+func parseLine(line string) (configToken, string, Limit, error) {
+	fields, err := splitFields(line)
+	if err != nil {
+		return 0, "", Limit{}, err
+	}
+	if len(fields) == 0 {
+		return configNone, "", Limit{}, nil
 	}
 	token, err := parseToken(fields[0])
 	if err != nil {
-		return 0, "", 0, err
+		return 0, "", Limit{}, err
+	}
+	if token == configInclude {
+		if len(fields) != 2 {
+			return 0, "", Limit{}, fmt.Errorf("invalid include line %q", line)
+		}
+		return token, fields[1], Limit{}, nil
 	}
 
-	limit, err := parseLimit(fields[2])
+	if len(fields) < 3 {
+		return 0, "", Limit{}, fmt.Errorf("invalid config line %q", line)
+	}
+
+	rate, err := parseLimit(fields[2])
 	if err != nil {
-		return 0, "", 0, err
+		return 0, "", Limit{}, err
+	}
+	limit := Limit{Rate: rate, Burst: rate}
+	if err := parseAttributes(&limit, fields[3:]); err != nil {
+		return 0, "", Limit{}, err
 	}
 
-	item := string(fields[1])
+	item := fields[1]
 
 	// Validate item format.
 	switch token {
 	case configKey:
 		b, err := hex.DecodeString(item)
 		if err != nil {
-			return 0, "", 0, err
+			return 0, "", Limit{}, err
 		}
 		if len(b) != 32 {
-			return 0, "", 0, fmt.Errorf("invalid length of key hash %q", item)
+			return 0, "", Limit{}, fmt.Errorf("invalid length of key hash %q", item)
 		}
 		item = string(b)
 	case configDomain:
@@ -105,46 +258,106 @@ func parseLine(line []byte) (configToken, string, int, error) {
 		var err error
 		item, err = submitToken.NormalizeDomainName(item)
 		if err != nil {
-			return 0, "", 0, err
+			return 0, "", Limit{}, err
 		}
 	}
 	return token, item, limit, nil
 }
 
-func ParseConfig(file io.Reader) (Config, error) {
-	config := Config{
-		AllowedKeys:    make(map[string]int),
-		AllowedDomains: make(map[string]int),
-	}
-	publicSeen := false
+// State of parsing a config file, and the files it includes.
+type configParser struct {
+	config     Config
+	publicSeen bool
+}
+
+// Parses a file with the given name, used for error messages. Relative
+// include paths are interpreted relative to dir.
+func (p *configParser) parse(file io.Reader, name, dir string, depth int) error {
+	lineno := 0
 	for scanner := bufio.NewScanner(file); scanner.Scan(); {
-		configType, item, limit, err := parseLine(scanner.Bytes())
-		if err != nil {
-			return Config{}, err
-		}
-		switch configType {
-		case configNone:
-			// Do nothing
-		case configKey:
-			if _, ok := config.AllowedKeys[item]; ok {
-				return Config{}, fmt.Errorf("invalid multiple key %x", item)
-			}
-			config.AllowedKeys[item] = limit
-		case configDomain:
-			if _, ok := config.AllowedDomains[item]; ok {
-				return Config{}, fmt.Errorf("invalid multiple domain %s", item)
-			}
-			config.AllowedDomains[item] = limit
-		case configPublic:
-			if publicSeen {
-				return Config{}, fmt.Errorf("invalid multiple \"public\" lines in rate-limit configuration")
+		lineno++
+		if err := p.parseLine(scanner.Text(), dir, depth); err != nil {
+			if name == "" {
+				return fmt.Errorf("line %d: %v", lineno, err)
 			}
-			config.AllowPublic = limit
-			config.PublicSuffixFile = item
-			publicSeen = true
-		default:
-			panic("internal error in parsing rate limit config")
+			return fmt.Errorf("%s:%d: %v", name, lineno, err)
 		}
 	}
-	return config, nil
+	return nil
+}
+
+func (p *configParser) parseLine(line, dir string, depth int) error {
+	configType, item, limit, err := parseLine(line)
+	if err != nil {
+		return err
+	}
+	switch configType {
+	case configNone:
+		// Do nothing
+	case configKey:
+		if _, ok := p.config.AllowedKeys[item]; ok {
+			return fmt.Errorf("invalid multiple key %x", item)
+		}
+		p.config.AllowedKeys[item] = limit
+	case configDomain:
+		if _, ok := p.config.AllowedDomains[item]; ok {
+			return fmt.Errorf("invalid multiple domain %s", item)
+		}
+		p.config.AllowedDomains[item] = limit
+	case configPublic:
+		if p.publicSeen {
+			return fmt.Errorf("invalid multiple \"public\" lines in rate-limit configuration")
+		}
+		p.config.AllowPublic = limit
+		p.config.PublicSuffixFile = item
+		p.publicSeen = true
+	case configInclude:
+		if depth >= maxIncludeDepth {
+			return fmt.Errorf("includes nested too deeply")
+		}
+		if !filepath.IsAbs(item) {
+			item = filepath.Join(dir, item)
+		}
+		return p.parseFile(item, depth+1)
+	default:
+		panic("internal error in parsing rate limit config")
+	}
+	return nil
+}
+
+func (p *configParser) parseFile(fileName string, depth int) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.parse(f, fileName, filepath.Dir(fileName), depth)
+}
+
+func newConfigParser() configParser {
+	return configParser{config: Config{
+		AllowedKeys:    make(map[string]Limit),
+		AllowedDomains: make(map[string]Limit),
+	}}
+}
+
+// ParseConfig parses a config file. Relative include paths are
+// interpreted relative to the current directory.
+func ParseConfig(file io.Reader) (Config, error) {
+	p := newConfigParser()
+	if err := p.parse(file, "", ".", 0); err != nil {
+		return Config{}, err
+	}
+	return p.config, nil
+}
+
+// ParseConfigFile reads and parses the named config file. Relative
+// include paths are interpreted relative to the directory of the
+// including file.
+func ParseConfigFile(fileName string) (Config, error) {
+	p := newConfigParser()
+	if err := p.parseFile(fileName, 0); err != nil {
+		return Config{}, err
+	}
+	return p.config, nil
 }
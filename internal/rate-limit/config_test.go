@@ -3,8 +3,12 @@ package rateLimit
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"sigsum.org/sigsum-go/pkg/crypto"
 )
@@ -47,25 +51,25 @@ func TestParseConfigWithPublic(t *testing.T) {
 	if len(config.AllowedKeys) != 2 {
 		t.Errorf("got %d keys, expected 2", len(config.AllowedKeys))
 	}
-	if got := config.AllowedKeys[string(key1[:])]; got != 10 {
-		t.Errorf("got limit %d for key1", got)
+	if got := config.AllowedKeys[string(key1[:])]; got != (Limit{Rate: 10, Burst: 10}) {
+		t.Errorf("got limit %v for key1", got)
 	}
-	if got := config.AllowedKeys[string(key2[:])]; got != 20 {
-		t.Errorf("got limit %d for key1", got)
+	if got := config.AllowedKeys[string(key2[:])]; got != (Limit{Rate: 20, Burst: 20}) {
+		t.Errorf("got limit %v for key2", got)
 	}
 
 	if len(config.AllowedDomains) != 2 {
 		t.Errorf("got %d domains, expected 2", len(config.AllowedKeys))
 	}
-	if d, got := "example.net", config.AllowedDomains["example.net"]; got != 30 {
-		t.Errorf("got limit %d for domain %s", got, d)
+	if d, got := "example.net", config.AllowedDomains["example.net"]; got != (Limit{Rate: 30, Burst: 30}) {
+		t.Errorf("got limit %v for domain %s", got, d)
 	}
-	if d, got := "www.example.org", config.AllowedDomains["www.example.org"]; got != 40 {
-		t.Errorf("got limit %d for domain %s", got, d)
+	if d, got := "www.example.org", config.AllowedDomains["www.example.org"]; got != (Limit{Rate: 40, Burst: 40}) {
+		t.Errorf("got limit %v for domain %s", got, d)
 	}
 
-	if got := config.AllowPublic; got != 50 {
-		t.Errorf("got public limit %d, expected 50", got)
+	if got := config.AllowPublic; got != (Limit{Rate: 50, Burst: 50}) {
+		t.Errorf("got public limit %v, expected 50", got)
 	}
 	if got := config.PublicSuffixFile; got != "suffixes.dat" {
 		t.Errorf("got unexpected suffix file name %q", got)
@@ -81,25 +85,25 @@ func TestParseConfigWithoutPublic(t *testing.T) {
 	if len(config.AllowedKeys) != 2 {
 		t.Errorf("got %d keys, expected 2", len(config.AllowedKeys))
 	}
-	if got := config.AllowedKeys[string(key1[:])]; got != 10 {
-		t.Errorf("got limit %d for key1", got)
+	if got := config.AllowedKeys[string(key1[:])]; got != (Limit{Rate: 10, Burst: 10}) {
+		t.Errorf("got limit %v for key1", got)
 	}
-	if got := config.AllowedKeys[string(key2[:])]; got != 20 {
-		t.Errorf("got limit %d for key1", got)
+	if got := config.AllowedKeys[string(key2[:])]; got != (Limit{Rate: 20, Burst: 20}) {
+		t.Errorf("got limit %v for key2", got)
 	}
 
 	if len(config.AllowedDomains) != 2 {
 		t.Errorf("got %d domains, expected 2", len(config.AllowedKeys))
 	}
-	if d, got := "example.net", config.AllowedDomains["example.net"]; got != 30 {
-		t.Errorf("got limit %d for domain %s", got, d)
+	if d, got := "example.net", config.AllowedDomains["example.net"]; got != (Limit{Rate: 30, Burst: 30}) {
+		t.Errorf("got limit %v for domain %s", got, d)
 	}
-	if d, got := "www.example.org", config.AllowedDomains["www.example.org"]; got != 40 {
-		t.Errorf("got limit %d for domain %s", got, d)
+	if d, got := "www.example.org", config.AllowedDomains["www.example.org"]; got != (Limit{Rate: 40, Burst: 40}) {
+		t.Errorf("got limit %v for domain %s", got, d)
 	}
 
-	if got := config.AllowPublic; got != 0 {
-		t.Errorf("got public limit %d, expected 00", got)
+	if got := config.AllowPublic; got != (Limit{}) {
+		t.Errorf("got public limit %v, expected 0", got)
 	}
 }
 
@@ -111,6 +115,10 @@ func TestParseBadConfig(t *testing.T) {
 		domainLine("eXample.net", 7),
 		publicLine("foo.dat", 10),
 		domainLine("other.example.com", -10),
+		domainLine("other.example.com", 10) + " burst",
+		domainLine("other.example.com", 10) + " burst=0",
+		domainLine("other.example.com", 10) + " burst=5 burst=6",
+		domainLine("other.example.com", 10) + " foo=5",
 	} {
 		badConfig := configFile + s + "\n"
 		_, err := parseConfigString(badConfig)
@@ -119,3 +127,105 @@ func TestParseBadConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestParseConfigBurst(t *testing.T) {
+	config, err := parseConfigString(keyLine(&key1, 10) + " burst=3\n" +
+		domainLine("example.net", 0) + " burst=0\n" +
+		publicLine("suffixes.dat", 50) + " burst=100\n")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if got := config.AllowedKeys[string(key1[:])]; got != (Limit{Rate: 10, Burst: 3}) {
+		t.Errorf("got limit %v for key1", got)
+	}
+	if got := config.AllowedDomains["example.net"]; got != (Limit{}) {
+		t.Errorf("got limit %v for domain", got)
+	}
+	if got := config.AllowPublic; got != (Limit{Rate: 50, Burst: 100}) {
+		t.Errorf("got public limit %v", got)
+	}
+}
+
+func TestParseConfigAttributes(t *testing.T) {
+	config, err := parseConfigString(keyLine(&key1, 10) + " per=hour until=2027-01-01\n" +
+		domainLine("example.net", 5) + " burst=1 per=month\n" +
+		domainLine("example.org", 5) + " per=day\n")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if got, want := config.AllowedKeys[string(key1[:])], (Limit{
+		Rate: 10, Burst: 10, Period: time.Hour,
+		Until: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+	}); got != want {
+		t.Errorf("got limit %v for key1, wanted %v", got, want)
+	}
+	if got, want := config.AllowedDomains["example.net"], (Limit{Rate: 5, Burst: 1, Period: 30 * 24 * time.Hour}); got != want {
+		t.Errorf("got limit %v for example.net, wanted %v", got, want)
+	}
+	if got, want := config.AllowedDomains["example.org"], (Limit{Rate: 5, Burst: 5}); got != want {
+		t.Errorf("got limit %v for example.org, wanted %v", got, want)
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	for _, table := range []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{" # comment", nil},
+		{"public suffixes.dat 10#comment", []string{"public", "suffixes.dat", "10"}},
+		{"public \"my suffixes.dat\" 10", []string{"public", "my suffixes.dat", "10"}},
+		{"include \"a#b\\\"c\\\\\"", []string{"include", "a#b\"c\\"}},
+		{"include \"\"", []string{"include", ""}},
+	} {
+		got, err := splitFields(table.line)
+		if err != nil {
+			t.Errorf("split of %q failed: %v", table.line, err)
+		} else if !slices.Equal(got, table.want) {
+			t.Errorf("unexpected split of %q, got %q, wanted %q", table.line, got, table.want)
+		}
+	}
+}
+
+func TestParseConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, contents string) string {
+		fileName := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fileName
+	}
+	// Relative includes are relative to the including file.
+	writeFile("partners/a.cfg", domainLine("a.example.com", 10)+"\ninclude \"b file.cfg\"\n")
+	writeFile("partners/b file.cfg", keyLine(&key1, 20)+"\n")
+	main := writeFile("main.cfg", domainLine("example.org", 30)+"\ninclude partners/a.cfg\n")
+
+	config, err := ParseConfigFile(main)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if len(config.AllowedDomains) != 2 || len(config.AllowedKeys) != 1 {
+		t.Errorf("unexpected config: %v", config)
+	}
+
+	// Errors are reported with file name and line number.
+	writeFile("partners/b file.cfg", "\n"+domainLine("example.org", 5)+"\n")
+	_, err = ParseConfigFile(main)
+	if err == nil {
+		t.Fatalf("duplicate domain in included file not detected")
+	}
+	if !strings.Contains(err.Error(), "b file.cfg:2:") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+
+	// Include loop.
+	loop := writeFile("loop.cfg", "include loop.cfg\n")
+	if _, err := ParseConfigFile(loop); err == nil {
+		t.Errorf("include loop not detected")
+	}
+}
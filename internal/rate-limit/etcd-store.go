@@ -0,0 +1,285 @@
+package rateLimit
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// Buckets are stored under the etcd key
+//   <prefix><bucket type>/<hex-encoded key>
+// with the value
+//   <tokens> <last update, unix nanoseconds> <rate> <burst> <period, nanoseconds>
+// Updates are done as a read followed by a transaction conditioned on
+// the key's modification revision, retried on conflict.
+
+// A CounterStore backed by etcd, shared by all frontends using the
+// same etcd cluster and prefix.
+type etcdStore struct {
+	kv etcdKV
+	// Prefix for all keys of this store, ending with "/".
+	prefix  string
+	timeout time.Duration
+
+	// Protects nextPrune.
+	mu        sync.Mutex
+	nextPrune time.Time
+}
+
+type etcdEntry struct {
+	key   string
+	value string
+	// Modification revision, zero if the key doesn't exist.
+	revision int64
+}
+
+// The etcd operations needed by etcdStore. Updates are conditioned
+// on the modification revision of the key, and return false, without
+// any change, if the key was modified concurrently.
+type etcdKV interface {
+	get(ctx context.Context, key string) (etcdEntry, error)
+	list(ctx context.Context, prefix string) ([]etcdEntry, error)
+	putIfUnchanged(ctx context.Context, key, value string, revision int64) (bool, error)
+	deleteIfUnchanged(ctx context.Context, key string, revision int64) (bool, error)
+}
+
+type etcdClient struct {
+	client *clientv3.Client
+}
+
+func (c etcdClient) get(ctx context.Context, key string) (etcdEntry, error) {
+	resp, err := c.client.Get(ctx, key)
+	if err != nil {
+		return etcdEntry{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return etcdEntry{key: key}, nil
+	}
+	return etcdEntry{key: key, value: string(resp.Kvs[0].Value), revision: resp.Kvs[0].ModRevision}, nil
+}
+
+func (c etcdClient) list(ctx context.Context, prefix string) ([]etcdEntry, error) {
+	resp, err := c.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]etcdEntry, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		entries = append(entries, etcdEntry{key: string(kv.Key), value: string(kv.Value), revision: kv.ModRevision})
+	}
+	return entries, nil
+}
+
+func (c etcdClient) putIfUnchanged(ctx context.Context, key, value string, revision int64) (bool, error) {
+	txn, err := c.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+		Then(clientv3.OpPut(key, value)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return txn.Succeeded, nil
+}
+
+func (c etcdClient) deleteIfUnchanged(ctx context.Context, key string, revision int64) (bool, error) {
+	txn, err := c.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return txn.Succeeded, nil
+}
+
+// NewEtcdStores returns a factory for stores kept in etcd, under the
+// given key prefix. Each etcd operation is bounded by timeout. If
+// etcd can't be reached, access is denied.
+func NewEtcdStores(client *clientv3.Client, prefix string, timeout time.Duration) StoreFactory {
+	return newEtcdStores(etcdClient{client}, prefix, timeout)
+}
+
+func newEtcdStores(kv etcdKV, prefix string, timeout time.Duration) StoreFactory {
+	return func(bucket BucketType) CounterStore {
+		return &etcdStore{
+			kv:      kv,
+			prefix:  prefix + bucket.String() + "/",
+			timeout: timeout,
+		}
+	}
+}
+
+func encodeBucket(b *bucket) string {
+	return fmt.Sprintf("%s %d %d %d %d", strconv.FormatFloat(b.tokens, 'g', -1, 64),
+		b.last.UnixNano(), b.limit.Rate, b.limit.Burst, int64(b.limit.Period))
+}
+
+func decodeBucket(value string) (bucket, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 5 {
+		return bucket{}, fmt.Errorf("invalid bucket %q", value)
+	}
+	tokens, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return bucket{}, err
+	}
+	var ints [4]int64
+	for i, f := range fields[1:] {
+		if ints[i], err = strconv.ParseInt(f, 10, 64); err != nil {
+			return bucket{}, err
+		}
+	}
+	return bucket{
+		limit: Limit{
+			Rate:   int(ints[1]),
+			Burst:  int(ints[2]),
+			Period: time.Duration(ints[3]),
+		},
+		tokens: tokens,
+		last:   time.Unix(0, ints[0]),
+	}, nil
+}
+
+func (s *etcdStore) path(key string) string {
+	return s.prefix + hex.EncodeToString([]byte(key))
+}
+
+// Reads the bucket, and calls update. If update returns true, the
+// modified bucket is written back, unless there was a concurrent
+// update, in which case the process is repeated.
+func (s *etcdStore) update(ctx context.Context, path string, update func(b *bucket, found bool) bool) error {
+	for {
+		entry, err := s.kv.get(ctx, path)
+		if err != nil {
+			return err
+		}
+		var b bucket
+		found := entry.revision > 0
+		if found {
+			b, err = decodeBucket(entry.value)
+			if err != nil {
+				return fmt.Errorf("bad value for %q: %v", path, err)
+			}
+		}
+		if !update(&b, found) {
+			return nil
+		}
+		if ok, err := s.kv.putIfUnchanged(ctx, path, encodeBucket(&b), entry.revision); err != nil || ok {
+			return err
+		}
+	}
+}
+
+func (s *etcdStore) AccessAllowed(key string, limit Limit, now time.Time) (func(), Quota) {
+	s.prune(now)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	path := s.path(key)
+	var allowed bool
+	var quota Quota
+	if err := s.update(ctx, path, func(b *bucket, found bool) bool {
+		if found {
+			b.refill(now)
+			b.setLimit(limit)
+		} else {
+			*b = bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		}
+		allowed = b.take()
+		quota = b.quota(!allowed)
+		// Nothing to write back, if no token was consumed.
+		return allowed
+	}); err != nil {
+		log.Error("rate limit store failed, denying access: %v", err)
+		return nil, Quota{}
+	}
+	if !allowed {
+		return nil, quota
+	}
+	return func() { s.accessRelax(path) }, quota
+}
+
+func (s *etcdStore) accessRelax(path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.update(ctx, path, func(b *bucket, found bool) bool {
+		// Bucket may be missing, if it was deleted since the
+		// token was consumed.
+		if !found {
+			return false
+		}
+		b.tokens = min(float64(b.limit.Burst), b.tokens+1)
+		return true
+	}); err != nil {
+		log.Error("returning rate limit token failed: %v", err)
+	}
+}
+
+// Deletes all buckets for which del returns true. Buckets modified
+// concurrently are left alone.
+func (s *etcdStore) deleteIf(ctx context.Context, del func(key string, b *bucket) bool) error {
+	entries, err := s.kv.list(ctx, s.prefix)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		key, err := hex.DecodeString(strings.TrimPrefix(entry.key, s.prefix))
+		if err != nil {
+			log.Warning("ignoring bad rate limit key %q: %v", entry.key, err)
+			continue
+		}
+		b, err := decodeBucket(entry.value)
+		if err != nil {
+			log.Warning("ignoring bad rate limit bucket %q: %v", entry.key, err)
+			continue
+		}
+		if !del(string(key), &b) {
+			continue
+		}
+		if _, err := s.kv.deleteIfUnchanged(ctx, entry.key, entry.revision); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deletes full buckets, at most once per prunePeriod. Done in the
+// background, to not delay the current request.
+func (s *etcdStore) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Before(s.nextPrune) {
+		return
+	}
+	s.nextPrune = now.Add(prunePeriod)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		if err := s.deleteIf(ctx, func(_ string, b *bucket) bool {
+			b.refill(now)
+			return b.tokens >= float64(b.limit.Burst)
+		}); err != nil {
+			log.Warning("pruning rate limit buckets failed: %v", err)
+		}
+	}()
+}
+
+// Retain and Reset are called when a frontend reloads its
+// configuration. The buckets are shared with other frontends, which
+// may still use the old configuration, or reload later, so they are
+// left alone. Buckets no longer in use are deleted by prune, once
+// refilled.
+func (s *etcdStore) Retain(_ func(key string) bool) {}
+
+func (s *etcdStore) Reset() {}
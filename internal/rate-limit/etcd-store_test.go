@@ -0,0 +1,258 @@
+package rateLimit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// In-memory etcdKV, with the same revision semantics as etcd.
+type fakeKV struct {
+	mu       sync.Mutex
+	entries  map[string]etcdEntry
+	revision int64
+	// Number of upcoming updates to fail with a conflict, as if
+	// the key was modified concurrently.
+	conflicts int
+	err       error
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{entries: make(map[string]etcdEntry)}
+}
+
+func (kv *fakeKV) get(_ context.Context, key string) (etcdEntry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.err != nil {
+		return etcdEntry{}, kv.err
+	}
+	if entry, ok := kv.entries[key]; ok {
+		return entry, nil
+	}
+	return etcdEntry{key: key}, nil
+}
+
+func (kv *fakeKV) list(_ context.Context, prefix string) ([]etcdEntry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.err != nil {
+		return nil, kv.err
+	}
+	var entries []etcdEntry
+	for key, entry := range kv.entries {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Must be called with lock held.
+func (kv *fakeKV) unchanged(key string, revision int64) bool {
+	if kv.conflicts > 0 {
+		kv.conflicts--
+		return false
+	}
+	return kv.entries[key].revision == revision
+}
+
+func (kv *fakeKV) putIfUnchanged(_ context.Context, key, value string, revision int64) (bool, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.err != nil {
+		return false, kv.err
+	}
+	if !kv.unchanged(key, revision) {
+		return false, nil
+	}
+	kv.revision++
+	kv.entries[key] = etcdEntry{key: key, value: value, revision: kv.revision}
+	return true, nil
+}
+
+func (kv *fakeKV) deleteIfUnchanged(_ context.Context, key string, revision int64) (bool, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.err != nil {
+		return false, kv.err
+	}
+	if !kv.unchanged(key, revision) {
+		return false, nil
+	}
+	delete(kv.entries, key)
+	return true, nil
+}
+
+// Returns the number of tokens in the stored bucket, or -1 if
+// there's no bucket.
+func (kv *fakeKV) tokens(t *testing.T, key string) float64 {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	entry, ok := kv.entries[key]
+	if !ok {
+		return -1
+	}
+	b, err := decodeBucket(entry.value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.tokens
+}
+
+// Returns a store, with pruning postponed until later than now.
+func newTestEtcdStore(kv etcdKV, now time.Time) *etcdStore {
+	s := newEtcdStores(kv, "/test/", time.Second)(BucketKey).(*etcdStore)
+	s.nextPrune = now.Add(time.Hour)
+	return s
+}
+
+func TestEncodeBucket(t *testing.T) {
+	for _, b := range []bucket{
+		{limit: Limit{Rate: 10, Burst: 5}, tokens: 2.5, last: time.Unix(1000, 17)},
+		{limit: Limit{Rate: 1, Burst: 1, Period: time.Hour}, tokens: 0, last: time.Unix(0, 0)},
+	} {
+		got, err := decodeBucket(encodeBucket(&b))
+		if err != nil {
+			t.Errorf("decoding %q failed: %v", encodeBucket(&b), err)
+		} else if got.limit != b.limit || got.tokens != b.tokens || !got.last.Equal(b.last) {
+			t.Errorf("unexpected bucket after round trip, got %#v, wanted %#v", got, b)
+		}
+	}
+	for _, s := range []string{
+		"",
+		"1 1000 10 5",
+		"x 1000 10 5 0",
+		"1 1000 10 5 0 0",
+		"1 1000 10 5.5 0",
+	} {
+		if _, err := decodeBucket(s); err == nil {
+			t.Errorf("accepted invalid bucket %q", s)
+		}
+	}
+}
+
+func TestEtcdStorePath(t *testing.T) {
+	s := NewEtcdStores(nil, "/sigsum-log/rate-limit/", time.Second)(BucketDomain).(*etcdStore)
+	if got, want := s.path("example.org"), "/sigsum-log/rate-limit/domain/6578616d706c652e6f7267"; got != want {
+		t.Errorf("unexpected path, got %q, wanted %q", got, want)
+	}
+}
+
+func TestEtcdStoreAccessAllowed(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 2, Period: time.Hour}
+	now := time.Unix(1000, 0)
+	kv := newFakeKV()
+	// Two frontends, sharing the buckets.
+	a, b := newTestEtcdStore(kv, now), newTestEtcdStore(kv, now)
+	path := a.path("foo")
+
+	relax, _ := a.AccessAllowed("foo", limit, now)
+	if relax == nil {
+		t.Fatalf("first access denied")
+	}
+	if relax, _ := b.AccessAllowed("foo", limit, now); relax == nil {
+		t.Fatalf("second access denied")
+	}
+	if relax, quota := a.AccessAllowed("foo", limit, now); relax != nil {
+		t.Errorf("third access allowed")
+	} else if quota.RetryAfter != time.Hour {
+		t.Errorf("unexpected retry after, got %v, wanted %v", quota.RetryAfter, time.Hour)
+	}
+	if got := kv.tokens(t, path); got != 0 {
+		t.Errorf("unexpected tokens, got %v, wanted 0", got)
+	}
+	relax()
+	if got := kv.tokens(t, path); got != 1 {
+		t.Errorf("token not returned, got %v tokens, wanted 1", got)
+	}
+	if relax, _ := b.AccessAllowed("foo", limit, now.Add(time.Hour)); relax == nil {
+		t.Errorf("access denied after refill")
+	}
+	if got := kv.tokens(t, path); got != 1 {
+		t.Errorf("unexpected tokens after refill, got %v, wanted 1", got)
+	}
+}
+
+func TestEtcdStoreConflict(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 2, Period: time.Hour}
+	now := time.Unix(1000, 0)
+	kv := newFakeKV()
+	s := newTestEtcdStore(kv, now)
+	kv.conflicts = 3
+	if relax, _ := s.AccessAllowed("foo", limit, now); relax == nil {
+		t.Fatalf("access denied")
+	}
+	if kv.conflicts != 0 {
+		t.Errorf("update not retried")
+	}
+	if got := kv.tokens(t, s.path("foo")); got != 1 {
+		t.Errorf("unexpected tokens, got %v, wanted 1", got)
+	}
+}
+
+func TestEtcdStoreFailure(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 2, Period: time.Hour}
+	now := time.Unix(1000, 0)
+	kv := newFakeKV()
+	s := newTestEtcdStore(kv, now)
+	kv.err = errors.New("unavailable")
+	if relax, _ := s.AccessAllowed("foo", limit, now); relax != nil {
+		t.Errorf("access allowed, despite failing store")
+	}
+}
+
+func TestEtcdStoreReload(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 1, Period: time.Hour}
+	now := time.Unix(1000, 0)
+	kv := newFakeKV()
+	a, b := newTestEtcdStore(kv, now), newTestEtcdStore(kv, now)
+	if relax, _ := a.AccessAllowed("foo", limit, now); relax == nil {
+		t.Fatalf("access denied")
+	}
+	// Frontend b reloads, with a config where foo is no longer
+	// allowed. That mustn't affect frontend a.
+	b.Retain(func(string) bool { return false })
+	b.Reset()
+	if got := kv.tokens(t, a.path("foo")); got != 0 {
+		t.Errorf("bucket modified by reload, got %v tokens, wanted 0", got)
+	}
+	if relax, _ := a.AccessAllowed("foo", limit, now); relax != nil {
+		t.Errorf("access allowed after reload by other frontend")
+	}
+}
+
+func TestEtcdStorePrune(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 2, Period: time.Hour}
+	now := time.Unix(1000, 0)
+	kv := newFakeKV()
+	s := newTestEtcdStore(kv, now)
+	for _, key := range []string{"foo", "bar", "bar"} {
+		if relax, _ := s.AccessAllowed(key, limit, now); relax == nil {
+			t.Fatalf("access for %q denied", key)
+		}
+	}
+	// Not a valid bucket key, should be left alone.
+	if _, err := kv.putIfUnchanged(context.Background(), "/test/key/x", "1 0 1 1 0", 0); err != nil {
+		t.Fatal(err)
+	}
+	// After an hour, foo's bucket is full, while bar's isn't.
+	s.nextPrune = time.Time{}
+	s.prune(now.Add(time.Hour))
+	deadline := time.Now().Add(5 * time.Second)
+	for kv.tokens(t, s.path("foo")) >= 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("full bucket not pruned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := kv.tokens(t, s.path("bar")); got != 0 {
+		t.Errorf("unexpected tokens for bar, got %v, wanted 0", got)
+	}
+	if got := kv.tokens(t, "/test/key/x"); got != 1 {
+		t.Errorf("invalid key not left alone")
+	}
+}
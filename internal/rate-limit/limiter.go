@@ -1,10 +1,11 @@
 package rateLimit
 
 import (
-	"io"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"sigsum.org/sigsum-go/pkg/crypto"
@@ -19,21 +20,81 @@ import (
 // for test purposes.
 const testDomain = "test.sigsum.org"
 
+type BucketType int
+
+const (
+	// No bucket applies, access is denied.
+	BucketNone BucketType = iota
+	BucketKey
+	BucketDomain
+	// Bucket for a registered domain, under the public limit.
+	BucketPublic
+)
+
+func (t BucketType) String() string {
+	switch t {
+	case BucketNone:
+		return "none"
+	case BucketKey:
+		return "key"
+	case BucketDomain:
+		return "domain"
+	case BucketPublic:
+		return "public"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// State of the token bucket that was applied to a request.
+type Quota struct {
+	Bucket BucketType
+	// Hex key hash, domain, or registered domain identifying the
+	// bucket.
+	Name  string
+	Limit Limit
+	// Number of whole tokens left in the bucket.
+	Remaining int
+	// Time until the bucket is full again.
+	Reset time.Duration
+	// For a denied request, time until a token is available. Zero
+	// if the bucket is never refilled.
+	RetryAfter time.Duration
+}
+
 type Limiter interface {
-	// Checks if access count is < limit. If so increment count
-	// and returns a function that can be called to undo the increment, in case no
-	// resources were consumed. Otherwise, returns nil.
-	AccessAllowed(domain *string, keyHash *crypto.Hash) func()
+	// Checks if the applicable token bucket is non-empty. If so,
+	// consumes a token and returns a function that can be called
+	// to return it, in case no resources were consumed.
+	// Otherwise, returns nil. In both cases, also returns the
+	// state of the applicable bucket.
+	AccessAllowed(domain *string, keyHash *crypto.Hash) (func(), Quota)
+}
+
+// A Limiter with a configuration that can be replaced at run time,
+// and state that can be saved to file. Saving and restoring state
+// is supported only with local stores.
+type ConfiguredLimiter interface {
+	Limiter
+	// Reads new configuration, and if valid, replaces the old
+	// one. Access counts are kept for keys and domains that are
+	// still configured.
+	Reload(configFile string) error
+	// Atomically replaces the file with a snapshot of the access
+	// counts.
+	SaveState(fileName string) error
+	// Restores access counts saved by SaveState, for keys and
+	// domains that are still configured. Buckets that would have
+	// been refilled completely since they were saved are ignored.
+	LoadState(fileName string) error
 }
 
 type NoLimit struct{}
 
-func (l NoLimit) AccessAllowed(_ *string, _ *crypto.Hash) func() {
-	return func() {}
+func (l NoLimit) AccessAllowed(_ *string, _ *crypto.Hash) (func(), Quota) {
+	return func() {}, Quota{}
 }
 
-var schedulePeriod = 24 * time.Hour
-
 type clock interface {
 	Now() time.Time
 }
@@ -44,97 +105,117 @@ func (_ wallTime) Now() time.Time {
 	return time.Now()
 }
 
-type schedule struct {
-	clock clock
-	sync.Mutex
-	next time.Time
-}
-
-func (s *schedule) IsTime() bool {
-	now := s.clock.Now()
-	s.Lock()
-	defer s.Unlock()
-	if now.Before(s.next) {
-		return false
-	}
-	s.next = s.next.Add(schedulePeriod)
-	return true
+// The configured limits, replaced as a unit on reload. Not modified
+// after construction, hence need no locking.
+type limits struct {
+	allowedKeys    map[string]Limit
+	allowedDomains map[string]Limit
+	allowPublic    Limit
+	domainDb       DomainDb
 }
 
 type limiter struct {
-	allowedKeys    map[string]int
-	allowedDomains map[string]int
-	allowPublic    int
-	domainDb       DomainDb
-	keyCounts      accessCounts
-	domainCounts   accessCounts
-	publicCounts   accessCounts
-
-	resetSchedule schedule
+	allowTestDomain bool
+	clock           clock
+	limits          atomic.Pointer[limits]
+	keyCounts       CounterStore
+	domainCounts    CounterStore
+	publicCounts    CounterStore
 }
 
-// Checks if domain or a suffix of domain is allowed. Second return
+// Checks if domain or a suffix of domain is allowed. Third return
 // value is true if domain was matched by the allow list.
-func (l *limiter) domainAllowed(domain string) (func(), bool) {
+func (l *limiter) domainAllowed(limits *limits, domain string, now time.Time) (func(), Quota, bool) {
 	s := domain
 	for {
-		if limit, ok := l.allowedDomains[s]; ok {
-			return l.domainCounts.AccessAllowed(s, limit), true
+		if limit, ok := limits.allowedDomains[s]; ok && !limit.expired(now) {
+			relax, quota := l.domainCounts.AccessAllowed(s, limit, now)
+			quota.Bucket, quota.Name = BucketDomain, s
+			return relax, quota, true
 		}
 		dot := strings.Index(s, ".")
 		if dot < 0 {
-			return nil, false
+			return nil, Quota{}, false
 		}
 		s = s[dot+1:]
 	}
 }
 
-func (l *limiter) AccessAllowed(submitDomain *string, keyHash *crypto.Hash) func() {
-	if l.resetSchedule.IsTime() {
-		l.keyCounts.Reset()
-		l.domainCounts.Reset()
-		l.publicCounts.Reset()
-	}
+func (l *limiter) AccessAllowed(submitDomain *string, keyHash *crypto.Hash) (func(), Quota) {
+	now := l.clock.Now()
+	limits := l.limits.Load()
 
 	// TODO: Avoid conversion to string.
 	keyHashString := string(keyHash[:])
-	if limit, ok := l.allowedKeys[keyHashString]; ok {
-		return l.keyCounts.AccessAllowed(keyHashString, limit)
+	if limit, ok := limits.allowedKeys[keyHashString]; ok && !limit.expired(now) {
+		relax, quota := l.keyCounts.AccessAllowed(keyHashString, limit, now)
+		quota.Bucket, quota.Name = BucketKey, hex.EncodeToString(keyHash[:])
+		return relax, quota
 	}
 	if submitDomain == nil {
 		// Skip all domain-based checks.
-		return nil
+		return nil, Quota{}
 	}
 	domain, err := token.NormalizeDomainName(*submitDomain)
 	if err != nil {
-		return nil
+		return nil, Quota{}
 	}
-	if relax, ok := l.domainAllowed(domain); ok {
-		return relax
+	if relax, quota, ok := l.domainAllowed(limits, domain, now); ok {
+		return relax, quota
 	}
-	if l.allowPublic <= 0 {
-		return nil
+	if limits.allowPublic.Rate <= 0 || limits.allowPublic.expired(now) {
+		return nil, Quota{}
 	}
 
-	domain, err = l.domainDb.GetRegisteredDomain(domain)
+	domain, err = limits.domainDb.GetRegisteredDomain(domain)
 	if err != nil {
 		// Reject unknown domains.
-		return nil
+		return nil, Quota{}
 	}
-	return l.publicCounts.AccessAllowed(domain, l.allowPublic)
+	relax, quota := l.publicCounts.AccessAllowed(domain, limits.allowPublic, now)
+	quota.Bucket, quota.Name = BucketPublic, domain
+	return relax, quota
 }
 
-func newLimiter(configFile io.Reader, allowTestDomain bool, clock clock) (Limiter, error) {
-	config, err := ParseConfig(configFile)
+func (l *limiter) Reload(configFile string) error {
+	config, err := ParseConfigFile(configFile)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	return l.reload(config)
+}
+
+func (l *limiter) reload(config Config) error {
+	limits, err := loadLimits(config, l.allowTestDomain)
+	if err != nil {
+		return err
+	}
+	l.limits.Store(limits)
+
+	// Drop counts for keys and domains that are no longer
+	// configured.
+	l.keyCounts.Retain(func(key string) bool {
+		_, ok := limits.allowedKeys[key]
+		return ok
+	})
+	l.domainCounts.Retain(func(domain string) bool {
+		_, ok := limits.allowedDomains[domain]
+		return ok
+	})
+	if limits.allowPublic.Rate <= 0 {
+		l.publicCounts.Reset()
+	}
+	return nil
+}
+
+func loadLimits(config Config, allowTestDomain bool) (*limits, error) {
 	var db DomainDb
-	if config.AllowPublic > 0 {
+	if config.AllowPublic.Rate > 0 {
 		f, err := os.Open(config.PublicSuffixFile)
 		if err != nil {
 			return nil, err
 		}
+		defer f.Close()
 		db, err = NewDomainDb(f)
 		if err != nil {
 			return nil, err
@@ -142,27 +223,39 @@ func newLimiter(configFile io.Reader, allowTestDomain bool, clock clock) (Limite
 	}
 
 	if !allowTestDomain {
-		config.AllowedDomains[strings.ToLower(testDomain)] = 0
+		config.AllowedDomains[strings.ToLower(testDomain)] = Limit{}
 	}
-	l := limiter{
+	return &limits{
 		allowedKeys:    config.AllowedKeys,
 		allowedDomains: config.AllowedDomains,
 		allowPublic:    config.AllowPublic,
 		domainDb:       db,
-		resetSchedule: schedule{
-			clock: clock,
-			next:  clock.Now().Add(schedulePeriod),
-		},
-	}
+	}, nil
+}
 
-	// Initialize the mappings.
-	l.keyCounts.Reset()
-	l.domainCounts.Reset()
-	l.publicCounts.Reset()
+func newLimiter(config Config, allowTestDomain bool, stores StoreFactory, clock clock) (ConfiguredLimiter, error) {
+	limits, err := loadLimits(config, allowTestDomain)
+	if err != nil {
+		return nil, err
+	}
+	l := limiter{
+		allowTestDomain: allowTestDomain,
+		clock:           clock,
+		keyCounts:       stores(BucketKey),
+		domainCounts:    stores(BucketDomain),
+		publicCounts:    stores(BucketPublic),
+	}
+	l.limits.Store(limits)
 
 	return &l, nil
 }
 
-func NewLimiter(configFile io.Reader, allowTestDomain bool) (Limiter, error) {
-	return newLimiter(configFile, allowTestDomain, wallTime{})
+// NewLimiter creates a limiter, with buckets kept in the stores
+// returned by the factory, e.g., LocalStore.
+func NewLimiter(configFile string, allowTestDomain bool, stores StoreFactory) (ConfiguredLimiter, error) {
+	config, err := ParseConfigFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	return newLimiter(config, allowTestDomain, stores, wallTime{})
 }
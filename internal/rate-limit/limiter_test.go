@@ -3,6 +3,8 @@ package rateLimit
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -27,8 +29,27 @@ func (c *fakeClock) Advance(delta time.Duration) {
 	c.now = c.now.Add(delta)
 }
 
-func newTestLimiter(config string, clock clock) (Limiter, error) {
-	return newLimiter(bytes.NewBuffer([]byte(config)), false, clock)
+func newTestLimiter(config string, clock clock) (ConfiguredLimiter, error) {
+	c, err := ParseConfig(bytes.NewBufferString(config))
+	if err != nil {
+		return nil, err
+	}
+	return newLimiter(c, false, LocalStore, clock)
+}
+
+// Writes config to a temporary file, and returns its name.
+func writeConfigFile(t *testing.T, config string) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "rate-limit.cfg")
+	if err := os.WriteFile(fileName, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func accessAllowed(l Limiter, domain *string, keyHash *crypto.Hash) func() {
+	relax, _ := l.AccessAllowed(domain, keyHash)
+	return relax
 }
 
 type request struct {
@@ -47,7 +68,7 @@ func repeatedAccess(t *testing.T, config string, count int, requests []request)
 	}
 	for i := 0; i < count; i++ {
 		r := &requests[i%len(requests)]
-		if limiter.AccessAllowed(r.domain, r.keyHash) == nil {
+		if accessAllowed(limiter, r.domain, r.keyHash) == nil {
 			return i
 		}
 		clock.Advance(r.delay)
@@ -77,8 +98,12 @@ func TestKeyLimit(t *testing.T) {
 		t.Errorf("should sustain one request per hour, but failed after %d requests", got)
 	}
 	if got := repeatedAccess(t, config, 100,
-		[]request{request{domain: nil, keyHash: &key2, delay: time.Hour}}); got != 23 {
-		t.Errorf("limit of 23 request per 24 hours not enforced, %d requests were allowed", got)
+		[]request{request{domain: nil, keyHash: &key2, delay: 0}}); got != 23 {
+		t.Errorf("burst of 23 requests not enforced, %d requests were allowed", got)
+	}
+	if got := repeatedAccess(t, config, 100,
+		[]request{request{domain: nil, keyHash: &key2, delay: 30 * time.Minute}}); got == 100 {
+		t.Errorf("limit of 23 requests per 24 hours not enforced, %d requests were allowed", got)
 	}
 	if got := repeatedAccess(t, config, 100,
 		[]request{
@@ -101,8 +126,8 @@ func TestDomainLimit(t *testing.T) {
 		t.Errorf("should sustain one request per hour, but failed after %d requests", got)
 	}
 	if got := repeatedAccess(t, config, 100,
-		[]request{request{domain: A("foo.Example.ORG"), keyHash: &key, delay: time.Hour}}); got != 23 {
-		t.Errorf("limit of 23 request per 24 hours not enforced, %d requests were allowed", got)
+		[]request{request{domain: A("foo.Example.ORG"), keyHash: &key, delay: 0}}); got != 23 {
+		t.Errorf("burst of 23 requests not enforced, %d requests were allowed", got)
 	}
 	if got := repeatedAccess(t, config, 100,
 		[]request{
@@ -113,8 +138,8 @@ func TestDomainLimit(t *testing.T) {
 	}
 	if got := repeatedAccess(t, config, 100,
 		[]request{
-			request{domain: A("foo.Example.org"), keyHash: &key, delay: time.Hour},
-			request{domain: A("under.foo.Example.org"), keyHash: &key, delay: time.Hour},
+			request{domain: A("foo.Example.org"), keyHash: &key, delay: 0},
+			request{domain: A("under.foo.Example.org"), keyHash: &key, delay: 0},
 		}); got != 23 {
 		t.Errorf("limit of 23 request applies also to subdomains, but failed after %d requests", got)
 	}
@@ -138,15 +163,15 @@ func TestPublicLimit(t *testing.T) {
 		t.Errorf("unknown suffixes should be denied, but %d requests were allowed", got)
 	}
 	if got := repeatedAccess(t, config, 100,
-		[]request{request{domain: A("foo.Example.org"), keyHash: &key, delay: time.Hour}}); got != 23 {
-		t.Errorf("limit of 23 request per 24 hours not enforced, %d requests were allowed", got)
+		[]request{request{domain: A("foo.Example.org"), keyHash: &key, delay: 0}}); got != 23 {
+		t.Errorf("burst of 23 requests not enforced, %d requests were allowed", got)
 	}
 	if got := repeatedAccess(t, config, 100,
 		[]request{
-			request{domain: A("foo.Example.org"), keyHash: &key, delay: time.Hour},
-			request{domain: A("bar.Example.ORG"), keyHash: &key, delay: time.Hour},
+			request{domain: A("foo.Example.org"), keyHash: &key, delay: 0},
+			request{domain: A("bar.Example.ORG"), keyHash: &key, delay: 0},
 		}); got != 23 {
-		t.Errorf("limit of 23 request (on example.org domains) per 24 hours not enforced, %d requests were allowed", got)
+		t.Errorf("burst of 23 requests (on example.org domains) not enforced, %d requests were allowed", got)
 	}
 	if got := repeatedAccess(t, config, 100,
 		[]request{
@@ -168,3 +193,142 @@ func TestPublicLimit(t *testing.T) {
 	}
 
 }
+
+func TestBurstLimit(t *testing.T) {
+	key := crypto.Hash{1}
+	// Refilled with one token per hour.
+	config := fmt.Sprintf("key %x 24 burst=5\n", key)
+	if got := repeatedAccess(t, config, 100,
+		[]request{request{domain: nil, keyHash: &key, delay: 0}}); got != 5 {
+		t.Errorf("burst of 5 requests not enforced, %d requests were allowed", got)
+	}
+	if got := repeatedAccess(t, config, 100,
+		[]request{request{domain: nil, keyHash: &key, delay: time.Hour}}); got != 100 {
+		t.Errorf("should sustain one request per hour, but failed after %d requests", got)
+	}
+	// With one request every 30 minutes, the bucket is drained
+	// by half a token per request.
+	if got := repeatedAccess(t, config, 100,
+		[]request{request{domain: nil, keyHash: &key, delay: 30 * time.Minute}}); got != 9 {
+		t.Errorf("unexpected number of requests at two per hour, %d requests were allowed", got)
+	}
+}
+
+func TestPeriodLimit(t *testing.T) {
+	key := crypto.Hash{1}
+	config := fmt.Sprintf("key %x 2 per=hour\n", key)
+	if got := repeatedAccess(t, config, 100,
+		[]request{request{domain: nil, keyHash: &key, delay: 30 * time.Minute}}); got != 100 {
+		t.Errorf("should sustain two requests per hour, but failed after %d requests", got)
+	}
+	if got := repeatedAccess(t, config, 100,
+		[]request{request{domain: nil, keyHash: &key, delay: 20 * time.Minute}}); got == 100 {
+		t.Errorf("limit of two requests per hour not enforced")
+	}
+}
+
+func TestExpiredLimit(t *testing.T) {
+	A := func(s string) *string { return &s }
+	clock := &fakeClock{now: time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC)}
+	limiter, err := newTestLimiter("domain foo.example.com 10 until=2027-01-01\n"+
+		"domain example.com 1\n", clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, quota := limiter.AccessAllowed(A("foo.example.com"), &crypto.Hash{}); quota.Name != "foo.example.com" {
+		t.Errorf("unexpected bucket before expiry: %#v", quota)
+	}
+	clock.Advance(time.Hour)
+	// Falls back to the parent domain.
+	if _, quota := limiter.AccessAllowed(A("foo.example.com"), &crypto.Hash{}); quota.Name != "example.com" {
+		t.Errorf("unexpected bucket after expiry: %#v", quota)
+	}
+}
+
+func TestQuotaBucket(t *testing.T) {
+	A := func(s string) *string { return &s }
+	key := crypto.Hash{1}
+	config := fmt.Sprintf("key %x 10\ndomain example.com 20\npublic test_suffix_list.dat 30\n", key)
+	limiter, err := newTestLimiter(config, &fakeClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []struct {
+		domain  *string
+		keyHash *crypto.Hash
+		bucket  BucketType
+		name    string
+		rate    int
+	}{
+		{nil, &key, BucketKey, fmt.Sprintf("%x", key), 10},
+		{A("foo.example.com"), &key, BucketKey, fmt.Sprintf("%x", key), 10},
+		{A("foo.example.com"), &crypto.Hash{}, BucketDomain, "example.com", 20},
+		{A("foo.Example.org"), &crypto.Hash{}, BucketPublic, "example.org", 30},
+		{A("foo.example.info"), &crypto.Hash{}, BucketNone, "", 0},
+		{nil, &crypto.Hash{}, BucketNone, "", 0},
+	} {
+		_, quota := limiter.AccessAllowed(table.domain, table.keyHash)
+		if quota.Bucket != table.bucket || quota.Name != table.name || quota.Limit.Rate != table.rate {
+			t.Errorf("unexpected quota for domain %v, key %x: %#v", table.domain, *table.keyHash, quota)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	key1 := crypto.Hash{1}
+	key2 := crypto.Hash{2}
+	domain := "foo.example.com"
+	limiter, err := newTestLimiter(fmt.Sprintf("key %x 2\nkey %x 2\ndomain %s 2\n", key1, key2, domain), &fakeClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, keyHash := range []*crypto.Hash{&key1, &key2} {
+		if accessAllowed(limiter, nil, keyHash) == nil {
+			t.Fatalf("access for key %x denied", *keyHash)
+		}
+	}
+	if accessAllowed(limiter, &domain, &crypto.Hash{}) == nil {
+		t.Fatalf("access for domain denied")
+	}
+	reloadable := limiter.(ConfiguredLimiter)
+	if err := reloadable.Reload(writeConfigFile(t, "key 01 2\n")); err == nil {
+		t.Fatalf("reload of invalid config unexpectedly succeeded")
+	}
+	// Old config still in use, with counts kept.
+	if accessAllowed(limiter, nil, &key1) == nil || accessAllowed(limiter, nil, &key1) != nil {
+		t.Errorf("unexpected access for key %x after failed reload", key1)
+	}
+
+	// Same limit for key2 and domain, new limit for key1.
+	if err := reloadable.Reload(writeConfigFile(t,
+		fmt.Sprintf("key %x 3\nkey %x 2\ndomain %s 2\n", key1, key2, domain))); err != nil {
+		t.Fatal(err)
+	}
+	// Count for key1 is kept, now with higher limit.
+	if accessAllowed(limiter, nil, &key1) == nil || accessAllowed(limiter, nil, &key1) != nil {
+		t.Errorf("unexpected access for key %x after reload", key1)
+	}
+	if accessAllowed(limiter, nil, &key2) == nil || accessAllowed(limiter, nil, &key2) != nil {
+		t.Errorf("unexpected access for key %x after reload", key2)
+	}
+	if accessAllowed(limiter, &domain, &crypto.Hash{}) == nil || accessAllowed(limiter, &domain, &crypto.Hash{}) != nil {
+		t.Errorf("unexpected access for domain after reload")
+	}
+
+	// Remove key2, and add it back, resetting its count.
+	if err := reloadable.Reload(writeConfigFile(t, fmt.Sprintf("key %x 3\n", key1))); err != nil {
+		t.Fatal(err)
+	}
+	if accessAllowed(limiter, nil, &key2) != nil {
+		t.Errorf("access for removed key %x allowed", key2)
+	}
+	if accessAllowed(limiter, &domain, &crypto.Hash{}) != nil {
+		t.Errorf("access for removed domain allowed")
+	}
+	if err := reloadable.Reload(writeConfigFile(t, fmt.Sprintf("key %x 1\n", key2))); err != nil {
+		t.Fatal(err)
+	}
+	if accessAllowed(limiter, nil, &key2) == nil {
+		t.Errorf("access for re-added key %x denied", key2)
+	}
+}
@@ -0,0 +1,134 @@
+package rateLimit
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time allowed for a trusted proxy to send the PROXY header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// Max length of a version 1 PROXY header, including CRLF.
+const maxProxyHeaderLength = 107
+
+type proxyListener struct {
+	net.Listener
+	config *ReadConfig
+}
+
+// NewProxyListener wraps a listener to accept the PROXY protocol
+// (version 1, text format), from the configured trusted proxies.
+// Connections from trusted proxies must start with a PROXY header,
+// and the source address from the header is used as the connection's
+// remote address. Other connections are passed through unchanged.
+func NewProxyListener(l net.Listener, config ReadConfig) net.Listener {
+	return &proxyListener{Listener: l, config: &config}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, config: l.config}, nil
+}
+
+// The PROXY header is read lazily, since Accept must not block on
+// a single slow connection.
+type proxyConn struct {
+	net.Conn
+	config *ReadConfig
+	once   sync.Once
+	reader *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.reader = bufio.NewReader(c.Conn)
+		c.remote = c.Conn.RemoteAddr()
+		addrPort, err := netip.ParseAddrPort(c.remote.String())
+		if err != nil || !c.config.trusted(addrPort.Addr()) {
+			return
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+		source, err := readProxyHeader(c.reader)
+		if err != nil {
+			c.err = fmt.Errorf("invalid PROXY header from %v: %v", c.remote, err)
+			return
+		}
+		if source != nil {
+			c.remote = source
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remote
+}
+
+// Reads a version 1 PROXY header. Returns the source address, or nil
+// for the UNKNOWN protocol.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxProxyHeaderLength {
+			return nil, fmt.Errorf("header too long")
+		}
+	}
+	header, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, fmt.Errorf("missing CRLF")
+	}
+	fields := strings.Split(header, " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("missing PROXY keyword")
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid number of fields")
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	if addr.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("address %v doesn't match protocol %s", addr, fields[1])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
@@ -0,0 +1,86 @@
+package rateLimit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	for _, table := range []struct {
+		input string
+		want  string // Empty for UNKNOWN, "error" for failure.
+	}{
+		{"PROXY TCP4 192.0.2.1 192.0.2.2 5000 80\r\n", "192.0.2.1:5000"},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 5000 80\r\n", "[2001:db8::1]:5000"},
+		{"PROXY UNKNOWN\r\n", ""},
+		{"PROXY UNKNOWN 2001:db8::1 2001:db8::2 5000 80\r\n", ""},
+		{"PROXY TCP4 192.0.2.1 192.0.2.2 5000 80\n", "error"},
+		{"PROXY TCP4 2001:db8::1 2001:db8::2 5000 80\r\n", "error"},
+		{"PROXY TCP4 192.0.2.1 192.0.2.2 5000\r\n", "error"},
+		{"PROXY TCP4 192.0.2.1 192.0.2.2 70000 80\r\n", "error"},
+		{"PROXY UDP4 192.0.2.1 192.0.2.2 5000 80\r\n", "error"},
+		{"GET / HTTP/1.1\r\n", "error"},
+		{"PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n", "error"},
+	} {
+		addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(table.input)))
+		got := ""
+		if err != nil {
+			got = "error"
+		} else if addr != nil {
+			got = addr.String()
+		}
+		if got != table.want {
+			t.Errorf("unexpected result for %q, got %q (err %v), wanted %q", table.input, got, err, table.want)
+		}
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	for _, table := range []struct {
+		trusted string
+		data    string
+		remote  string // Empty for the real address.
+		body    string
+	}{
+		{"127.0.0.0/8", "PROXY TCP4 192.0.2.1 127.0.0.1 5000 80\r\nhello", "192.0.2.1:5000", "hello"},
+		// Header not interpreted if not from trusted proxy.
+		{"10.0.0.0/8", "PROXY TCP4 192.0.2.1 127.0.0.1 5000 80\r\nhello", "", "PROXY TCP4 192.0.2.1 127.0.0.1 5000 80\r\nhello"},
+	} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		pl := NewProxyListener(l, ReadConfig{TrustedProxies: []netip.Prefix{netip.MustParsePrefix(table.trusted)}})
+		go func() {
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			c.Write([]byte(table.data))
+		}()
+		conn, err := pl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := table.remote
+		if want == "" {
+			want = conn.(*proxyConn).Conn.RemoteAddr().String()
+		}
+		if got := conn.RemoteAddr().String(); got != want {
+			t.Errorf("unexpected remote address %q, wanted %q", got, want)
+		}
+		body, err := io.ReadAll(conn)
+		if err != nil {
+			t.Errorf("read failed: %v", err)
+		} else if string(body) != table.body {
+			t.Errorf("unexpected data %q, wanted %q", body, table.body)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,161 @@
+package rateLimit
+
+import (
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// Endpoints subject to per-source limits, since they may be
+// expensive for the backend.
+var readEndpoints = map[string]bool{
+	"get-leaves":            true,
+	"get-inclusion-proof":   true,
+	"get-consistency-proof": true,
+	// tlog-tiles hash tiles and entry bundles.
+	"tile": true,
+}
+
+type ReadConfig struct {
+	// Number of requests per minute from each source address to
+	// the read endpoints. Zero means no limit.
+	Rate int
+	// Bucket size, zero means same as Rate.
+	Burst int
+	// Maximum number of concurrent requests to any endpoint.
+	// Zero means no limit.
+	MaxInFlight int
+	// If non-nil, requests for which it returns true aren't
+	// counted against MaxInFlight. Intended for long-polling
+	// requests, which would otherwise hold the slots needed by
+	// other requests.
+	Exempt func(*http.Request) bool
+	// Proxies trusted to report the original source address,
+	// in X-Forwarded-For headers or using the PROXY protocol.
+	TrustedProxies []netip.Prefix
+}
+
+func (c *ReadConfig) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range c.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type readLimitHandler struct {
+	handler http.Handler
+	// Prefix of all endpoint paths, e.g., "/" or "/prefix/".
+	pattern string
+	config  ReadConfig
+	// Per-source limit.
+	limit    Limit
+	counts   accessCounts
+	inFlight chan struct{}
+	clock    clock
+}
+
+// NewReadLimitHandler wraps a log server handler, enforcing per-source
+// limits for the read endpoints, and a cap on the number of requests
+// in flight.
+func NewReadLimitHandler(handler http.Handler, pattern string, config ReadConfig) http.Handler {
+	return newReadLimitHandler(handler, pattern, config, wallTime{})
+}
+
+func newReadLimitHandler(handler http.Handler, pattern string, config ReadConfig, clock clock) *readLimitHandler {
+	h := readLimitHandler{
+		handler: handler,
+		pattern: pattern,
+		config:  config,
+		clock:   clock,
+	}
+	if config.Rate > 0 {
+		burst := config.Burst
+		if burst == 0 {
+			burst = config.Rate
+		}
+		h.limit = Limit{
+			Rate:   config.Rate,
+			Burst:  burst,
+			Period: time.Minute,
+		}
+	}
+	if config.MaxInFlight > 0 {
+		h.inFlight = make(chan struct{}, config.MaxInFlight)
+	}
+	h.counts.Reset()
+	return &h
+}
+
+// Returns the address of the client. For requests via trusted
+// proxies, that's the right-most untrusted address in the
+// X-Forwarded-For headers.
+func (h *readLimitHandler) sourceAddr(r *http.Request) (netip.Addr, bool) {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr := addrPort.Addr().Unmap()
+	if !h.config.trusted(addr) {
+		return addr, true
+	}
+	forwarded := r.Header.Values("X-Forwarded-For")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hops := strings.Split(forwarded[i], ",")
+		for j := len(hops) - 1; j >= 0; j-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[j]))
+			if err != nil {
+				// Can't trust anything further left.
+				return addr, true
+			}
+			addr = hop.Unmap()
+			if !h.config.trusted(addr) {
+				return addr, true
+			}
+		}
+	}
+	return addr, true
+}
+
+func (h *readLimitHandler) isReadEndpoint(path string) bool {
+	rest, ok := strings.CutPrefix(path, h.pattern)
+	if !ok {
+		return false
+	}
+	endpoint, _, _ := strings.Cut(rest, "/")
+	return readEndpoints[endpoint]
+}
+
+func (h *readLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.inFlight != nil && (h.config.Exempt == nil || !h.config.Exempt(r)) {
+		select {
+		case h.inFlight <- struct{}{}:
+			defer func() { <-h.inFlight }()
+		default:
+			log.Debug("rejecting request, %d requests in flight", cap(h.inFlight))
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "server overloaded", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	if h.limit.Rate > 0 && h.isReadEndpoint(r.URL.Path) {
+		addr, ok := h.sourceAddr(r)
+		if !ok {
+			http.Error(w, "invalid source address", http.StatusBadRequest)
+			return
+		}
+		if relax, quota := h.counts.AccessAllowed(addr.String(), h.limit, h.clock.Now()); relax == nil {
+			log.Debug("rejecting request from %v, read rate limit exceeded", addr)
+			w.Header().Set("Retry-After", strconv.FormatInt(int64((quota.RetryAfter+time.Second-1)/time.Second), 10))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+	}
+	h.handler.ServeHTTP(w, r)
+}
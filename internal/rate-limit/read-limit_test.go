@@ -0,0 +1,119 @@
+package rateLimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestReadLimit(t *testing.T) {
+	clock := &fakeClock{}
+	h := newReadLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}),
+		"/prefix/", ReadConfig{Rate: 2}, clock)
+	status := func(path, remote string) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, table := range []struct {
+		desc   string
+		path   string
+		remote string
+		want   int
+	}{
+		{"first", "/prefix/get-leaves/0/10", "192.0.2.1:1000", http.StatusOK},
+		{"second", "/prefix/get-inclusion-proof/10/abcd", "192.0.2.1:1001", http.StatusOK},
+		{"limited", "/prefix/get-consistency-proof/1/2", "192.0.2.1:1002", http.StatusTooManyRequests},
+		{"other source", "/prefix/get-leaves/0/10", "192.0.2.2:1000", http.StatusOK},
+		{"other endpoint", "/prefix/get-tree-head", "192.0.2.1:1000", http.StatusOK},
+		{"tile", "/prefix/tile/1/000", "192.0.2.2:1000", http.StatusOK},
+		{"tile limited", "/prefix/tile/entries/000", "192.0.2.2:1000", http.StatusTooManyRequests},
+		{"checkpoint", "/prefix/checkpoint", "192.0.2.2:1000", http.StatusOK},
+	} {
+		if got := status(table.path, table.remote); got != table.want {
+			t.Errorf("%s: unexpected status %d, wanted %d", table.desc, got, table.want)
+		}
+	}
+	clock.Advance(30 * time.Second)
+	if got := status("/prefix/get-leaves/0/10", "192.0.2.1:1000"); got != http.StatusOK {
+		t.Errorf("unexpected status %d after refill", got)
+	}
+}
+
+func TestReadLimitSourceAddr(t *testing.T) {
+	h := newReadLimitHandler(nil, "/", ReadConfig{
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}, &fakeClock{})
+	for _, table := range []struct {
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"192.0.2.1:1000", nil, "192.0.2.1"},
+		{"192.0.2.1:1000", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"10.0.0.1:1000", nil, "10.0.0.1"},
+		{"10.0.0.1:1000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.1:1000", []string{"198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"10.0.0.1:1000", []string{"203.0.113.1, 198.51.100.1", "10.0.0.2"}, "198.51.100.1"},
+		{"10.0.0.1:1000", []string{"198.51.100.1, garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"[::ffff:10.0.0.1]:1000", []string{"198.51.100.1"}, "198.51.100.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/get-leaves/0/1", nil)
+		r.RemoteAddr = table.remote
+		for _, f := range table.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		addr, ok := h.sourceAddr(r)
+		if !ok {
+			t.Errorf("failed for remote %q, forwarded %q", table.remote, table.forwarded)
+		} else if got := addr.String(); got != table.want {
+			t.Errorf("unexpected address for remote %q, forwarded %q, got %s, wanted %s",
+				table.remote, table.forwarded, got, table.want)
+		}
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	block := make(chan struct{})
+	started := make(chan struct{})
+	h := newReadLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		started <- struct{}{}
+		<-block
+	}), "/", ReadConfig{MaxInFlight: 1, Exempt: func(r *http.Request) bool {
+		return r.URL.Path == "/add-leaf"
+	}}, &fakeClock{})
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/get-tree-head", nil))
+		done <- w.Code
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/get-tree-head", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status %d, wanted 503", w.Code)
+	}
+
+	// Exempt requests aren't affected by the cap.
+	go func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/add-leaf", nil))
+		done <- w.Code
+	}()
+	<-started
+
+	close(block)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("unexpected status %d for exempt request", code)
+	}
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("unexpected status %d for first request", code)
+	}
+}
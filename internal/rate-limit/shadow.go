@@ -0,0 +1,59 @@
+package rateLimit
+
+import (
+	"encoding/hex"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+)
+
+// Name recorded for shadow denials not attributed to a configured
+// allow-list entry.
+const OtherName = "other"
+
+type Metrics interface {
+	// Records a request that would have been denied, in shadow
+	// mode. For the key and domain buckets, name is the configured
+	// allow-list entry; for other buckets, it is always
+	// OtherName, to keep the number of distinct names bounded.
+	RecordShadowDenied(bucket BucketType, name string)
+}
+
+// A limiter that evaluates requests as usual, but never denies
+// access. Requests that would have been denied are logged and
+// counted, which is useful for trying out a configuration against
+// real traffic.
+type shadowLimiter struct {
+	ConfiguredLimiter
+	metrics Metrics
+}
+
+func NewShadowLimiter(l ConfiguredLimiter, metrics Metrics) ConfiguredLimiter {
+	return &shadowLimiter{ConfiguredLimiter: l, metrics: metrics}
+}
+
+func (l *shadowLimiter) AccessAllowed(domain *string, keyHash *crypto.Hash) (func(), Quota) {
+	relax, quota := l.ConfiguredLimiter.AccessAllowed(domain, keyHash)
+	if relax != nil {
+		return relax, quota
+	}
+	name := quota.Name
+	if quota.Bucket == BucketNone {
+		if domain != nil {
+			name = *domain
+		} else {
+			name = hex.EncodeToString(keyHash[:])
+		}
+	}
+	log.Info("rate limit shadow mode: would deny request, bucket %s %q", quota.Bucket, name)
+	switch quota.Bucket {
+	case BucketKey, BucketDomain:
+		l.metrics.RecordShadowDenied(quota.Bucket, name)
+	default:
+		// The name is chosen by the submitter, so it
+		// mustn't be used as a metrics label.
+		l.metrics.RecordShadowDenied(quota.Bucket, OtherName)
+	}
+	// No token was consumed, so there's nothing to return.
+	return func() {}, quota
+}
@@ -0,0 +1,54 @@
+package rateLimit
+
+import (
+	"fmt"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+type denials map[string]int
+
+func (d denials) RecordShadowDenied(bucket BucketType, name string) {
+	d[fmt.Sprintf("%s=%s", bucket, name)]++
+}
+
+func TestShadowLimiter(t *testing.T) {
+	A := func(s string) *string { return &s }
+	key := crypto.Hash{1}
+	config := fmt.Sprintf("key %x 2\ndomain example.com 1\npublic test_suffix_list.dat 1\n", key)
+	l, err := newTestLimiter(config, &fakeClock{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := make(denials)
+	limiter := NewShadowLimiter(l, metrics)
+	for i := 0; i < 5; i++ {
+		if accessAllowed(limiter, nil, &key) == nil {
+			t.Errorf("key access denied in shadow mode")
+		}
+		if accessAllowed(limiter, A("foo.example.com"), &crypto.Hash{}) == nil {
+			t.Errorf("domain access denied in shadow mode")
+		}
+		if accessAllowed(limiter, A("foo.example.org"), &crypto.Hash{}) == nil {
+			t.Errorf("public domain denied in shadow mode")
+		}
+		// Distinct names must not add distinct metrics labels.
+		if accessAllowed(limiter, A(fmt.Sprintf("example%d.invalid", i)), &crypto.Hash{}) == nil {
+			t.Errorf("unknown domain denied in shadow mode")
+		}
+	}
+	for name, count := range map[string]int{
+		fmt.Sprintf("key=%x", key): 3,
+		"domain=example.com":       4,
+		"public=other":             4,
+		"none=other":               5,
+	} {
+		if got := metrics[name]; got != count {
+			t.Errorf("unexpected count for %s, got %d, wanted %d", name, got, count)
+		}
+	}
+	if len(metrics) != 4 {
+		t.Errorf("unexpected denials: %v", metrics)
+	}
+}
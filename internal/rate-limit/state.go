@@ -0,0 +1,204 @@
+package rateLimit
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.glasklar.is/sigsum/dependencies/safefile"
+)
+
+// State file syntax is
+//   key=<hash> <tokens> <last update, unix nanoseconds>
+//   domain=<name> <tokens> <last update>
+//   public=<registered domain> <tokens> <last update>
+// with one line per bucket.
+
+type bucketState struct {
+	tokens float64
+	last   time.Time
+}
+
+type limiterState struct {
+	keys    map[string]bucketState
+	domains map[string]bucketState
+	public  map[string]bucketState
+}
+
+func (c *accessCounts) snapshot() map[string]bucketState {
+	c.Lock()
+	defer c.Unlock()
+	m := make(map[string]bucketState)
+	for key, b := range c.buckets {
+		m[key] = bucketState{tokens: b.tokens, last: b.last}
+	}
+	return m
+}
+
+// Adds buckets from saved state, for keys that have a configured
+// limit, refilled up to now. Buckets that would be full by now are
+// skipped.
+func (c *accessCounts) restore(m map[string]bucketState, getLimit func(key string) (Limit, bool), now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	for key, s := range m {
+		limit, ok := getLimit(key)
+		if !ok {
+			continue
+		}
+		b := bucket{
+			limit:  limit,
+			tokens: max(0, min(float64(limit.Burst), s.tokens)),
+			last:   s.last,
+		}
+		b.refill(now)
+		if b.tokens >= float64(limit.Burst) {
+			continue
+		}
+		c.buckets[key] = &b
+	}
+}
+
+func writeBuckets(w io.Writer, keyword string, m map[string]bucketState, formatKey func(string) string) error {
+	for key, s := range m {
+		if _, err := fmt.Fprintf(w, "%s=%s %s %d\n", keyword, formatKey(key),
+			strconv.FormatFloat(s.tokens, 'g', -1, 64), s.last.UnixNano()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *limiterState) ToASCII(w io.Writer) error {
+	if err := writeBuckets(w, "key", s.keys, func(key string) string {
+		return hex.EncodeToString([]byte(key))
+	}); err != nil {
+		return err
+	}
+	identity := func(s string) string { return s }
+	if err := writeBuckets(w, "domain", s.domains, identity); err != nil {
+		return err
+	}
+	return writeBuckets(w, "public", s.public, identity)
+}
+
+func parseBucketState(value string) (string, bucketState, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return "", bucketState{}, fmt.Errorf("invalid bucket %q", value)
+	}
+	tokens, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return "", bucketState{}, err
+	}
+	last, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", bucketState{}, err
+	}
+	return fields[0], bucketState{tokens: tokens, last: time.Unix(0, last)}, nil
+}
+
+func (s *limiterState) FromASCII(r io.Reader) error {
+	s.keys = make(map[string]bucketState)
+	s.domains = make(map[string]bucketState)
+	s.public = make(map[string]bucketState)
+	lineno := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineno++
+		keyword, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			return fmt.Errorf("missing keyword on line %d", lineno)
+		}
+		key, b, err := parseBucketState(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s line %d: %v", keyword, lineno, err)
+		}
+		switch keyword {
+		case "key":
+			binary, err := hex.DecodeString(key)
+			if err != nil {
+				return fmt.Errorf("invalid key line %d: %v", lineno, err)
+			}
+			s.keys[string(binary)] = b
+		case "domain":
+			s.domains[key] = b
+		case "public":
+			s.public[key] = b
+		default:
+			return fmt.Errorf("unknown keyword %q on line %d", keyword, lineno)
+		}
+	}
+	return scanner.Err()
+}
+
+// Returns the in-memory counts, or an error if buckets are kept
+// in a shared store, which needs no saving.
+func (l *limiter) localCounts() (*accessCounts, *accessCounts, *accessCounts, error) {
+	keys, ok1 := l.keyCounts.(*accessCounts)
+	domains, ok2 := l.domainCounts.(*accessCounts)
+	public, ok3 := l.publicCounts.(*accessCounts)
+	if !(ok1 && ok2 && ok3) {
+		return nil, nil, nil, fmt.Errorf("rate limit state is kept in a shared store")
+	}
+	return keys, domains, public, nil
+}
+
+func (l *limiter) SaveState(fileName string) error {
+	keys, domains, public, err := l.localCounts()
+	if err != nil {
+		return err
+	}
+	state := limiterState{
+		keys:    keys.snapshot(),
+		domains: domains.snapshot(),
+		public:  public.snapshot(),
+	}
+	f, err := safefile.Create(fileName, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := state.ToASCII(f); err != nil {
+		return err
+	}
+
+	// Atomically replace old file with new.
+	return f.Commit()
+}
+
+func (l *limiter) LoadState(fileName string) error {
+	keys, domains, public, err := l.localCounts()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var state limiterState
+	if err := state.FromASCII(f); err != nil {
+		return fmt.Errorf("invalid rate limit state file %q: %v", fileName, err)
+	}
+	now := l.clock.Now()
+	limits := l.limits.Load()
+	keys.restore(state.keys, func(key string) (Limit, bool) {
+		limit, ok := limits.allowedKeys[key]
+		return limit, ok
+	}, now)
+	domains.restore(state.domains, func(domain string) (Limit, bool) {
+		limit, ok := limits.allowedDomains[domain]
+		return limit, ok
+	}, now)
+	public.restore(state.public, func(_ string) (Limit, bool) {
+		return limits.allowPublic, limits.allowPublic.Rate > 0
+	}, now)
+	return nil
+}
@@ -0,0 +1,103 @@
+package rateLimit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	key1 := crypto.Hash{1}
+	key2 := crypto.Hash{2}
+	domain := "foo.example.com"
+	// One token per hour, with a burst larger than the rate.
+	config := fmt.Sprintf("key %x 24 burst=48\nkey %x 24\ndomain example.com 24\n", key1, key2)
+	fileName := filepath.Join(t.TempDir(), "rate-limit-state")
+
+	clock := &fakeClock{now: time.Unix(10000, 0)}
+	saved, err := newTestLimiter(config, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saved.LoadState(fileName); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("unexpected result loading non-existing state, err: %v", err)
+	}
+	for i := 0; i < 48; i++ {
+		if accessAllowed(saved, &domain, &key1) == nil {
+			t.Fatalf("access denied after %d requests", i)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		if accessAllowed(saved, &domain, &crypto.Hash{3}) == nil {
+			t.Fatalf("domain access denied after %d requests", i)
+		}
+	}
+	if err := saved.SaveState(fileName); err != nil {
+		t.Fatalf("saving state failed: %v", err)
+	}
+
+	for _, table := range []struct {
+		desc   string
+		config string
+		delay  time.Duration
+		// Expected number of tokens for key1 and example.com,
+		// -1 if not restored.
+		keyTokens, domainTokens float64
+	}{
+		{"restart", config, time.Hour, 1, 21},
+		{"lowered limit", fmt.Sprintf("key %x 5\ndomain example.com 3\n", key1), 0, 0, -1},
+		{"key removed", "domain example.com 24\n", 0, -1, 20},
+		// Longer than the refill period, but the key bucket
+		// isn't full yet.
+		{"partly refilled", config, 25 * time.Hour, 25, -1},
+		{"outdated", config, 48 * time.Hour, -1, -1},
+	} {
+		clock := &fakeClock{now: time.Unix(10000, 0).Add(table.delay)}
+		restored, err := newTestLimiter(table.config, clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := restored.LoadState(fileName); err != nil {
+			t.Fatalf("%s: loading state failed: %v", table.desc, err)
+		}
+		l := restored.(*limiter)
+		if got := l.keyCounts.(*accessCounts).GetTokens(string(key1[:]), clock.now); got != table.keyTokens {
+			t.Errorf("%s: unexpected key tokens, got %v, wanted %v", table.desc, got, table.keyTokens)
+		}
+		if got := l.domainCounts.(*accessCounts).GetTokens("example.com", clock.now); got != table.domainTokens {
+			t.Errorf("%s: unexpected domain tokens, got %v, wanted %v", table.desc, got, table.domainTokens)
+		}
+	}
+}
+
+func TestParseState(t *testing.T) {
+	for _, table := range []struct {
+		desc  string
+		input string
+		valid bool
+	}{
+		{"empty", "", true},
+		{"valid", fmt.Sprintf("key=%x 2.5 1000000\ndomain=example.com 0 1000\npublic=example.org 1 1000\n", crypto.Hash{1}), true},
+		{"old time line", "time=1000\n", false},
+		{"bad keyword", "foo=example.com 1 1000\n", false},
+		{"bad key", "key=xx 1 1000\n", false},
+		{"bad tokens", "domain=example.com x 1000\n", false},
+		{"missing field", "domain=example.com 1\n", false},
+		{"too long line", "domain=" + strings.Repeat("x", 100000) + " 1 1000\n", false},
+	} {
+		var state limiterState
+		err := state.FromASCII(bytes.NewBufferString(table.input))
+		if table.valid && err != nil {
+			t.Errorf("%s: failed: %v", table.desc, err)
+		} else if !table.valid && err == nil {
+			t.Errorf("%s: unexpected success", table.desc)
+		}
+	}
+}
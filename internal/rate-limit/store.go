@@ -0,0 +1,33 @@
+package rateLimit
+
+import (
+	"time"
+)
+
+// Storage for the token buckets of one type. A store may be shared
+// by several log frontends, so that they enforce a single global
+// quota. Implementations must be safe for concurrent use.
+type CounterStore interface {
+	// Consumes a token, if available. Returns a function to
+	// return the token, or nil if access is denied, and the state
+	// of the bucket.
+	AccessAllowed(key string, limit Limit, now time.Time) (func(), Quota)
+	// Keeps only the buckets for keys for which keep returns
+	// true. A shared store may keep other buckets too, since
+	// they may still be in use by other frontends.
+	Retain(keep func(key string) bool)
+	// Deletes all buckets, with the same exception for shared
+	// stores as Retain.
+	Reset()
+}
+
+// Returns the store to use for buckets of the given type.
+type StoreFactory func(bucket BucketType) CounterStore
+
+// LocalStore keeps buckets in memory, private to the process. This
+// is the default.
+func LocalStore(_ BucketType) CounterStore {
+	c := accessCounts{}
+	c.Reset()
+	return &c
+}
@@ -0,0 +1,85 @@
+// Package receipt implements receipts for leaves accepted by the
+// log, signed by the log's key, promising that the leaf will be
+// published before a deadline.
+package receipt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+// Signed data is the namespace, a NUL byte, and the ascii lines
+//
+//	leaf_hash=<hex>
+//	accepted=<unix time>
+//	deadline=<unix time>
+//
+// The NUL byte ensures that the signed data can't be mistaken for a
+// checkpoint.
+const namespace = "sigsum.org/v1/add-leaf-receipt"
+
+// Name of the add-leaf response header carrying the receipt.
+const HeaderName = "Sigsum-Receipt"
+
+type Receipt struct {
+	LeafHash crypto.Hash
+	// Time the leaf was accepted, in seconds since the epoch.
+	Accepted uint64
+	// Time by which the leaf is to be included in a published
+	// tree head.
+	Deadline  uint64
+	Signature crypto.Signature
+}
+
+func (r *Receipt) toSigned() []byte {
+	return []byte(fmt.Sprintf("%s\x00leaf_hash=%x\naccepted=%d\ndeadline=%d\n",
+		namespace, r.LeafHash, r.Accepted, r.Deadline))
+}
+
+// Sign sets the signature of the receipt.
+func (r *Receipt) Sign(signer crypto.Signer) error {
+	sig, err := signer.Sign(r.toSigned())
+	if err != nil {
+		return err
+	}
+	r.Signature = sig
+	return nil
+}
+
+func (r *Receipt) Verify(key *crypto.PublicKey) bool {
+	return crypto.Verify(key, r.toSigned(), &r.Signature)
+}
+
+// ToHeader formats the receipt as a header value, with space
+// separated leaf hash, accepted time, deadline and signature.
+func (r *Receipt) ToHeader() string {
+	return fmt.Sprintf("%x %d %d %x", r.LeafHash, r.Accepted, r.Deadline, r.Signature)
+}
+
+func (r *Receipt) FromHeader(s string) error {
+	fields := strings.Split(s, " ")
+	if len(fields) != 4 {
+		return fmt.Errorf("invalid receipt, expected 4 fields, got %d", len(fields))
+	}
+	leafHash, err := crypto.HashFromHex(fields[0])
+	if err != nil {
+		return fmt.Errorf("invalid receipt leaf hash: %v", err)
+	}
+	accepted, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid receipt accepted time: %v", err)
+	}
+	deadline, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid receipt deadline: %v", err)
+	}
+	sig, err := crypto.SignatureFromHex(fields[3])
+	if err != nil {
+		return fmt.Errorf("invalid receipt signature: %v", err)
+	}
+	*r = Receipt{LeafHash: leafHash, Accepted: accepted, Deadline: deadline, Signature: sig}
+	return nil
+}
@@ -0,0 +1,52 @@
+package receipt
+
+import (
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+)
+
+func TestReceipt(t *testing.T) {
+	pub, signer, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Receipt{LeafHash: crypto.Hash{1}, Accepted: 1000, Deadline: 1600}
+	if err := r.Sign(signer); err != nil {
+		t.Fatalf("signing failed: %v", err)
+	}
+	if !r.Verify(&pub) {
+		t.Errorf("valid receipt not accepted")
+	}
+
+	var parsed Receipt
+	if err := parsed.FromHeader(r.ToHeader()); err != nil {
+		t.Fatalf("parsing header failed: %v", err)
+	}
+	if parsed != r {
+		t.Errorf("unexpected receipt after round trip, got %v, wanted %v", parsed, r)
+	}
+
+	for _, modify := range []func(r *Receipt){
+		func(r *Receipt) { r.LeafHash[0]++ },
+		func(r *Receipt) { r.Accepted++ },
+		func(r *Receipt) { r.Deadline++ },
+	} {
+		bad := r
+		modify(&bad)
+		if bad.Verify(&pub) {
+			t.Errorf("modified receipt accepted: %v", bad)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"01 1000 1600",
+		"xx 1000 1600 00",
+		r.ToHeader() + " 1",
+	} {
+		if err := parsed.FromHeader(s); err == nil {
+			t.Errorf("invalid header %q accepted", s)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package state
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"sigsum.org/sigsum-go/pkg/api"
@@ -18,12 +20,22 @@ type PrimaryTree interface {
 	GetConsistencyProof(context.Context, *requests.ConsistencyProof) (types.ConsistencyProof, error)
 }
 
+// A secondary node, replicating the primary's tree.
+type Secondary struct {
+	// Name, used only for logging.
+	Name      string
+	Client    api.Secondary
+	PublicKey crypto.PublicKey
+}
+
 type ReplicationState struct {
 	// Timeout for interaction with primary and secondary.
-	timeout      time.Duration
-	primary      PrimaryTree
-	secondaryPub crypto.PublicKey
-	secondary    api.Secondary
+	timeout     time.Duration
+	primary     PrimaryTree
+	secondaries []Secondary
+	// Number of secondaries that must have replicated a tree
+	// head, before it can be signed.
+	quorum int
 }
 
 // Return the latest primary tree head with size at least minSize.
@@ -39,12 +51,12 @@ func (r ReplicationState) getPrimaryTreeHead(ctx context.Context, minSize uint64
 }
 
 // Return the latest secondary tree head with size at least minSize.
-func (r ReplicationState) getSecondaryTreeHead(ctx context.Context, minSize uint64, maxSize uint64) (types.TreeHead, error) {
-	sth, err := r.secondary.GetSecondaryTreeHead(ctx)
+func (s *Secondary) getTreeHead(ctx context.Context, minSize uint64, maxSize uint64) (types.TreeHead, error) {
+	sth, err := s.Client.GetSecondaryTreeHead(ctx)
 	if err != nil {
 		return types.TreeHead{}, fmt.Errorf("failed fetching tree head from secondary: %w", err)
 	}
-	if !sth.Verify(&r.secondaryPub) {
+	if !sth.Verify(&s.PublicKey) {
 		return types.TreeHead{}, fmt.Errorf("invalid signature on secondary's tree head")
 	}
 	if sth.Size > maxSize {
@@ -72,9 +84,9 @@ func (r ReplicationState) checkConsistency(ctx context.Context, old *types.TreeH
 	return proof.Verify(old, new)
 }
 
-// Identifies the latest tree head replicated by the secondary, and
-// with size >= minSize, or fails if priamry or secondary is in a bad
-// or too old state.
+// Identifies the latest tree head replicated by at least quorum
+// secondaries, and with size >= minSize, or fails if the primary or
+// too many secondaries are in a bad or too old state.
 func (r ReplicationState) ReplicatedTreeHead(ctx context.Context, minSize uint64) (types.TreeHead, error) {
 	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
@@ -83,18 +95,48 @@ func (r ReplicationState) ReplicatedTreeHead(ctx context.Context, minSize uint64
 	if err != nil {
 		return types.TreeHead{}, err
 	}
-	if primaryTreeHead.Size == minSize || r.secondary == nil {
+	if primaryTreeHead.Size == minSize || len(r.secondaries) == 0 {
 		return primaryTreeHead, nil
 	}
 
-	secTreeHead, err := r.getSecondaryTreeHead(ctx, minSize, primaryTreeHead.Size)
-	if err != nil {
-		return types.TreeHead{}, fmt.Errorf("failed fetching tree head from secondary: %w", err)
+	// Query all secondaries in parallel.
+	type result struct {
+		th  types.TreeHead
+		err error
 	}
+	results := make([]result, len(r.secondaries))
+	var wg sync.WaitGroup
+	for i := range r.secondaries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			th, err := r.secondaries[i].getTreeHead(ctx, minSize, primaryTreeHead.Size)
+			if err == nil {
+				err = r.checkConsistency(ctx, &th, &primaryTreeHead)
+			}
+			results[i] = result{th: th, err: err}
+		}(i)
+	}
+	wg.Wait()
 
-	if err := r.checkConsistency(ctx, &secTreeHead, &primaryTreeHead); err != nil {
-		return types.TreeHead{}, err
+	var replicated []types.TreeHead
+	for i, res := range results {
+		if res.err != nil {
+			log.Warning("secondary %q: %v", r.secondaries[i].Name, res.err)
+			continue
+		}
+		log.Debug("secondary %q: replicated size %d", r.secondaries[i].Name, res.th.Size)
+		replicated = append(replicated, res.th)
+	}
+	if len(replicated) < r.quorum {
+		return types.TreeHead{}, fmt.Errorf("only %d of %d secondaries have a valid tree head, need %d",
+			len(replicated), len(r.secondaries), r.quorum)
 	}
-	log.Debug("using latest tree head from secondary: size %d", secTreeHead.Size)
-	return secTreeHead, nil
+	// All tree heads are consistent with the primary's tree, so
+	// the quorum:th largest one is replicated by at least quorum
+	// secondaries.
+	sort.Slice(replicated, func(i, j int) bool { return replicated[i].Size > replicated[j].Size })
+	th := replicated[r.quorum-1]
+	log.Debug("using tree head replicated by %d secondaries: size %d", r.quorum, th.Size)
+	return th, nil
 }
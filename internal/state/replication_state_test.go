@@ -2,9 +2,12 @@ package state
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
+	memdb "sigsum.org/log-go/internal/db"
 	"sigsum.org/log-go/internal/mocks/db"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/merkle"
@@ -62,12 +65,12 @@ func TestGetSecondaryTreeHead(t *testing.T) {
 	secondary := mockapi.NewMockSecondary(ctrl)
 	secondary.EXPECT().GetSecondaryTreeHead(gomock.Any()).MinTimes(1).Return(sth, nil)
 
-	state := ReplicationState{secondary: secondary, secondaryPub: pub}
+	s := Secondary{Client: secondary, PublicKey: pub}
 	ctx := context.Background()
 
 	for minSize := uint64(3); minSize < 7; minSize++ {
 		for maxSize := uint64(4); maxSize < 8; maxSize++ {
-			got, err := state.getSecondaryTreeHead(ctx, minSize, maxSize)
+			got, err := s.getTreeHead(ctx, minSize, maxSize)
 			if minSize <= 5 && 5 <= maxSize {
 				if err != nil {
 					t.Errorf("getSecondaryTreeHead size %d..%d failed: %v",
@@ -86,6 +89,90 @@ func TestGetSecondaryTreeHead(t *testing.T) {
 	}
 }
 
+func TestReplicatedTreeHead(t *testing.T) {
+	const n = 8
+	primary := memdb.NewMemoryDb()
+	// Tree heads indexed by tree size.
+	treeHeads := []types.TreeHead{{RootHash: merkle.HashEmptyTree()}}
+	for i := 1; i <= n; i++ {
+		if _, err := primary.AddLeaf(nil, &types.Leaf{Checksum: crypto.Hash{uint8(i)}}, 0); err != nil {
+			t.Fatal(err)
+		}
+		th, err := primary.GetTreeHead(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		treeHeads = append(treeHeads, th)
+	}
+
+	const (
+		failing      = -1
+		inconsistent = -2
+	)
+	for _, table := range []struct {
+		desc    string
+		minSize uint64
+		quorum  int
+		sizes   []int
+		want    int // failing for error
+	}{
+		{"no secondaries", 2, 0, nil, n},
+		{"1 of 3", 2, 1, []int{3, 7, 5}, 7},
+		{"2 of 3", 2, 2, []int{3, 7, 5}, 5},
+		{"3 of 3", 2, 3, []int{3, 7, 5}, 3},
+		{"2 of 3, one failing", 2, 2, []int{failing, 7, 4}, 4},
+		{"2 of 3, two failing", 2, 2, []int{failing, 7, failing}, failing},
+		{"2 of 3, one inconsistent", 2, 2, []int{inconsistent, 7, 4}, 4},
+		{"2 of 3, one behind", 5, 2, []int{3, 7, 5}, 5},
+		{"2 of 3, two behind", 5, 2, []int{3, 7, 4}, failing},
+	} {
+		func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var secondaries []Secondary
+			for i, size := range table.sizes {
+				pub, signer, err := crypto.NewKeyPair()
+				if err != nil {
+					t.Fatal(err)
+				}
+				client := mockapi.NewMockSecondary(ctrl)
+				switch size {
+				case failing:
+					client.EXPECT().GetSecondaryTreeHead(gomock.Any()).Return(
+						types.SignedTreeHead{}, fmt.Errorf("mock failure"))
+				case inconsistent:
+					th := types.TreeHead{Size: 6, RootHash: crypto.Hash{1}}
+					client.EXPECT().GetSecondaryTreeHead(gomock.Any()).Return(th.Sign(signer))
+				default:
+					client.EXPECT().GetSecondaryTreeHead(gomock.Any()).Return(treeHeads[size].Sign(signer))
+				}
+				secondaries = append(secondaries, Secondary{
+					Name:      fmt.Sprintf("secondary-%d", i),
+					Client:    client,
+					PublicKey: pub,
+				})
+			}
+			state := ReplicationState{
+				timeout:     time.Minute,
+				primary:     primary,
+				secondaries: secondaries,
+				quorum:      table.quorum,
+			}
+			got, err := state.ReplicatedTreeHead(context.Background(), table.minSize)
+			if table.want == failing {
+				if err == nil {
+					t.Errorf("%s: unexpected success, got size %d", table.desc, got.Size)
+				}
+			} else if err != nil {
+				t.Errorf("%s: failed: %v", table.desc, err)
+			} else if got != treeHeads[table.want] {
+				t.Errorf("%s: got size %d, wanted %d", table.desc, got.Size, table.want)
+			}
+		}()
+	}
+}
+
 func TestCheckConsistency(t *testing.T) {
 	withConsistencyProof := func(old *types.TreeHead, new *types.TreeHead, consistencyProof []crypto.Hash) error {
 		t.Helper()
@@ -2,12 +2,14 @@ package state
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"maps"
 	"sync"
 	"time"
 
 	"sigsum.org/log-go/internal/witness"
-	"sigsum.org/sigsum-go/pkg/api"
 	"sigsum.org/sigsum-go/pkg/crypto"
 	"sigsum.org/sigsum-go/pkg/log"
 	"sigsum.org/sigsum-go/pkg/policy"
@@ -18,19 +20,54 @@ import (
 type StateManagerSingle struct {
 	signer           crypto.Signer
 	storeSth         func(sth *types.SignedTreeHead) error
+	storeCosigned    func(cth *types.CosignedTreeHead) error
 	replicationState ReplicationState
+	// Tree size known to be cosigned by each witness, and
+	// function to persist it. Accessed only by the rotate
+	// goroutine.
+	witnessSizes      map[crypto.Hash]uint64
+	storeWitnessSizes func(sizes map[crypto.Hash]uint64) error
+	// If set, only tree heads with witness quorum are published.
+	requireQuorum bool
+	// Accepted range of cosignature timestamps.
+	cosignatureWindow witness.TimestampWindow
+	// Cosignatures collected in the latest round, published or
+	// not. Accessed only by the rotate goroutine.
+	collected types.CosignedTreeHead
 
 	// Lock-protected access to tree heads. All endpoints are readers.
 	sync.RWMutex
 	signedTreeHead   types.SignedTreeHead
 	cosignedTreeHead types.CosignedTreeHead
+	publishedAt      time.Time
+	// Closed and replaced on each publish.
+	nextPublish chan struct{}
+	// Set by Run.
+	collector *witness.CosignatureCollector
+	// Set by SetPolicy, consumed by Run.
+	policyUpdate *policyUpdate
+}
+
+// Wrapper, since a nil policy is valid.
+type policyUpdate struct {
+	policy *policy.Policy
 }
 
 // NewStateManagerSingle() sets up a new state manager, in particular its
-// signedTreeHead.  An optional secondary node can be used to ensure that
-// a newer primary tree is not signed unless it has been replicated.
+// signedTreeHead.  Optional secondary nodes can be used to ensure that
+// a newer primary tree is not signed unless it has been replicated by
+// at least quorum of the secondaries.
+// The previously published cosigned tree head is restored, keeping
+// only valid cosignatures from the witnesses of the optional policy.
+// If requireQuorum is set, a new tree head is published only when
+// its cosignatures satisfy the policy's quorum. Cosignatures with
+// timestamps outside of cosignatureWindow are rejected.
 func NewStateManagerSingle(primary PrimaryTree, signer crypto.Signer, timeout time.Duration,
-	secondary api.Secondary, secondaryPub *crypto.PublicKey, sthFileName string) (*StateManagerSingle, error) {
+	secondaries []Secondary, quorum int, sthFileName string,
+	p *policy.Policy, requireQuorum bool, cosignatureWindow witness.TimestampWindow) (*StateManagerSingle, error) {
+	if quorum < 0 || quorum > len(secondaries) || (quorum == 0 && len(secondaries) > 0) {
+		return nil, fmt.Errorf("invalid replication quorum %d, with %d secondaries", quorum, len(secondaries))
+	}
 	pub := signer.Public()
 	sthFile := sthFile{name: sthFileName}
 	startupMode, err := sthFile.Startup()
@@ -69,21 +106,84 @@ func NewStateManagerSingle(primary PrimaryTree, signer crypto.Signer, timeout ti
 	default:
 		panic(fmt.Sprintf("internal error, unknown startup mode %d", startupMode))
 	}
+	cth := types.CosignedTreeHead{SignedTreeHead: sth}
+	if startupMode == StartupSaved {
+		saved, err := sthFile.LoadCosigned(&pub, witnessKeys(p))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			log.Info("No saved cosigned tree head, starting without cosignatures")
+		case err != nil:
+			log.Warning("Ignoring saved cosigned tree head: %v", err)
+		case saved.Size > sth.Size || (saved.Size == sth.Size && saved.TreeHead != sth.TreeHead):
+			log.Warning("Ignoring saved cosigned tree head of size %d, inconsistent with signed tree head of size %d",
+				saved.Size, sth.Size)
+		default:
+			// If older than sth, the saved tree head is the
+			// one that was most recently published.
+			cth = saved
+		}
+	}
+	witnessSizes, err := sthFile.LoadWitnessSizes(witnessKeys(p))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		log.Info("No saved witness sizes")
+	case err != nil:
+		log.Warning("Ignoring saved witness sizes: %v", err)
+	}
 	return &StateManagerSingle{
-		signer:   signer,
-		storeSth: sthFile.Store,
+		signer:            signer,
+		storeSth:          sthFile.Store,
+		storeCosigned:     sthFile.StoreCosigned,
+		witnessSizes:      witnessSizes,
+		storeWitnessSizes: sthFile.StoreWitnessSizes,
 		replicationState: ReplicationState{
-			primary:      primary,
-			secondary:    secondary,
-			secondaryPub: *secondaryPub,
-			timeout:      timeout,
+			primary:     primary,
+			secondaries: secondaries,
+			quorum:      quorum,
+			timeout:     timeout,
 		},
-		// No cosignatures available at startup.
-		signedTreeHead:   sth,
-		cosignedTreeHead: types.CosignedTreeHead{SignedTreeHead: sth},
+		requireQuorum:     requireQuorum,
+		cosignatureWindow: cosignatureWindow,
+		collected:         cth,
+		signedTreeHead:    sth,
+		cosignedTreeHead:  cth,
+		publishedAt:       publishTime(&cth, time.Now()),
+		nextPublish:       make(chan struct{}),
 	}, nil
 }
 
+// Returns an estimate of when a restored cosigned tree head was
+// published: the time of its newest cosignature, which can't be much
+// earlier. Without cosignatures, there's nothing to go by, and now
+// is returned.
+func publishTime(cth *types.CosignedTreeHead, now time.Time) time.Time {
+	var newest uint64
+	for _, cs := range cth.Cosignatures {
+		newest = max(newest, cs.Timestamp)
+	}
+	if newest == 0 {
+		return now
+	}
+	t := time.Unix(int64(newest), 0)
+	if t.After(now) {
+		// Don't trust a timestamp in the future.
+		return now
+	}
+	return t
+}
+
+// Returns public keys of the policy's witnesses, indexed by key hash.
+func witnessKeys(p *policy.Policy) map[crypto.Hash]crypto.PublicKey {
+	keys := make(map[crypto.Hash]crypto.PublicKey)
+	if p == nil {
+		return keys
+	}
+	for _, w := range p.GetWitnessesWithUrl() {
+		keys[crypto.HashBytes(w.PublicKey[:])] = w.PublicKey
+	}
+	return keys
+}
+
 func (sm *StateManagerSingle) SignedTreeHead() types.SignedTreeHead {
 	sm.RLock()
 	defer sm.RUnlock()
@@ -96,20 +196,69 @@ func (sm *StateManagerSingle) CosignedTreeHead() types.CosignedTreeHead {
 	return sm.cosignedTreeHead
 }
 
+func (sm *StateManagerSingle) NextPublish() <-chan struct{} {
+	sm.RLock()
+	defer sm.RUnlock()
+	return sm.nextPublish
+}
+
+func (sm *StateManagerSingle) WitnessStatus() []witness.Status {
+	sm.RLock()
+	defer sm.RUnlock()
+	if sm.collector == nil {
+		return nil
+	}
+	return sm.collector.Status()
+}
+
+// SetPolicy replaces the policy used by Run, taking effect at the
+// start of the next rotation.
+func (sm *StateManagerSingle) SetPolicy(p *policy.Policy) {
+	sm.Lock()
+	defer sm.Unlock()
+	sm.policyUpdate = &policyUpdate{policy: p}
+}
+
+func (sm *StateManagerSingle) takePolicyUpdate() *policyUpdate {
+	sm.Lock()
+	defer sm.Unlock()
+	update := sm.policyUpdate
+	sm.policyUpdate = nil
+	return update
+}
+
+func (sm *StateManagerSingle) setCollector(collector *witness.CosignatureCollector) {
+	sm.Lock()
+	defer sm.Unlock()
+	sm.collector = collector
+}
+
+// Returns the witnesses to query, and quorum predicate, for the
+// optional policy.
+func policyWitnesses(p *policy.Policy) ([]policy.Entity, witness.QuorumPredicate) {
+	if p == nil {
+		return nil, nil
+	}
+	return p.GetWitnessesWithUrl(), newQuorumFunc(p)
+}
+
 func (sm *StateManagerSingle) Run(ctx context.Context, p *policy.Policy, interval time.Duration, metrics witness.WitnessMetrics) {
 	pub := sm.signer.Public()
-	var witnesses []policy.Entity
-	var quorum witness.QuorumPredicate
-	if p != nil {
-		witnesses = p.GetWitnessesWithUrl()
-		quorum = newQuorumFunc(p)
-	}
+	witnesses, quorum := policyWitnesses(p)
 	collector := witness.NewCosignatureCollector(&pub, witnesses,
-		sm.replicationState.primary.GetConsistencyProof, metrics, quorum)
+		sm.replicationState.primary.GetConsistencyProof, metrics, quorum, sm.witnessSizes, sm.cosignatureWindow)
+	sm.setCollector(collector)
 
 	for ctx.Err() == nil {
 		rotateCtx, _ := context.WithTimeout(ctx, interval)
 
+		if update := sm.takePolicyUpdate(); update != nil {
+			witnesses, quorum := policyWitnesses(update.policy)
+			log.Info("policy updated, %d witnesses", len(witnesses))
+			collector = collector.WithWitnesses(witnesses, quorum)
+			sm.setCollector(collector)
+		}
+
 		currentTH := sm.SignedTreeHead().TreeHead
 		nextTH, err := sm.replicationState.ReplicatedTreeHead(
 			rotateCtx, currentTH.Size)
@@ -121,32 +270,98 @@ func (sm *StateManagerSingle) Run(ctx context.Context, p *policy.Policy, interva
 		if err := sm.rotate(rotateCtx, &nextTH, collector.GetCosignatures); err != nil {
 			log.Warning("failed rotating tree head: %v", err)
 		}
+		sm.updateWitnessSizes(collector.WitnessSizes())
+		metrics.RecordPublishedAge(sm.publishedAge())
 		// Waits until end of interval
 		<-rotateCtx.Done()
 	}
 }
 
+func (sm *StateManagerSingle) updateWitnessSizes(sizes map[crypto.Hash]uint64) {
+	if maps.Equal(sizes, sm.witnessSizes) {
+		return
+	}
+	if err := sm.storeWitnessSizes(sizes); err != nil {
+		log.Warning("failed storing witness sizes: %v", err)
+		return
+	}
+	sm.witnessSizes = sizes
+}
+
 func (sm *StateManagerSingle) rotate(ctx context.Context, nextTH *types.TreeHead,
-	getCosignatures func(context.Context, *types.SignedTreeHead) map[crypto.Hash]types.Cosignature) error {
+	getCosignatures func(context.Context, *types.SignedTreeHead, map[crypto.Hash]types.Cosignature, witness.PublishFunc) map[crypto.Hash]types.Cosignature) error {
 	nextSTH, err := sm.signTreeHead(nextTH)
 	if err != nil {
 		return err
 	}
 
+	// If the tree head is unchanged, carry over previous
+	// cosignatures, so that a witness failing this round doesn't
+	// drop its valid cosignature.
+	var prevCosignatures map[crypto.Hash]types.Cosignature
+	if sm.collected.TreeHead == nextSTH.TreeHead {
+		prevCosignatures = sm.collected.Cosignatures
+	}
+
+	// Publish as soon as we have quorum, and merge any late
+	// cosignatures into the published tree head.
+	published := false
+	publish := func(cosignatures map[crypto.Hash]types.Cosignature) {
+		if err := sm.publish(&types.CosignedTreeHead{
+			SignedTreeHead: nextSTH,
+			Cosignatures:   cosignatures,
+		}); err != nil {
+			log.Warning("failed publishing cosigned tree head: %v", err)
+			return
+		}
+		published = true
+	}
 	// Blocks (with no locks held), potentially until context times out.
-	cosignatures := getCosignatures(ctx, &nextSTH)
+	cosignatures := getCosignatures(ctx, &nextSTH, prevCosignatures, publish)
+	sm.collected = types.CosignedTreeHead{
+		SignedTreeHead: nextSTH,
+		Cosignatures:   cosignatures,
+	}
+	if published {
+		return nil
+	}
+	if sm.requireQuorum {
+		return fmt.Errorf("no witness quorum for tree head of size %d, keeping published tree head of size %d",
+			nextSTH.Size, sm.CosignedTreeHead().Size)
+	}
+	// Quorum not reached, publish anyway.
+	return sm.publish(&types.CosignedTreeHead{
+		SignedTreeHead: nextSTH,
+		Cosignatures:   cosignatures,
+	})
+}
+
+func (sm *StateManagerSingle) publish(cth *types.CosignedTreeHead) error {
+	// Store before publishing, so that a restart never publishes
+	// an older tree head than what has been published.
+	if err := sm.storeCosigned(cth); err != nil {
+		return err
+	}
 
 	sm.Lock()
 	defer sm.Unlock()
 
-	log.Debug("rotating cosigned tree head: previous size %d, new size %d", sm.cosignedTreeHead.Size, nextSTH.Size)
-	sm.cosignedTreeHead = types.CosignedTreeHead{
-		SignedTreeHead: nextSTH,
-		Cosignatures:   cosignatures,
-	}
+	log.Debug("rotating cosigned tree head: previous size %d, new size %d, cosignatures %d",
+		sm.cosignedTreeHead.Size, cth.Size, len(cth.Cosignatures))
+	sm.cosignedTreeHead = *cth
+	sm.publishedAt = time.Now()
+	// Wake up anyone waiting for this tree head.
+	close(sm.nextPublish)
+	sm.nextPublish = make(chan struct{})
 	return nil
 }
 
+func (sm *StateManagerSingle) publishedAge() time.Duration {
+	sm.RLock()
+	defer sm.RUnlock()
+	return time.Since(sm.publishedAt)
+}
+
 func newQuorumFunc(p *policy.Policy) witness.QuorumPredicate {
 	if p.ProcessQuorum(cosignatureQuorumProcessor{}).(bool) {
 		return nil // quorum none
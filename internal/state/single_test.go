@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"sigsum.org/log-go/internal/mocks/db"
+	"sigsum.org/log-go/internal/witness"
 	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/policy"
 	"sigsum.org/sigsum-go/pkg/types"
 )
 
@@ -69,7 +72,7 @@ func TestNewStateManagerSingle(t *testing.T) {
 				t.Fatal(err)
 			}
 			// This test uses no secondary.
-			sm, err := NewStateManagerSingle(trillianClient, signer, time.Duration(0), nil, &crypto.PublicKey{}, tmpFile.Name())
+			sm, err := NewStateManagerSingle(trillianClient, signer, time.Duration(0), nil, 0, tmpFile.Name(), nil, false, witness.TimestampWindow{})
 			if got, want := err != nil, table.description != "valid"; got != want {
 				t.Errorf("got error %v but wanted %v in test %q: %v", got, want, table.description, err)
 			}
@@ -87,6 +90,94 @@ func TestNewStateManagerSingle(t *testing.T) {
 	}
 }
 
+func TestNewStateManagerSingleRestore(t *testing.T) {
+	_, signer := mustKeyPair(t)
+	sth1 := mustSignTreehead(t, signer, 1)
+	sth2 := mustSignTreehead(t, signer, 2)
+	for _, table := range []struct {
+		desc     string
+		sth      types.SignedTreeHead
+		cosigned *types.SignedTreeHead // nil for no file
+		want     types.SignedTreeHead
+	}{
+		{"no file", sth1, nil, sth1},
+		{"same", sth2, &sth2, sth2},
+		{"older", sth2, &sth1, sth1},
+		{"newer", sth1, &sth2, sth1},
+	} {
+		withTmpDir(t, func(dir string) {
+			sthFile := sthFile{dir + "sth"}
+			if err := sthFile.Store(&table.sth); err != nil {
+				t.Fatal(err)
+			}
+			if table.cosigned != nil {
+				if err := sthFile.StoreCosigned(&types.CosignedTreeHead{SignedTreeHead: *table.cosigned}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sm, err := NewStateManagerSingle(nil, signer, time.Duration(0), nil, 0, sthFile.name, nil, false, witness.TimestampWindow{})
+			if err != nil {
+				t.Fatalf("%s: NewStateManagerSingle failed: %v", table.desc, err)
+			}
+			if got := sm.SignedTreeHead(); got != table.sth {
+				t.Errorf("%s: unexpected signed tree head, got size %d, wanted %d", table.desc, got.Size, table.sth.Size)
+			}
+			if got := sm.CosignedTreeHead(); got.SignedTreeHead != table.want {
+				t.Errorf("%s: unexpected cosigned tree head, got size %d, wanted %d", table.desc, got.Size, table.want.Size)
+			}
+		})
+	}
+}
+
+func TestNewStateManagerSingleRestoreAge(t *testing.T) {
+	lPub, signer := mustKeyPair(t)
+	wPub, wSigner := mustKeyPair(t)
+	p, err := policy.ParseConfig(strings.NewReader(fmt.Sprintf("witness W %x http://witness.example.org\nquorum W\n", wPub)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sth := mustSignTreehead(t, signer, 2)
+	origin := types.SigsumCheckpointOrigin(&lPub)
+	for _, table := range []struct {
+		desc      string
+		timestamp uint64 // Zero for no cosignature.
+		minAge    time.Duration
+		maxAge    time.Duration
+	}{
+		{"no cosignatures", 0, 0, time.Minute},
+		{"old", uint64(time.Now().Add(-3 * time.Hour).Unix()), 3 * time.Hour, 3*time.Hour + time.Minute},
+		{"future", uint64(time.Now().Add(time.Hour).Unix()), 0, time.Minute},
+	} {
+		withTmpDir(t, func(dir string) {
+			sthFile := sthFile{dir + "sth"}
+			if err := sthFile.Store(&sth); err != nil {
+				t.Fatal(err)
+			}
+			cth := types.CosignedTreeHead{SignedTreeHead: sth}
+			if table.timestamp > 0 {
+				cs, err := sth.TreeHead.Cosign(wSigner, origin, table.timestamp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cth.Cosignatures = map[crypto.Hash]types.Cosignature{crypto.HashBytes(wPub[:]): cs}
+			}
+			if err := sthFile.StoreCosigned(&cth); err != nil {
+				t.Fatal(err)
+			}
+			sm, err := NewStateManagerSingle(nil, signer, time.Duration(0), nil, 0, sthFile.name, p, true, witness.TimestampWindow{})
+			if err != nil {
+				t.Fatalf("%s: NewStateManagerSingle failed: %v", table.desc, err)
+			}
+			if got := len(sm.CosignedTreeHead().Cosignatures); table.timestamp > 0 && got != 1 {
+				t.Fatalf("%s: cosignature not restored", table.desc)
+			}
+			if age := sm.publishedAge(); age < table.minAge || age > table.maxAge {
+				t.Errorf("%s: unexpected age %v, wanted between %v and %v", table.desc, age, table.minAge, table.maxAge)
+			}
+		})
+	}
+}
+
 func TestSignedTreeHead(t *testing.T) {
 	want := types.SignedTreeHead{TreeHead: types.TreeHead{Size: 5}}
 	sm := StateManagerSingle{
@@ -155,15 +246,21 @@ func TestRotate(t *testing.T) {
 		sth := mustSignTreehead(t, lSigner, table.signedSize)
 		nth := types.TreeHead{Size: table.nextSize}
 		var storedSth types.SignedTreeHead
+		var storedCth types.CosignedTreeHead
 		sm := StateManagerSingle{
 			signer:           signer,
 			cosignedTreeHead: types.CosignedTreeHead{SignedTreeHead: sth},
+			nextPublish:      make(chan struct{}),
 			storeSth: func(sth *types.SignedTreeHead) error {
 				storedSth = *sth
 				return nil
 			},
+			storeCosigned: func(cth *types.CosignedTreeHead) error {
+				storedCth = *cth
+				return nil
+			},
 		}
-		err := sm.rotate(context.Background(), &nth, func(_ context.Context, sth *types.SignedTreeHead) map[crypto.Hash]types.Cosignature {
+		err := sm.rotate(context.Background(), &nth, func(_ context.Context, sth *types.SignedTreeHead, _ map[crypto.Hash]types.Cosignature, _ witness.PublishFunc) map[crypto.Hash]types.Cosignature {
 			if !table.withCosignature {
 				return nil
 			}
@@ -192,6 +289,9 @@ func TestRotate(t *testing.T) {
 				t.Errorf("%s: unexpected cosigned tree head after rotation, got size %d, expected %d", table.desc, newCth.Size, table.nextSize)
 
 			}
+			if !reflect.DeepEqual(storedCth, newCth) {
+				t.Errorf("%s: unexpected stored cosigned tree head after rotation, got size %d, expected %d", table.desc, storedCth.Size, table.nextSize)
+			}
 			if table.withCosignature {
 				if len(newCth.Cosignatures) != 1 {
 					t.Fatalf("%s: unexpected cth cosignature count, got %d, expected 1", table.desc, len(newCth.Cosignatures))
@@ -215,6 +315,143 @@ func TestRotate(t *testing.T) {
 	}
 }
 
+func TestRotateEarlyPublish(t *testing.T) {
+	lPub, lSigner := mustKeyPair(t)
+	w1Pub, w1Signer := mustKeyPair(t)
+	w2Pub, w2Signer := mustKeyPair(t)
+	origin := types.SigsumCheckpointOrigin(&lPub)
+
+	var stored []types.CosignedTreeHead
+	sm := StateManagerSingle{
+		signer:           lSigner,
+		cosignedTreeHead: types.CosignedTreeHead{SignedTreeHead: mustSignTreehead(t, lSigner, 1)},
+		nextPublish:      make(chan struct{}),
+		storeSth:         func(_ *types.SignedTreeHead) error { return nil },
+		storeCosigned: func(cth *types.CosignedTreeHead) error {
+			stored = append(stored, *cth)
+			return nil
+		},
+	}
+	nth := types.TreeHead{Size: 2}
+	next := sm.NextPublish()
+	err := sm.rotate(context.Background(), &nth, func(_ context.Context, sth *types.SignedTreeHead, _ map[crypto.Hash]types.Cosignature, publish witness.PublishFunc) map[crypto.Hash]types.Cosignature {
+		cosignatures := map[crypto.Hash]types.Cosignature{
+			crypto.HashBytes(w1Pub[:]): mustCosign(t, w1Signer, &sth.TreeHead, origin),
+		}
+		publish(cosignatures)
+		// Published before collection is done.
+		if cth := sm.CosignedTreeHead(); cth.Size != 2 || len(cth.Cosignatures) != 1 {
+			t.Errorf("tree head not published at quorum, got size %d, cosignatures %d", cth.Size, len(cth.Cosignatures))
+		}
+		select {
+		case <-next:
+		default:
+			t.Errorf("publish didn't notify waiters")
+		}
+		// Late cosignature.
+		cosignatures = map[crypto.Hash]types.Cosignature{
+			crypto.HashBytes(w1Pub[:]): cosignatures[crypto.HashBytes(w1Pub[:])],
+			crypto.HashBytes(w2Pub[:]): mustCosign(t, w2Signer, &sth.TreeHead, origin),
+		}
+		publish(cosignatures)
+		return cosignatures
+	})
+	if err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	if got, want := len(stored), 2; got != want {
+		t.Errorf("unexpected number of stored cosigned tree heads, got %d, want %d", got, want)
+	}
+	cth := sm.CosignedTreeHead()
+	if cth.Size != 2 || len(cth.Cosignatures) != 2 {
+		t.Errorf("late cosignature not merged, got size %d, cosignatures %d", cth.Size, len(cth.Cosignatures))
+	}
+	if !reflect.DeepEqual(stored[len(stored)-1], cth) {
+		t.Errorf("stored cosigned tree head differs from published one")
+	}
+}
+
+func TestRotateReuseCosignatures(t *testing.T) {
+	lPub, lSigner := mustKeyPair(t)
+	wPub, wSigner := mustKeyPair(t)
+	wKeyHash := crypto.HashBytes(wPub[:])
+	origin := types.SigsumCheckpointOrigin(&lPub)
+
+	sth := mustSignTreehead(t, lSigner, 2)
+	prev := map[crypto.Hash]types.Cosignature{wKeyHash: mustCosign(t, wSigner, &sth.TreeHead, origin)}
+
+	for _, table := range []struct {
+		desc     string
+		nextSize uint64
+		wantPrev bool
+	}{
+		{"unchanged", 2, true},
+		{"grown", 3, false},
+	} {
+		sm := StateManagerSingle{
+			signer:        lSigner,
+			collected:     types.CosignedTreeHead{SignedTreeHead: sth, Cosignatures: prev},
+			nextPublish:   make(chan struct{}),
+			storeSth:      func(_ *types.SignedTreeHead) error { return nil },
+			storeCosigned: func(_ *types.CosignedTreeHead) error { return nil },
+		}
+		nth := types.TreeHead{Size: table.nextSize}
+		err := sm.rotate(context.Background(), &nth, func(_ context.Context, _ *types.SignedTreeHead, prevCosignatures map[crypto.Hash]types.Cosignature, _ witness.PublishFunc) map[crypto.Hash]types.Cosignature {
+			if got := len(prevCosignatures) > 0; got != table.wantPrev {
+				t.Errorf("%s: unexpected previous cosignatures: %v", table.desc, prevCosignatures)
+			}
+			return prevCosignatures
+		})
+		if err != nil {
+			t.Errorf("%s: rotate failed: %v", table.desc, err)
+		}
+	}
+}
+
+func TestRotateRequireQuorum(t *testing.T) {
+	lPub, lSigner := mustKeyPair(t)
+	wPub, wSigner := mustKeyPair(t)
+	wKeyHash := crypto.HashBytes(wPub[:])
+	origin := types.SigsumCheckpointOrigin(&lPub)
+
+	published := types.CosignedTreeHead{SignedTreeHead: mustSignTreehead(t, lSigner, 1)}
+	sm := StateManagerSingle{
+		signer:           lSigner,
+		requireQuorum:    true,
+		cosignedTreeHead: published,
+		nextPublish:      make(chan struct{}),
+		storeSth:         func(_ *types.SignedTreeHead) error { return nil },
+		storeCosigned:    func(_ *types.CosignedTreeHead) error { return nil },
+	}
+	for _, table := range []struct {
+		desc     string
+		quorum   bool
+		wantSize uint64
+	}{
+		{"no quorum", false, 1},
+		{"quorum", true, 2},
+	} {
+		nth := types.TreeHead{Size: 2}
+		err := sm.rotate(context.Background(), &nth, func(_ context.Context, sth *types.SignedTreeHead, _ map[crypto.Hash]types.Cosignature, publish witness.PublishFunc) map[crypto.Hash]types.Cosignature {
+			if !table.quorum {
+				return nil
+			}
+			cosignatures := map[crypto.Hash]types.Cosignature{wKeyHash: mustCosign(t, wSigner, &sth.TreeHead, origin)}
+			publish(cosignatures)
+			return cosignatures
+		})
+		if got, want := err != nil, !table.quorum; got != want {
+			t.Errorf("%s: unexpected rotate result: %v", table.desc, err)
+		}
+		if got := sm.SignedTreeHead().Size; got != 2 {
+			t.Errorf("%s: unexpected signed tree head size %d", table.desc, got)
+		}
+		if got := sm.CosignedTreeHead().Size; got != table.wantSize {
+			t.Errorf("%s: unexpected published tree head size %d, want %d", table.desc, got, table.wantSize)
+		}
+	}
+}
+
 func mustKeyPair(t *testing.T) (crypto.PublicKey, crypto.Signer) {
 	t.Helper()
 	pub, signer, err := crypto.NewKeyPair()
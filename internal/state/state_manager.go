@@ -16,7 +16,14 @@ type StateManager interface {
 	SignedTreeHead() types.SignedTreeHead
 	// Currently published tree.
 	CosignedTreeHead() types.CosignedTreeHead
+	// Returns a channel that is closed when the next cosigned
+	// tree head is published.
+	NextPublish() <-chan struct{}
 
 	// Run periodically rotates the node's tree heads and queries witnesses.
 	Run(context.Context, *policy.Policy, time.Duration, witness.WitnessMetrics)
+	// Status of the witnesses queried by Run.
+	WitnessStatus() []witness.Status
+	// Replaces the policy used by Run.
+	SetPolicy(*policy.Policy)
 }
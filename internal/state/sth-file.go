@@ -31,12 +31,26 @@ const (
 	StartupLocalTree
 
 	StartupFileSuffix = ".startup"
+	// The latest published cosigned tree head is stored in a file
+	// next to the sth file, with this suffix.
+	CosignedFileSuffix = ".cosigned"
+	// The tree size known to be cosigned by each witness is
+	// stored in a file next to the sth file, with this suffix.
+	WitnessSizesFileSuffix = ".witness-sizes"
 )
 
 func (s sthFile) startupFileName() string {
 	return s.name + StartupFileSuffix
 }
 
+func (s sthFile) cosignedFileName() string {
+	return s.name + CosignedFileSuffix
+}
+
+func (s sthFile) witnessSizesFileName() string {
+	return s.name + WitnessSizesFileSuffix
+}
+
 func parseStartupFile(f io.Reader) (StartupMode, error) {
 	// TODO: Add a GetString method to sigsum-go's ascii.Parser?
 	scanner := bufio.NewScanner(f)
@@ -66,6 +80,45 @@ func parseStartupFile(f io.Reader) (StartupMode, error) {
 	}
 }
 
+// CreateStartupFile creates a startup file next to the named sth
+// file, telling the log server how to create the sth file on next
+// start, and verifies that it is read back as intended. Fails if
+// either file already exists.
+func CreateStartupFile(sthFileName string, mode StartupMode) error {
+	var keyword string
+	switch mode {
+	case StartupEmpty:
+		keyword = "empty"
+	case StartupLocalTree:
+		keyword = "local-tree"
+	default:
+		return fmt.Errorf("invalid startup mode %d", mode)
+	}
+	s := sthFile{name: sthFileName}
+	if _, err := os.Stat(s.name); !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unexpected sth file %q, inconsistent with startup mode %q", s.name, keyword)
+	}
+	// Writing is not atomic, user is expected to not run this
+	// under the feet of log server startup.
+	f, err := os.OpenFile(s.startupFileName(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("creating startup file failed: %v", err)
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "startup=%s", keyword)
+	// Explicit close, to catch errors.
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("writing startup file failed: %v", err)
+	}
+	if got, err := s.Startup(); err != nil || got != mode {
+		return fmt.Errorf("startup file %q not read back as expected, got mode %d, err: %v", s.startupFileName(), got, err)
+	}
+	return nil
+}
+
 func (s sthFile) Startup() (StartupMode, error) {
 	name := s.startupFileName()
 	f, err := os.Open(name)
@@ -100,7 +153,8 @@ func (s sthFile) Load(pub *crypto.PublicKey) (types.SignedTreeHead, error) {
 }
 
 // Creates a new sth file. Fails if sth file already exists. On
-// success, any startup file is deleted.
+// success, any startup, cosigned and witness sizes files are
+// deleted.
 func (s sthFile) Create(sth *types.SignedTreeHead) error {
 	f, err := safefile.Create(s.name, 0644)
 	if err != nil {
@@ -112,10 +166,13 @@ func (s sthFile) Create(sth *types.SignedTreeHead) error {
 		return err
 	}
 
-	// Ensure startup file is deleted before we create the sth
-	// file.
-	if err := os.Remove(s.startupFileName()); err != nil && !errors.Is(err, fs.ErrNotExist) {
-		return err
+	// Ensure startup file, and any cosigned tree head and
+	// witness sizes for a previous tree, are deleted before we
+	// create the sth file.
+	for _, name := range []string{s.startupFileName(), s.cosignedFileName(), s.witnessSizesFileName()} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
 	}
 	// Atomically create file, or fail if file already exists.
 	return f.CommitIfNotExists()
@@ -135,3 +192,122 @@ func (s sthFile) Store(sth *types.SignedTreeHead) error {
 	// Atomically replace old file with new.
 	return f.Commit()
 }
+
+// Loads the cosigned tree head file, and verifies the log's
+// signature. Cosignatures are kept only if they are valid signatures
+// by one of the given witnesses, indexed by key hash; others are
+// dropped with a warning.
+func (s sthFile) LoadCosigned(pub *crypto.PublicKey, witnesses map[crypto.Hash]crypto.PublicKey) (types.CosignedTreeHead, error) {
+	name := s.cosignedFileName()
+	f, err := os.Open(name)
+	if err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	defer f.Close()
+	var cth types.CosignedTreeHead
+	if err := cth.FromASCII(f); err != nil {
+		return types.CosignedTreeHead{}, err
+	}
+	if !cth.Verify(pub) {
+		return types.CosignedTreeHead{}, fmt.Errorf("invalid signature in file %q", name)
+	}
+	origin := types.SigsumCheckpointOrigin(pub)
+	cosignatures := make(map[crypto.Hash]types.Cosignature)
+	for keyHash, cs := range cth.Cosignatures {
+		key, ok := witnesses[keyHash]
+		if !ok {
+			log.Warning("Dropping cosignature from unknown witness %x in file %q", keyHash, name)
+			continue
+		}
+		if !cs.Verify(&key, origin, &cth.TreeHead) {
+			log.Warning("Dropping invalid cosignature from witness %x in file %q", keyHash, name)
+			continue
+		}
+		cosignatures[keyHash] = cs
+	}
+	cth.Cosignatures = cosignatures
+	return cth, nil
+}
+
+func (s sthFile) StoreCosigned(cth *types.CosignedTreeHead) error {
+	f, err := safefile.Create(s.cosignedFileName(), 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := cth.ToASCII(f); err != nil {
+		return err
+	}
+
+	// Atomically replace old file with new.
+	return f.Commit()
+}
+
+// Loads the witness sizes file, with one line "witness=<key hash>
+// <size>" per witness. Only sizes for the given witnesses, indexed by
+// key hash, are kept, so that a witness' entry is ignored if its key
+// is changed.
+func (s sthFile) LoadWitnessSizes(witnesses map[crypto.Hash]crypto.PublicKey) (map[crypto.Hash]uint64, error) {
+	name := s.witnessSizesFileName()
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sizes, err := parseWitnessSizes(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid witness sizes file %q: %v", name, err)
+	}
+	for keyHash := range sizes {
+		if _, ok := witnesses[keyHash]; !ok {
+			log.Info("Ignoring size for unknown witness %x in file %q", keyHash, name)
+			delete(sizes, keyHash)
+		}
+	}
+	return sizes, nil
+}
+
+func parseWitnessSizes(f io.Reader) (map[crypto.Hash]uint64, error) {
+	sizes := make(map[crypto.Hash]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.SplitN(
+			strings.TrimSpace(scanner.Text()),
+			"=", 2)
+		if len(line) != 2 || line[0] != "witness" {
+			return nil, fmt.Errorf("missing witness= keyword")
+		}
+		var hex string
+		var size uint64
+		if _, err := fmt.Sscanf(line[1], "%s %d", &hex, &size); err != nil {
+			return nil, fmt.Errorf("invalid witness line: %v", err)
+		}
+		keyHash, err := crypto.HashFromHex(hex)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := sizes[keyHash]; ok {
+			return nil, fmt.Errorf("duplicate witness %x", keyHash)
+		}
+		sizes[keyHash] = size
+	}
+	return sizes, scanner.Err()
+}
+
+func (s sthFile) StoreWitnessSizes(sizes map[crypto.Hash]uint64) error {
+	f, err := safefile.Create(s.witnessSizesFileName(), 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for keyHash, size := range sizes {
+		if _, err := fmt.Fprintf(f, "witness=%x %d\n", keyHash, size); err != nil {
+			return err
+		}
+	}
+
+	// Atomically replace old file with new.
+	return f.Commit()
+}
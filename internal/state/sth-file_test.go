@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"strings"
 	"testing"
 
 	"sigsum.org/sigsum-go/pkg/crypto"
@@ -106,12 +107,13 @@ func TestCreate(t *testing.T) {
 	withTmpDir(t, func(dir string) {
 		sthFile := sthFile{dir + "foo"}
 		startupFileName := dir + "foo.startup"
-		// Create file to be deleted.
-		if err := os.WriteFile(startupFileName, []byte("foo"), 0666); err != nil {
-			t.Fatalf("creating startup file %q failed: %v", startupFileName, err)
-		}
-		if _, err := os.ReadFile(startupFileName); err != nil {
-			t.Fatalf("reading startup file %q failed: %v", startupFileName, err)
+		// Create files to be deleted, the latter two left
+		// over from a previous tree.
+		staleFileNames := []string{startupFileName, dir + "foo.cosigned", dir + "foo.witness-sizes"}
+		for _, name := range staleFileNames {
+			if err := os.WriteFile(name, []byte("foo"), 0666); err != nil {
+				t.Fatalf("creating file %q failed: %v", name, err)
+			}
 		}
 		signer := crypto.NewEd25519Signer(&crypto.PrivateKey{7})
 		pub := signer.Public()
@@ -120,8 +122,10 @@ func TestCreate(t *testing.T) {
 		if err := sthFile.Create(&sth0); err != nil {
 			t.Fatalf("creating sth file failed: %v", err)
 		}
-		if _, err := os.ReadFile(startupFileName); !errors.Is(err, fs.ErrNotExist) {
-			t.Errorf("startup file is still around after sth was created, err: %v", err)
+		for _, name := range staleFileNames {
+			if _, err := os.ReadFile(name); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("file %q is still around after sth was created, err: %v", name, err)
+			}
 		}
 		if sth, err := sthFile.Load(&pub); err != nil || sth != sth0 {
 			if err != nil {
@@ -137,6 +141,96 @@ func TestCreate(t *testing.T) {
 	})
 }
 
+func TestCreateStartupFile(t *testing.T) {
+	withTmpDir(t, func(dir string) {
+		sthFileName := dir + "foo"
+		if err := CreateStartupFile(sthFileName, StartupSaved); err == nil {
+			t.Errorf("creating startup file with mode saved unexpectedly succeeded")
+		}
+		if err := CreateStartupFile(sthFileName, StartupLocalTree); err != nil {
+			t.Fatalf("creating startup file failed: %v", err)
+		}
+		if mode, err := (sthFile{sthFileName}).Startup(); err != nil || mode != StartupLocalTree {
+			t.Errorf("unexpected startup mode %d, err: %v", mode, err)
+		}
+		if err := CreateStartupFile(sthFileName, StartupEmpty); err == nil {
+			t.Errorf("overwriting startup file unexpectedly succeeded")
+		}
+		if err := os.Remove(sthFileName + StartupFileSuffix); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(sthFileName, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := CreateStartupFile(sthFileName, StartupEmpty); err == nil {
+			t.Errorf("creating startup file with existing sth file unexpectedly succeeded")
+		}
+	})
+}
+
+func TestStoreCosigned(t *testing.T) {
+	withTmpDir(t, func(dir string) {
+		sthFile := sthFile{dir + "foo"}
+		signer := crypto.NewEd25519Signer(&crypto.PrivateKey{7})
+		pub := signer.Public()
+		origin := types.SigsumCheckpointOrigin(&pub)
+		sth := mustSignTh(t, &types.TreeHead{Size: 3}, signer)
+
+		witnesses := make(map[crypto.Hash]crypto.PublicKey)
+		cosignatures := make(map[crypto.Hash]types.Cosignature)
+		for i := byte(0); i < 3; i++ {
+			wSigner := crypto.NewEd25519Signer(&crypto.PrivateKey{10 + i})
+			wPub := wSigner.Public()
+			keyHash := crypto.HashBytes(wPub[:])
+			cs, err := sth.Cosign(wSigner, origin, 1000+uint64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch i {
+			case 0:
+				witnesses[keyHash] = wPub
+			case 1:
+				witnesses[keyHash] = wPub
+				cs.Timestamp++ // Invalidates signature
+			case 2:
+				// Unknown witness.
+			}
+			cosignatures[keyHash] = cs
+		}
+		if err := sthFile.StoreCosigned(&types.CosignedTreeHead{SignedTreeHead: sth, Cosignatures: cosignatures}); err != nil {
+			t.Fatalf("storing cosigned tree head failed: %v", err)
+		}
+		cth, err := sthFile.LoadCosigned(&pub, witnesses)
+		if err != nil {
+			t.Fatalf("loading cosigned tree head failed: %v", err)
+		}
+		if cth.SignedTreeHead != sth {
+			t.Errorf("loaded unexpected tree head, got: %v, wanted: %v", cth.SignedTreeHead, sth)
+		}
+		if len(cth.Cosignatures) != 1 {
+			t.Fatalf("unexpected number of cosignatures, got %d, wanted 1", len(cth.Cosignatures))
+		}
+		for keyHash, cs := range cth.Cosignatures {
+			if cs != cosignatures[keyHash] || cs.Timestamp != 1000 {
+				t.Errorf("unexpected cosignature %v", cs)
+			}
+		}
+
+		otherPub := crypto.NewEd25519Signer(&crypto.PrivateKey{8}).Public()
+		if _, err := sthFile.LoadCosigned(&otherPub, witnesses); err == nil {
+			t.Errorf("loading cosigned tree head with wrong log key unexpectedly succeeded")
+		}
+
+		// Creating a new sth file deletes the cosigned tree head.
+		if err := sthFile.Create(&sth); err != nil {
+			t.Fatalf("creating sth file failed: %v", err)
+		}
+		if _, err := sthFile.LoadCosigned(&pub, witnesses); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("cosigned tree head is still around after sth was created, err: %v", err)
+		}
+	})
+}
+
 // Creates temporary directory, runs function, end then removes files
 // and directory.
 func withTmpDir(t *testing.T, f func(dir string)) {
@@ -156,3 +250,64 @@ func mustSignTh(t *testing.T, th *types.TreeHead, signer crypto.Signer) types.Si
 	}
 	return sth
 }
+
+func TestStoreWitnessSizes(t *testing.T) {
+	withTmpDir(t, func(dir string) {
+		sthFile := sthFile{dir + "foo"}
+		witnesses := make(map[crypto.Hash]crypto.PublicKey)
+		sizes := make(map[crypto.Hash]uint64)
+		for i := byte(0); i < 3; i++ {
+			wPub := crypto.NewEd25519Signer(&crypto.PrivateKey{10 + i}).Public()
+			keyHash := crypto.HashBytes(wPub[:])
+			if i < 2 {
+				witnesses[keyHash] = wPub
+			}
+			// Last one is unknown, e.g., witness key changed.
+			sizes[keyHash] = 100 + uint64(i)
+		}
+		if _, err := sthFile.LoadWitnessSizes(witnesses); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("unexpected result loading non-existing witness sizes, err: %v", err)
+		}
+		if err := sthFile.StoreWitnessSizes(sizes); err != nil {
+			t.Fatalf("storing witness sizes failed: %v", err)
+		}
+		loaded, err := sthFile.LoadWitnessSizes(witnesses)
+		if err != nil {
+			t.Fatalf("loading witness sizes failed: %v", err)
+		}
+		if len(loaded) != 2 {
+			t.Fatalf("unexpected number of witness sizes, got %d, wanted 2", len(loaded))
+		}
+		for keyHash, size := range loaded {
+			if size != sizes[keyHash] {
+				t.Errorf("unexpected size for witness %x, got %d, wanted %d", keyHash, size, sizes[keyHash])
+			}
+		}
+	})
+}
+
+func TestParseWitnessSizes(t *testing.T) {
+	for _, table := range []struct {
+		desc  string
+		input string
+		size  int
+	}{
+		{"empty", "", 0},
+		{"valid", "witness=" + strings.Repeat("01", 32) + " 5\nwitness=" + strings.Repeat("02", 32) + " 7\n", 2},
+		{"bad keyword", "size=" + strings.Repeat("01", 32) + " 5\n", -1},
+		{"bad hash", "witness=0102 5\n", -1},
+		{"bad size", "witness=" + strings.Repeat("01", 32) + " x\n", -1},
+		{"duplicate", "witness=" + strings.Repeat("01", 32) + " 5\nwitness=" + strings.Repeat("01", 32) + " 7\n", -1},
+	} {
+		sizes, err := parseWitnessSizes(bytes.NewBufferString(table.input))
+		if table.size < 0 {
+			if err == nil {
+				t.Errorf("%s: unexpected success", table.desc)
+			}
+		} else if err != nil {
+			t.Errorf("%s: failed: %v", table.desc, err)
+		} else if len(sizes) != table.size {
+			t.Errorf("%s: unexpected number of sizes, got %d, wanted %d", table.desc, len(sizes), table.size)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+// Package tiles serves the log's Merkle tree in the C2SP tlog-tiles
+// format, see https://c2sp.org/tlog-tiles. All data is derived from
+// the log's backend and the currently published tree head; only
+// tiles within the published tree are served.
+package tiles
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/checkpoint"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/log"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+const (
+	tileHeight = 8
+	tileWidth  = 1 << tileHeight
+	// Published tiles never change, so they can be cached forever.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Bound on the number of cached node hashes. When full, an
+	// arbitrary entry is evicted.
+	maxCachedNodes = 100000
+	// Bound on the number of backend requests for serving a
+	// single tile. Enough for a full level 1 tile with nothing
+	// cached.
+	maxTileRequests = 64
+	// Number of leaves fetched per backend request, when
+	// computing level 1 hashes.
+	leafBatchSize = 4 * tileWidth
+)
+
+// Returned when a tile can't be served without exceeding
+// maxTileRequests. Any hashes computed are cached, so that a retry
+// makes progress.
+var errTileBusy = errors.New("too many backend requests needed for tile")
+
+// Subset of the db.Client interface.
+type Backend interface {
+	GetLeaves(context.Context, *requests.Leaves) ([]types.Leaf, error)
+	GetInclusionProof(context.Context, *requests.InclusionProof) (types.InclusionProof, error)
+}
+
+// Subset of the state.StateManager interface.
+type TreeHeads interface {
+	CosignedTreeHead() types.CosignedTreeHead
+}
+
+// Identifies a node in the tree, at level tileHeight*level.
+type nodeKey struct {
+	level uint8
+	index uint64
+}
+
+type Server struct {
+	origin  string
+	keyId   checkpoint.KeyId
+	backend Backend
+	state   TreeHeads
+	timeout time.Duration
+
+	// Cache of hashes for complete subtrees at levels >= 1, i.e.,
+	// the root hashes of full tiles. Since the tree is
+	// append-only, these never change.
+	mu       sync.Mutex
+	maxNodes int
+	nodes    map[nodeKey]crypto.Hash
+
+	maxRequests int
+}
+
+func NewServer(logPublicKey *crypto.PublicKey, backend Backend, state TreeHeads, timeout time.Duration) *Server {
+	origin := types.SigsumCheckpointOrigin(logPublicKey)
+	return &Server{
+		origin:   origin,
+		keyId:    checkpoint.NewLogKeyId(origin, logPublicKey),
+		backend:  backend,
+		state:    state,
+		timeout:  timeout,
+		maxNodes: maxCachedNodes,
+		nodes:    make(map[nodeKey]crypto.Hash),
+
+		maxRequests: maxTileRequests,
+	}
+}
+
+// Registers the checkpoint and tile endpoints on the mux, with the
+// given url prefix, which should be empty or end with "/".
+func (s *Server) Register(mux *http.ServeMux, prefix string) {
+	mux.HandleFunc("GET "+prefix+"checkpoint", s.serveCheckpoint)
+	mux.HandleFunc("GET "+prefix+"tile/", func(w http.ResponseWriter, r *http.Request) {
+		s.serveTile(w, r, strings.TrimPrefix(r.URL.Path, prefix+"tile/"))
+	})
+}
+
+func (s *Server) serveCheckpoint(w http.ResponseWriter, _ *http.Request) {
+	cp := checkpoint.Checkpoint{
+		SignedTreeHead: s.state.CosignedTreeHead().SignedTreeHead,
+		Origin:         s.origin,
+		KeyId:          s.keyId,
+	}
+	// Cosignatures are not included, since the log doesn't know
+	// the key names used by the witnesses. They are available
+	// via the get-tree-head endpoint.
+	var buf bytes.Buffer
+	if err := cp.ToASCII(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
+
+// Tile coordinates, as parsed from the request path.
+type tilePath struct {
+	entries bool
+	level   uint8
+	index   uint64
+	// Number of hashes or entries, tileWidth for full tiles.
+	width int
+}
+
+// Parses a tile path, "<L>/<N>[.p/<W>]" or "entries/<N>[.p/<W>]",
+// where N is encoded as a sequence of 3-digit path elements, all but
+// the last one prefixed by "x". Non-canonical encodings are rejected.
+func parseTilePath(p string) (tilePath, error) {
+	var tile tilePath
+	elements := strings.Split(p, "/")
+	if len(elements) < 2 {
+		return tilePath{}, fmt.Errorf("invalid tile path %q", p)
+	}
+	if elements[0] == "entries" {
+		tile.entries = true
+	} else {
+		level, err := parseDecimal(elements[0], 63)
+		if err != nil {
+			return tilePath{}, fmt.Errorf("invalid tile level: %v", err)
+		}
+		tile.level = uint8(level)
+	}
+	elements = elements[1:]
+	tile.width = tileWidth
+	if n := len(elements); n >= 2 && strings.HasSuffix(elements[n-2], ".p") {
+		width, err := parseDecimal(elements[n-1], tileWidth-1)
+		if err != nil || width == 0 {
+			return tilePath{}, fmt.Errorf("invalid partial tile width %q", elements[n-1])
+		}
+		tile.width = int(width)
+		elements[n-2] = strings.TrimSuffix(elements[n-2], ".p")
+		elements = elements[:n-1]
+	}
+	for i, e := range elements {
+		if i < len(elements)-1 {
+			if !strings.HasPrefix(e, "x") {
+				return tilePath{}, fmt.Errorf("invalid tile index element %q", e)
+			}
+			e = e[1:]
+		}
+		if len(e) != 3 || strings.Trim(e, "0123456789") != "" {
+			return tilePath{}, fmt.Errorf("invalid tile index element %q", e)
+		}
+		d, _ := strconv.ParseUint(e, 10, 64)
+		if tile.index > (^uint64(0)-d)/1000 {
+			return tilePath{}, fmt.Errorf("tile index out of range")
+		}
+		tile.index = tile.index*1000 + d
+	}
+	if formatIndex(tile.index) != strings.Join(elements, "/") {
+		return tilePath{}, fmt.Errorf("non-canonical tile index in %q", p)
+	}
+	return tile, nil
+}
+
+func parseDecimal(s string, max uint64) (uint64, error) {
+	if len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("leading zero in %q", s)
+	}
+	// Use ParseUint, to not accept leading +/-.
+	i, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if i > max {
+		return 0, fmt.Errorf("value %d too large", i)
+	}
+	return i, nil
+}
+
+func formatIndex(n uint64) string {
+	s := fmt.Sprintf("%03d", n%1000)
+	for n >= 1000 {
+		n /= 1000
+		s = fmt.Sprintf("x%03d/%s", n%1000, s)
+	}
+	return s
+}
+
+// Checks that the tile is within a tree of the given size.
+func (t *tilePath) inTree(size uint64) bool {
+	n := size
+	if !t.entries {
+		if tileHeight*uint(t.level) >= 64 {
+			return false
+		}
+		// Number of complete subtrees at the tile's level.
+		n >>= tileHeight * uint(t.level)
+	}
+	return t.index < n/tileWidth || (t.index == n/tileWidth && uint64(t.width) <= n%tileWidth)
+}
+
+func (s *Server) serveTile(w http.ResponseWriter, r *http.Request, p string) {
+	tile, err := parseTilePath(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if size := s.state.CosignedTreeHead().Size; !tile.inTree(size) {
+		http.Error(w, fmt.Sprintf("tile outside of published tree, size %d", size), http.StatusNotFound)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	var data []byte
+	if tile.entries {
+		data, err = s.entryBundle(ctx, tile.index, tile.width)
+	} else {
+		data, err = s.hashTile(ctx, tile.level, tile.index, tile.width)
+	}
+	if err == errTileBusy {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Warning("serving tile %q failed: %v", p, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", immutableCacheControl)
+	w.Write(data)
+}
+
+// Fetches leaves [start, end), as many requests as needed.
+func (s *Server) getLeaves(ctx context.Context, start, end uint64) ([]types.Leaf, error) {
+	var leaves []types.Leaf
+	for start < end {
+		l, err := s.backend.GetLeaves(ctx, &requests.Leaves{StartIndex: start, EndIndex: end})
+		if err != nil {
+			return nil, err
+		}
+		if len(l) == 0 {
+			return nil, fmt.Errorf("backend get leaves returned an empty list")
+		}
+		leaves = append(leaves, l...)
+		start += uint64(len(l))
+	}
+	return leaves, nil
+}
+
+func (s *Server) entryBundle(ctx context.Context, index uint64, width int) ([]byte, error) {
+	leaves, err := s.getLeaves(ctx, index*tileWidth, index*tileWidth+uint64(width))
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	for _, leaf := range leaves {
+		b := leaf.ToBinary()
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(b)))
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
+
+func (s *Server) hashTile(ctx context.Context, level uint8, index uint64, width int) ([]byte, error) {
+	budget := s.maxRequests
+	hashes, err := s.tileHashes(ctx, level, index, width, &budget)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(hashes)*crypto.HashSize)
+	for _, h := range hashes {
+		buf = append(buf, h[:]...)
+	}
+	return buf, nil
+}
+
+// Returns the first width hashes of the given tile, using at most
+// *budget backend requests for hashes not in the cache. Caller must
+// ensure that the tile is within the published tree. The root hash
+// of a full tile is added to the cache, as a node one level up.
+func (s *Server) tileHashes(ctx context.Context, level uint8, index uint64, width int, budget *int) ([]crypto.Hash, error) {
+	var hashes []crypto.Hash
+	if level == 0 {
+		start := index * tileWidth
+		leaves, err := s.getLeaves(ctx, start, start+uint64(width))
+		if err != nil {
+			return nil, err
+		}
+		hashes = make([]crypto.Hash, len(leaves))
+		for i, leaf := range leaves {
+			hashes[i] = merkle.HashLeafNode(leaf.ToBinary())
+		}
+	} else {
+		var err error
+		hashes, err = s.nodeHashes(ctx, level, index, width, budget)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if width == tileWidth {
+		s.storeNode(nodeKey{level: level + 1, index: index}, rootHash(hashes))
+	}
+	return hashes, nil
+}
+
+// Returns the hashes of a tile at level >= 1, from the cache when
+// possible.
+func (s *Server) nodeHashes(ctx context.Context, level uint8, index uint64, width int, budget *int) ([]crypto.Hash, error) {
+	start := index * tileWidth
+	hashes := make([]crypto.Hash, width)
+	var missing []int
+	for i := range hashes {
+		var ok bool
+		if hashes[i], ok = s.cachedNode(nodeKey{level: level, index: start + uint64(i)}); !ok {
+			missing = append(missing, i)
+		}
+	}
+	if level == 1 {
+		// Compute from the leaves, fetching runs of
+		// consecutive subtrees together.
+		for len(missing) > 0 {
+			n := 1
+			for n < len(missing) && n*tileWidth < leafBatchSize && missing[n] == missing[0]+n {
+				n++
+			}
+			if *budget < 1 {
+				return nil, errTileBusy
+			}
+			*budget--
+			first := start + uint64(missing[0])
+			leaves, err := s.getLeaves(ctx, first*tileWidth, (first+uint64(n))*tileWidth)
+			if err != nil {
+				return nil, err
+			}
+			for j := 0; j < n; j++ {
+				leafHashes := make([]crypto.Hash, tileWidth)
+				for k := range leafHashes {
+					leafHashes[k] = merkle.HashLeafNode(leaves[j*tileWidth+k].ToBinary())
+				}
+				hashes[missing[j]] = rootHash(leafHashes)
+				s.storeNode(nodeKey{level: 1, index: first + uint64(j)}, hashes[missing[j]])
+			}
+			missing = missing[n:]
+		}
+		return hashes, nil
+	}
+	for _, i := range missing {
+		key := nodeKey{level: level, index: start + uint64(i)}
+		if h, ok := s.cachedTileRoot(key); ok {
+			hashes[i] = h
+		} else {
+			if *budget < 2 {
+				return nil, errTileBusy
+			}
+			*budget -= 2
+			var err error
+			if hashes[i], err = s.computeNodeHash(ctx, key); err != nil {
+				return nil, err
+			}
+		}
+		s.storeNode(key, hashes[i])
+	}
+	return hashes, nil
+}
+
+func (s *Server) cachedNode(key nodeKey) (crypto.Hash, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.nodes[key]
+	return h, ok
+}
+
+// Computes the hash of a node at level >= 2 from the full tile one
+// level below, if all of its hashes are cached.
+func (s *Server) cachedTileRoot(key nodeKey) (crypto.Hash, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hashes := make([]crypto.Hash, tileWidth)
+	for i := range hashes {
+		h, ok := s.nodes[nodeKey{level: key.level - 1, index: key.index*tileWidth + uint64(i)}]
+		if !ok {
+			return crypto.Hash{}, false
+		}
+		hashes[i] = h
+	}
+	return rootHash(hashes), true
+}
+
+func (s *Server) storeNode(key nodeKey, h crypto.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.nodes[key]; ok {
+		return
+	}
+	if len(s.nodes) >= s.maxNodes {
+		for k := range s.nodes {
+			delete(s.nodes, k)
+			break
+		}
+	}
+	s.nodes[key] = h
+}
+
+// Returns the root hash of a perfect tree, with the given number,
+// a power of two, of hashes at the bottom.
+func rootHash(hashes []crypto.Hash) crypto.Hash {
+	level := append([]crypto.Hash(nil), hashes...)
+	for len(level) > 1 {
+		for i := 0; i < len(level)/2; i++ {
+			level[i] = merkle.HashInteriorNode(&level[2*i], &level[2*i+1])
+		}
+		level = level[:len(level)/2]
+	}
+	return level[0]
+}
+
+// Computes the hash of a node from its first leaf, and the inclusion
+// proof for that leaf in the tree ending with the node. The first
+// path elements of that proof are then the right siblings within the
+// node. This takes two small backend requests, regardless of level.
+func (s *Server) computeNodeHash(ctx context.Context, key nodeKey) (crypto.Hash, error) {
+	height := tileHeight * int(key.level)
+	start := key.index << height
+	leaves, err := s.getLeaves(ctx, start, start+1)
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	h := merkle.HashLeafNode(leaves[0].ToBinary())
+	proof, err := s.backend.GetInclusionProof(ctx, &requests.InclusionProof{
+		Size:     start + 1<<height,
+		LeafHash: h,
+	})
+	if err != nil {
+		return crypto.Hash{}, err
+	}
+	if proof.LeafIndex != start || len(proof.Path) < height {
+		return crypto.Hash{}, fmt.Errorf("unexpected inclusion proof for leaf %d, index %d, path length %d",
+			start, proof.LeafIndex, len(proof.Path))
+	}
+	for _, sibling := range proof.Path[:height] {
+		h = merkle.HashInteriorNode(&h, &sibling)
+	}
+	return h, nil
+}
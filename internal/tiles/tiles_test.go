@@ -0,0 +1,340 @@
+package tiles
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sigsum.org/log-go/internal/db"
+	"sigsum.org/sigsum-go/pkg/checkpoint"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/merkle"
+	"sigsum.org/sigsum-go/pkg/requests"
+	"sigsum.org/sigsum-go/pkg/types"
+)
+
+type fixedTreeHead struct {
+	cth types.CosignedTreeHead
+}
+
+func (f *fixedTreeHead) CosignedTreeHead() types.CosignedTreeHead {
+	return f.cth
+}
+
+func newLeaves(n int) []types.Leaf {
+	leaves := make([]types.Leaf, n)
+	for i := 0; i < n; i++ {
+		var blob [8]byte
+		binary.BigEndian.PutUint64(blob[:], uint64(i))
+		leaves[i].Checksum = crypto.HashBytes(blob[:])
+	}
+	return leaves
+}
+
+func subtreeHead(leaves []types.Leaf) types.TreeHead {
+	dbClient := db.NewMemoryDb()
+	if err := dbClient.AddSequencedLeaves(nil, leaves, 0); err != nil {
+		panic(err)
+	}
+	th, err := dbClient.GetTreeHead(nil)
+	if err != nil {
+		panic(err)
+	}
+	return th
+}
+
+func TestParseTilePath(t *testing.T) {
+	for _, table := range []struct {
+		path string
+		want *tilePath // nil for error
+	}{
+		{"0/000", &tilePath{level: 0, index: 0, width: 256}},
+		{"1/x001/234", &tilePath{level: 1, index: 1234, width: 256}},
+		{"2/x001/x234/067.p/8", &tilePath{level: 2, index: 1234067, width: 8}},
+		{"entries/005.p/255", &tilePath{entries: true, index: 5, width: 255}},
+		{"entries/x001/000", &tilePath{entries: true, index: 1000, width: 256}},
+		{"0/0", nil},
+		{"0/x000/005", nil},
+		{"0/001/002", nil},
+		{"00/001", nil},
+		{"64/001", nil},
+		{"0/005.p/0", nil},
+		{"0/005.p/256", nil},
+		{"0/005.p/08", nil},
+		{"0/005.p", nil},
+		{"0/x018/x446/x744/x073/x709/x551/616", nil},
+		{"entries", nil},
+	} {
+		got, err := parseTilePath(table.path)
+		if table.want == nil {
+			if err == nil {
+				t.Errorf("%q: unexpected success, got %#v", table.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: failed: %v", table.path, err)
+		} else if got != *table.want {
+			t.Errorf("%q: got %#v, wanted %#v", table.path, got, *table.want)
+		}
+	}
+}
+
+func TestInTree(t *testing.T) {
+	for _, table := range []struct {
+		tile tilePath
+		size uint64
+		want bool
+	}{
+		{tilePath{index: 0, width: 256}, 255, false},
+		{tilePath{index: 0, width: 255}, 255, true},
+		{tilePath{index: 0, width: 256}, 256, true},
+		{tilePath{index: 1, width: 1}, 256, false},
+		{tilePath{index: 1, width: 1}, 257, true},
+		{tilePath{entries: true, index: 1, width: 2}, 257, false},
+		{tilePath{level: 1, index: 0, width: 1}, 255, false},
+		{tilePath{level: 1, index: 0, width: 1}, 256, true},
+		{tilePath{level: 2, index: 0, width: 1}, 65535, false},
+		{tilePath{level: 2, index: 0, width: 1}, 65536, true},
+		{tilePath{level: 8, index: 0, width: 1}, ^uint64(0), false},
+	} {
+		if got := table.tile.inTree(table.size); got != table.want {
+			t.Errorf("%#v, size %d: got %v, wanted %v", table.tile, table.size, got, table.want)
+		}
+	}
+}
+
+func TestServeTiles(t *testing.T) {
+	const n = 65536 + 300
+	leaves := newLeaves(n)
+	dbClient := db.NewMemoryDb()
+	if err := dbClient.AddSequencedLeaves(nil, leaves[:65536], 0); err != nil {
+		t.Fatal(err)
+	}
+	th65536, err := dbClient.GetTreeHead(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Root hashes of the subtrees for leaves [0, 256) and [65536, 65792).
+	th256 := subtreeHead(leaves[:256])
+	th65792 := subtreeHead(leaves[65536:65792])
+	if err := dbClient.AddSequencedLeaves(nil, leaves[65536:], 65536); err != nil {
+		t.Fatal(err)
+	}
+	// Publish only part of the tree.
+	state := &fixedTreeHead{types.CosignedTreeHead{
+		SignedTreeHead: types.SignedTreeHead{TreeHead: types.TreeHead{Size: n - 10}},
+	}}
+	publicKey, _, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewServer(&publicKey, dbClient, state, time.Minute).Register(mux, "/foo/")
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	get := func(path string) (int, []byte, http.Header) {
+		t.Helper()
+		rsp, err := http.Get(s.URL + path)
+		if err != nil {
+			t.Fatalf("GET %q failed: %v", path, err)
+		}
+		defer rsp.Body.Close()
+		body, err := io.ReadAll(rsp.Body)
+		if err != nil {
+			t.Fatalf("reading response for %q failed: %v", path, err)
+		}
+		return rsp.StatusCode, body, rsp.Header
+	}
+	leafHashes := func(leaves []types.Leaf) []byte {
+		var buf []byte
+		for _, leaf := range leaves {
+			h := merkle.HashLeafNode(leaf.ToBinary())
+			buf = append(buf, h[:]...)
+		}
+		return buf
+	}
+
+	for _, table := range []struct {
+		path string
+		want []byte // nil for 404
+	}{
+		{"/foo/tile/0/000", leafHashes(leaves[:256])},
+		{"/foo/tile/0/001.p/17", leafHashes(leaves[256 : 256+17])},
+		{"/foo/tile/0/256", leafHashes(leaves[65536 : 65536+256])},
+		{"/foo/tile/0/257.p/34", leafHashes(leaves[65536+256 : 65536+290])},
+		{"/foo/tile/0/257.p/35", nil},
+		{"/foo/tile/0/258", nil},
+		{"/foo/tile/1/000.p/1", th256.RootHash[:]},
+		{"/foo/tile/1/001.p/1", th65792.RootHash[:]},
+		{"/foo/tile/1/001.p/2", nil},
+		{"/foo/tile/2/000.p/1", th65536.RootHash[:]},
+		{"/foo/tile/2/000.p/2", nil},
+		{"/foo/tile/3/000.p/1", nil},
+		{"/foo/tile/0/x000/000", nil},
+	} {
+		status, body, header := get(table.path)
+		if table.want == nil {
+			if status != http.StatusNotFound {
+				t.Errorf("%q: got status %d, wanted 404", table.path, status)
+			}
+			continue
+		}
+		if status != http.StatusOK {
+			t.Errorf("%q: unexpected status %d: %q", table.path, status, body)
+			continue
+		}
+		if !bytes.Equal(body, table.want) {
+			t.Errorf("%q: unexpected tile contents", table.path)
+		}
+		if got := header.Get("Cache-Control"); got != immutableCacheControl {
+			t.Errorf("%q: unexpected Cache-Control: %q", table.path, got)
+		}
+	}
+
+	status, body, _ := get("/foo/tile/entries/257.p/2")
+	if status != http.StatusOK {
+		t.Fatalf("entries: unexpected status %d: %q", status, body)
+	}
+	var want []byte
+	for _, leaf := range leaves[65536+256 : 65536+258] {
+		want = append(want, 0, 128)
+		want = append(want, leaf.ToBinary()...)
+	}
+	if !bytes.Equal(body, want) {
+		t.Errorf("entries: unexpected entry bundle")
+	}
+
+	status, body, _ = get("/foo/checkpoint")
+	if status != http.StatusOK {
+		t.Fatalf("checkpoint: unexpected status %d: %q", status, body)
+	}
+	origin := types.SigsumCheckpointOrigin(&publicKey)
+	var buf bytes.Buffer
+	if err := (&checkpoint.Checkpoint{
+		SignedTreeHead: state.cth.SignedTreeHead,
+		Origin:         origin,
+		KeyId:          checkpoint.NewLogKeyId(origin, &publicKey),
+	}).ToASCII(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, buf.Bytes()) {
+		t.Errorf("checkpoint: got %q, wanted %q", body, buf.Bytes())
+	}
+}
+
+// Counts backend requests, and leaves returned.
+type countingBackend struct {
+	Backend
+	requests int
+	leaves   int
+}
+
+func (b *countingBackend) GetLeaves(ctx context.Context, req *requests.Leaves) ([]types.Leaf, error) {
+	b.requests++
+	leaves, err := b.Backend.GetLeaves(ctx, req)
+	b.leaves += len(leaves)
+	return leaves, err
+}
+
+func (b *countingBackend) GetInclusionProof(ctx context.Context, req *requests.InclusionProof) (types.InclusionProof, error) {
+	b.requests++
+	return b.Backend.GetInclusionProof(ctx, req)
+}
+
+func TestTileHashesBackendCalls(t *testing.T) {
+	const n = 65536 + 512
+	leaves := newLeaves(n)
+	dbClient := db.NewMemoryDb()
+	if err := dbClient.AddSequencedLeaves(nil, leaves, 0); err != nil {
+		t.Fatal(err)
+	}
+	backend := countingBackend{Backend: dbClient}
+	publicKey, _, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(&publicKey, &backend, nil, time.Minute)
+
+	for _, table := range []struct {
+		desc         string
+		level        uint8
+		index        uint64
+		width        int
+		want         []types.TreeHead
+		wantRequests int
+		wantLeaves   int
+	}{
+		{"level 2", 2, 0, 1, []types.TreeHead{subtreeHead(leaves[:65536])}, 2, 1},
+		{"level 1", 1, 0, 256, []types.TreeHead{subtreeHead(leaves[:256])}, 64, 65536},
+		{"level 1, cached", 1, 0, 256, nil, 0, 0},
+		// Computed from the cached root of the above tile.
+		{"level 2, cached", 2, 0, 1, []types.TreeHead{subtreeHead(leaves[:65536])}, 0, 0},
+		{"level 0", 0, 257, 256, nil, 1, 256},
+		{"level 1, partial", 1, 1, 2, []types.TreeHead{
+			subtreeHead(leaves[65536 : 65536+256]),
+			subtreeHead(leaves[65536+256:]),
+		}, 1, 256},
+	} {
+		backend.requests, backend.leaves = 0, 0
+		budget := maxTileRequests
+		hashes, err := s.tileHashes(context.Background(), table.level, table.index, table.width, &budget)
+		if err != nil {
+			t.Fatalf("%s: failed: %v", table.desc, err)
+		}
+		if len(hashes) != table.width {
+			t.Fatalf("%s: got %d hashes, wanted %d", table.desc, len(hashes), table.width)
+		}
+		for i, th := range table.want {
+			if hashes[i] != th.RootHash {
+				t.Errorf("%s: unexpected hash %d", table.desc, i)
+			}
+		}
+		if backend.requests != table.wantRequests {
+			t.Errorf("%s: got %d backend requests, wanted %d", table.desc, backend.requests, table.wantRequests)
+		}
+		if backend.leaves != table.wantLeaves {
+			t.Errorf("%s: got %d leaves from backend, wanted %d", table.desc, backend.leaves, table.wantLeaves)
+		}
+	}
+
+	// With a smaller budget, the tile is served after a few
+	// retries, each limited by the budget.
+	backend.requests = 0
+	s = NewServer(&publicKey, &backend, nil, time.Minute)
+	s.maxRequests = 10
+	retries := 0
+	for {
+		_, err := s.hashTile(context.Background(), 1, 0, 256)
+		if err == nil {
+			break
+		}
+		if err != errTileBusy {
+			t.Fatal(err)
+		}
+		retries++
+		if retries > 10 {
+			t.Fatalf("no progress")
+		}
+	}
+	if retries != 6 || backend.requests != 64 {
+		t.Errorf("unexpected retries %d, and backend requests %d", retries, backend.requests)
+	}
+
+	// Evicts when full.
+	s = NewServer(&publicKey, dbClient, nil, time.Minute)
+	s.maxNodes = 10
+	budget := maxTileRequests
+	if _, err := s.tileHashes(context.Background(), 1, 0, 256, &budget); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.nodes) > s.maxNodes {
+		t.Errorf("cache size %d exceeds bound", len(s.nodes))
+	}
+}
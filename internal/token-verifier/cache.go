@@ -0,0 +1,136 @@
+package tokenVerifier
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+)
+
+const (
+	// Bounds on the number of cached results. Invalid tokens get
+	// a smaller, separate bound, so that invalid tokens for
+	// arbitrary domains can't push out valid ones. When full,
+	// new results aren't cached.
+	maxCacheEntries         = 10000
+	maxNegativeCacheEntries = 1000
+)
+
+type cacheKey struct {
+	domain string
+	token  crypto.Signature
+}
+
+type cacheEntry struct {
+	// Nil for a valid token.
+	err    error
+	expiry time.Time
+}
+
+// Cached results with a common TTL. Must be used with the
+// cachingVerifier's lock held.
+type resultCache struct {
+	ttl        time.Duration
+	maxEntries int
+	entries    map[cacheKey]cacheEntry
+	// Keys in order of insertion, which, with a common TTL, is
+	// also the order of expiry.
+	queue []cacheKey
+}
+
+func newResultCache(ttl time.Duration, maxEntries int) resultCache {
+	return resultCache{ttl: ttl, maxEntries: maxEntries, entries: make(map[cacheKey]cacheEntry)}
+}
+
+func (c *resultCache) lookup(key cacheKey, now time.Time) (cacheEntry, bool) {
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expiry) {
+		// Expired entries are left for expire to delete.
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Deletes expired entries from the front of the queue, so that
+// each entry is visited once.
+func (c *resultCache) expire(now time.Time) {
+	for len(c.queue) > 0 {
+		key := c.queue[0]
+		if now.Before(c.entries[key].expiry) {
+			return
+		}
+		delete(c.entries, key)
+		c.queue = c.queue[1:]
+	}
+}
+
+func (c *resultCache) store(key cacheKey, err error, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.expire(now)
+	if _, ok := c.entries[key]; ok || len(c.entries) >= c.maxEntries {
+		return
+	}
+	c.entries[key] = cacheEntry{err: err, expiry: now.Add(c.ttl)}
+	c.queue = append(c.queue, key)
+}
+
+type cachingVerifier struct {
+	verifier Verifier
+	now      func() time.Time
+
+	// Protects the caches.
+	sync.Mutex
+	positive resultCache
+	negative resultCache
+}
+
+// NewCachingVerifier wraps a verifier, caching valid tokens for
+// positiveTTL, and invalid tokens for negativeTTL. Lookup failures,
+// which may be temporary, are not cached. A zero TTL disables
+// caching of the corresponding results.
+func NewCachingVerifier(verifier Verifier, positiveTTL, negativeTTL time.Duration) Verifier {
+	return newCachingVerifier(verifier, positiveTTL, negativeTTL, time.Now)
+}
+
+func newCachingVerifier(verifier Verifier, positiveTTL, negativeTTL time.Duration, now func() time.Time) *cachingVerifier {
+	return &cachingVerifier{
+		verifier: verifier,
+		now:      now,
+		positive: newResultCache(positiveTTL, maxCacheEntries),
+		negative: newResultCache(negativeTTL, maxNegativeCacheEntries),
+	}
+}
+
+func (v *cachingVerifier) lookup(key cacheKey) (cacheEntry, bool) {
+	v.Lock()
+	defer v.Unlock()
+	now := v.now()
+	if entry, ok := v.positive.lookup(key, now); ok {
+		return entry, true
+	}
+	return v.negative.lookup(key, now)
+}
+
+func (v *cachingVerifier) Verify(ctx context.Context, header *token.SubmitHeader) error {
+	key := cacheKey{domain: header.Domain, token: header.Token}
+	if entry, ok := v.lookup(key); ok {
+		return entry.err
+	}
+	err := v.verifier.Verify(ctx, header)
+	switch {
+	case err == nil:
+		v.Lock()
+		defer v.Unlock()
+		v.positive.store(key, nil, v.now())
+	case errors.Is(err, ErrInvalidToken):
+		v.Lock()
+		defer v.Unlock()
+		v.negative.store(key, err, v.now())
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package tokenVerifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+)
+
+// Verifier returning a fixed result, and counting calls.
+type countingVerifier struct {
+	err   error
+	count int
+}
+
+func (v *countingVerifier) Verify(_ context.Context, _ *token.SubmitHeader) error {
+	v.count++
+	return v.err
+}
+
+func TestCachingVerifier(t *testing.T) {
+	for _, table := range []struct {
+		desc string
+		err  error
+		// Expected number of calls, after calls at time 0, 30s
+		// and 90s, with TTLs of one and two minutes.
+		count int
+	}{
+		{"valid", nil, 1},
+		{"invalid", fmt.Errorf("%w: no key", ErrInvalidToken), 2},
+		{"lookup failure", errors.New("timeout"), 3},
+	} {
+		now := time.Unix(1000, 0)
+		inner := countingVerifier{err: table.err}
+		v := newCachingVerifier(&inner, 2*time.Minute, time.Minute, func() time.Time { return now })
+		header := token.SubmitHeader{Domain: "example.org"}
+		for _, delay := range []time.Duration{0, 30 * time.Second, time.Minute} {
+			now = now.Add(delay)
+			if err := v.Verify(context.Background(), &header); err != table.err {
+				t.Errorf("%s: unexpected error: %v", table.desc, err)
+			}
+		}
+		if inner.count != table.count {
+			t.Errorf("%s: unexpected number of lookups, got %d, wanted %d", table.desc, inner.count, table.count)
+		}
+	}
+}
+
+func TestCachingVerifierKey(t *testing.T) {
+	inner := countingVerifier{}
+	v := NewCachingVerifier(&inner, time.Minute, time.Minute)
+	for _, header := range []token.SubmitHeader{
+		{Domain: "example.org"},
+		{Domain: "example.org"},
+		{Domain: "example.com"},
+		{Domain: "example.org", Token: crypto.Signature{1}},
+	} {
+		if err := v.Verify(context.Background(), &header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inner.count != 3 {
+		t.Errorf("unexpected number of lookups, got %d, wanted 3", inner.count)
+	}
+}
+
+func TestCachingVerifierNegativeBound(t *testing.T) {
+	now := time.Unix(1000, 0)
+	invalid := countingVerifier{err: fmt.Errorf("%w: no key", ErrInvalidToken)}
+	v := newCachingVerifier(&invalid, time.Minute, time.Minute, func() time.Time { return now })
+	for i := 0; i < 2*maxNegativeCacheEntries; i++ {
+		header := token.SubmitHeader{Domain: fmt.Sprintf("%d.example.org", i)}
+		if err := v.Verify(context.Background(), &header); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if got := len(v.negative.entries); got != maxNegativeCacheEntries {
+		t.Errorf("unexpected number of negative entries, got %d, wanted %d", got, maxNegativeCacheEntries)
+	}
+
+	valid := countingVerifier{}
+	v.verifier = &valid
+	header := token.SubmitHeader{Domain: "example.org"}
+	for i := 0; i < 2; i++ {
+		if err := v.Verify(context.Background(), &header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if valid.count != 1 {
+		t.Errorf("unexpected number of lookups, got %d, wanted 1", valid.count)
+	}
+
+	// Once expired, negative entries are deleted as new results
+	// are stored.
+	now = now.Add(time.Minute)
+	header = token.SubmitHeader{Domain: "example.com"}
+	if err := v.Verify(context.Background(), &header); err != nil {
+		t.Fatal(err)
+	}
+	v.verifier = &invalid
+	header = token.SubmitHeader{Domain: "invalid.example.org"}
+	if err := v.Verify(context.Background(), &header); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := len(v.negative.entries); got != 1 {
+		t.Errorf("unexpected number of negative entries, got %d, wanted 1", got)
+	}
+}
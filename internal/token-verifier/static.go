@@ -0,0 +1,84 @@
+package tokenVerifier
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+)
+
+// Key file syntax is
+//   <domain> <hex public key>
+// with # used for comments. A domain may be listed several times,
+// with different keys.
+
+type staticVerifier struct {
+	keys   map[string][]crypto.PublicKey // map key is normalized domain.
+	logKey crypto.PublicKey
+	// Used for domains not in the file, may be nil.
+	next Verifier
+}
+
+func parseKeyFile(file io.Reader) (map[string][]crypto.PublicKey, error) {
+	keys := make(map[string][]crypto.PublicKey)
+	lineno := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		lineno++
+		line := scanner.Bytes()
+		if comment := bytes.Index(line, []byte{'#'}); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := bytes.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid key file line %d: %q", lineno, line)
+		}
+		domain, err := token.NormalizeDomainName(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid domain on line %d: %v", lineno, err)
+		}
+		key, err := crypto.PublicKeyFromHex(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key on line %d: %v", lineno, err)
+		}
+		keys[domain] = append(keys[domain], key)
+	}
+	return keys, nil
+}
+
+// NewStaticVerifier returns a verifier using the domains and keys
+// listed in the given key file. Tokens for domains not listed are
+// passed on to the next verifier, or rejected if next is nil.
+func NewStaticVerifier(file io.Reader, logKey *crypto.PublicKey, next Verifier) (Verifier, error) {
+	keys, err := parseKeyFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return &staticVerifier{keys: keys, logKey: *logKey, next: next}, nil
+}
+
+func (v *staticVerifier) Verify(ctx context.Context, header *token.SubmitHeader) error {
+	domain, err := token.NormalizeDomainName(header.Domain)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	keys, ok := v.keys[domain]
+	if !ok {
+		if v.next != nil {
+			return v.next.Verify(ctx, header)
+		}
+		return fmt.Errorf("%w: no keys listed for domain %q", ErrInvalidToken, header.Domain)
+	}
+	for _, key := range keys {
+		if token.VerifyToken(&key, &v.logKey, &header.Token) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no matching key listed for domain %q", ErrInvalidToken, header.Domain)
+}
@@ -0,0 +1,95 @@
+package tokenVerifier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+)
+
+func TestParseKeyFile(t *testing.T) {
+	for _, table := range []struct {
+		desc    string
+		input   string
+		domains int // -1 for failure
+	}{
+		{"empty", "", 0},
+		{"comments", "# comment\n  \n", 0},
+		{"valid", fmt.Sprintf("example.org %x\nExample.ORG %x # second key\nexample.com %x\n",
+			crypto.PublicKey{1}, crypto.PublicKey{2}, crypto.PublicKey{3}), 2},
+		{"missing key", "example.org\n", -1},
+		{"extra field", fmt.Sprintf("example.org %x foo\n", crypto.PublicKey{1}), -1},
+		{"bad key", "example.org 0102\n", -1},
+	} {
+		keys, err := parseKeyFile(bytes.NewBufferString(table.input))
+		if table.domains < 0 {
+			if err == nil {
+				t.Errorf("%s: unexpected success", table.desc)
+			}
+		} else if err != nil {
+			t.Errorf("%s: failed: %v", table.desc, err)
+		} else if len(keys) != table.domains {
+			t.Errorf("%s: unexpected number of domains, got %d, wanted %d", table.desc, len(keys), table.domains)
+		}
+	}
+}
+
+func TestStaticVerifier(t *testing.T) {
+	logPub, _, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitPub, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	validToken, err := token.MakeToken(submitSigner, &logPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := fmt.Sprintf("example.org %x\nexample.org %x\nexample.com %x\n",
+		otherPub, submitPub, otherPub)
+
+	for _, next := range []*countingVerifier{nil, &countingVerifier{}} {
+		var nextVerifier Verifier
+		if next != nil {
+			nextVerifier = next
+		}
+		v, err := NewStaticVerifier(bytes.NewBufferString(keyFile), &logPub, nextVerifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, table := range []struct {
+			domain  string
+			token   crypto.Signature
+			invalid bool
+		}{
+			{"example.org", validToken, false},
+			{"Example.Org", validToken, false},
+			{"example.org", crypto.Signature{1}, true},
+			{"example.com", validToken, true},
+			// Not listed, passed on to next, if any.
+			{"example.net", validToken, next == nil},
+		} {
+			err := v.Verify(context.Background(), &token.SubmitHeader{Domain: table.domain, Token: table.token})
+			if table.invalid {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("unexpected result for domain %q, err: %v", table.domain, err)
+				}
+			} else if err != nil {
+				t.Errorf("verification for domain %q failed: %v", table.domain, err)
+			}
+		}
+		if next != nil && next.count != 1 {
+			t.Errorf("unexpected number of calls to next verifier: %d", next.count)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package tokenVerifier implements verification of submit tokens,
+// by looking up the submitter's keys in DNS.
+package tokenVerifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+)
+
+// Bound on the number of TXT records examined per lookup, matching
+// the limit in sigsum-go's token.DnsVerifier. Without it, a domain
+// publishing many records would make each lookup expensive.
+const maxNumberOfKeys = 10
+
+// Returned (wrapped) if the domain has no key matching the token,
+// as opposed to failures to do the DNS lookup.
+var ErrInvalidToken = errors.New("invalid submit token")
+
+type Verifier interface {
+	Verify(ctx context.Context, header *token.SubmitHeader) error
+}
+
+type Metrics interface {
+	// Records latency of a DNS lookup, with result "ok",
+	// "not-found" or "error".
+	RecordLookup(result string, latency time.Duration)
+}
+
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// Returns a resolver that sends queries to the DNS server at the
+// given address (host:port). If address is empty, returns the system
+// resolver.
+func NewResolver(address string) Resolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+}
+
+type dnsVerifier struct {
+	resolver Resolver
+	logKey   crypto.PublicKey
+	metrics  Metrics
+}
+
+func NewDnsVerifier(resolver Resolver, logKey *crypto.PublicKey, metrics Metrics) Verifier {
+	return &dnsVerifier{resolver: resolver, logKey: *logKey, metrics: metrics}
+}
+
+func (v *dnsVerifier) Verify(ctx context.Context, header *token.SubmitHeader) error {
+	start := time.Now()
+	rsps, err := v.resolver.LookupTXT(ctx, token.Label+"."+header.Domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			v.metrics.RecordLookup("not-found", time.Since(start))
+			return fmt.Errorf("%w: no keys registered for domain %q", ErrInvalidToken, header.Domain)
+		}
+		v.metrics.RecordLookup("error", time.Since(start))
+		return fmt.Errorf("looking up keys for domain %q failed: %v", header.Domain, err)
+	}
+	v.metrics.RecordLookup("ok", time.Since(start))
+
+	if len(rsps) > maxNumberOfKeys {
+		rsps = rsps[:maxNumberOfKeys]
+	}
+	for _, rsp := range rsps {
+		key, err := crypto.PublicKeyFromHex(strings.TrimSpace(rsp))
+		if err != nil {
+			continue
+		}
+		if token.VerifyToken(&key, &v.logKey, &header.Token) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no matching key registered for domain %q", ErrInvalidToken, header.Domain)
+}
@@ -0,0 +1,159 @@
+package tokenVerifier
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"sigsum.org/sigsum-go/pkg/crypto"
+	"sigsum.org/sigsum-go/pkg/submit-token"
+)
+
+type lookupCounts map[string]int
+
+func (c lookupCounts) RecordLookup(result string, _ time.Duration) {
+	c[result]++
+}
+
+// Serves TXT records over UDP, until the connection is closed.
+// Unknown names get an NXDOMAIN response.
+func serveDns(t *testing.T, conn net.PacketConn, records map[string][]string) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var p dnsmessage.Parser
+		header, err := p.Start(buf[:n])
+		if err != nil {
+			t.Errorf("invalid dns query: %v", err)
+			continue
+		}
+		q, err := p.Question()
+		if err != nil {
+			t.Errorf("invalid dns question: %v", err)
+			continue
+		}
+		name := strings.TrimSuffix(q.Name.String(), ".")
+		txt, ok := records[name]
+		rcode := dnsmessage.RCodeSuccess
+		if !ok {
+			rcode = dnsmessage.RCodeNameError
+		}
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+			ID: header.ID, Response: true, Authoritative: true, RCode: rcode,
+		})
+		b.StartQuestions()
+		b.Question(q)
+		b.StartAnswers()
+		if q.Type == dnsmessage.TypeTXT {
+			for _, s := range txt {
+				b.TXTResource(dnsmessage.ResourceHeader{
+					Name: q.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: 60,
+				}, dnsmessage.TXTResource{TXT: []string{s}})
+			}
+		}
+		rsp, err := b.Finish()
+		if err != nil {
+			t.Errorf("building dns response failed: %v", err)
+			continue
+		}
+		conn.WriteTo(rsp, addr)
+	}
+}
+
+func TestDnsVerifier(t *testing.T) {
+	logPub, _, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitPub, submitSigner, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	validToken, err := token.MakeToken(submitSigner, &logPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Records before the valid key, not counting it.
+	padding := func(n int) []string {
+		var records []string
+		for i := 0; i < n; i++ {
+			records = append(records, fmt.Sprintf("%064x", i))
+		}
+		return records
+	}
+	go serveDns(t, conn, map[string][]string{
+		token.Label + ".example.org": []string{"garbage", hex.EncodeToString(submitPub[:])},
+		token.Label + ".example.com": []string{strings.Repeat("00", 32)},
+		// Valid key as the last examined record.
+		token.Label + ".a.example.org": append(padding(maxNumberOfKeys-1), hex.EncodeToString(submitPub[:])),
+		// Valid key after too many other records.
+		token.Label + ".b.example.org": append(padding(maxNumberOfKeys), hex.EncodeToString(submitPub[:])),
+	})
+
+	metrics := make(lookupCounts)
+	v := NewDnsVerifier(NewResolver(conn.LocalAddr().String()), &logPub, metrics)
+	for _, table := range []struct {
+		domain  string
+		token   crypto.Signature
+		invalid bool
+	}{
+		{"example.org", validToken, false},
+		{"example.org", crypto.Signature{1}, true},
+		{"example.com", validToken, true},
+		{"example.net", validToken, true},
+		{"a.example.org", validToken, false},
+		{"b.example.org", validToken, true},
+	} {
+		err := v.Verify(context.Background(), &token.SubmitHeader{Domain: table.domain, Token: table.token})
+		if table.invalid {
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("unexpected result for domain %q, err: %v", table.domain, err)
+			}
+		} else if err != nil {
+			t.Errorf("verification for domain %q failed: %v", table.domain, err)
+		}
+	}
+	if metrics["ok"] != 5 || metrics["not-found"] != 1 {
+		t.Errorf("unexpected lookup metrics: %v", metrics)
+	}
+}
+
+func TestDnsVerifierLookupFailure(t *testing.T) {
+	logPub, _, err := crypto.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A server that never responds.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	metrics := make(lookupCounts)
+	v := NewDnsVerifier(NewResolver(conn.LocalAddr().String()), &logPub, metrics)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = v.Verify(ctx, &token.SubmitHeader{Domain: "example.org"})
+	if err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("unexpected result from unresponsive server, err: %v", err)
+	}
+	if metrics["error"] != 1 {
+		t.Errorf("unexpected lookup metrics: %v", metrics)
+	}
+}
@@ -0,0 +1,130 @@
+package witness
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Health int
+
+const (
+	// Latest attempt succeeded.
+	HealthHealthy Health = iota
+	// Latest attempt failed.
+	HealthDegraded
+	// At least downThreshold consecutive attempts failed.
+	HealthDown
+)
+
+const (
+	downThreshold = 3
+	// After n consecutive failures, the witness is not queried
+	// again until backoffBase * 2^(n-1) has passed, or at most
+	// maxProbePeriod.
+	backoffBase    = 5 * time.Second
+	maxProbePeriod = 10 * time.Minute
+)
+
+func (h Health) String() string {
+	switch h {
+	case HealthHealthy:
+		return "healthy"
+	case HealthDegraded:
+		return "degraded"
+	case HealthDown:
+		return "down"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(h))
+	}
+}
+
+func (h Health) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// Status of a witness, as seen by the collector.
+type Status struct {
+	URL     string `json:"url"`
+	KeyHash string `json:"key-hash"`
+	Health  Health `json:"health"`
+	// Number of consecutive failed attempts.
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last-error,omitempty"`
+	LastSuccess time.Time `json:"last-success,omitzero"`
+	// Zero if the witness isn't backed off.
+	NextAttempt time.Time `json:"next-attempt,omitzero"`
+}
+
+// Tracks consecutive failures of a witness. Concurrency safe, since
+// it's read by status queries while witnesses are queried.
+type healthState struct {
+	sync.Mutex
+	failures    int
+	lastError   error
+	lastSuccess time.Time
+	nextAttempt time.Time
+}
+
+// Returns true if the witness should be queried now, false if it's
+// backed off after previous failures.
+func (s *healthState) shouldQuery(now time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+	return !now.Before(s.nextAttempt)
+}
+
+// Records the result of an attempt, and returns the new health.
+func (s *healthState) record(now time.Time, err error) Health {
+	s.Lock()
+	defer s.Unlock()
+	if err == nil {
+		s.failures = 0
+		s.lastError = nil
+		s.lastSuccess = now
+		s.nextAttempt = time.Time{}
+	} else {
+		s.failures++
+		s.lastError = err
+		s.nextAttempt = now.Add(backoff(s.failures))
+	}
+	return s.health()
+}
+
+// Must be called with lock held.
+func (s *healthState) health() Health {
+	switch {
+	case s.failures == 0:
+		return HealthHealthy
+	case s.failures < downThreshold:
+		return HealthDegraded
+	default:
+		return HealthDown
+	}
+}
+
+func (s *healthState) status() Status {
+	s.Lock()
+	defer s.Unlock()
+	status := Status{
+		Health:      s.health(),
+		Failures:    s.failures,
+		LastSuccess: s.lastSuccess,
+		NextAttempt: s.nextAttempt,
+	}
+	if s.lastError != nil {
+		status.LastError = s.lastError.Error()
+	}
+	return status
+}
+
+func backoff(failures int) time.Duration {
+	d := backoffBase
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= maxProbePeriod {
+			return maxProbePeriod
+		}
+	}
+	return d
+}
@@ -0,0 +1,56 @@
+package witness
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	for _, table := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{7, 320 * time.Second},
+		{8, maxProbePeriod},
+		{100, maxProbePeriod},
+	} {
+		if got := backoff(table.failures); got != table.want {
+			t.Errorf("unexpected backoff after %d failures, got %v, want %v", table.failures, got, table.want)
+		}
+	}
+}
+
+func TestHealthState(t *testing.T) {
+	now := time.Now()
+	var s healthState
+	if !s.shouldQuery(now) {
+		t.Fatalf("new witness not queried")
+	}
+	for i, want := range []Health{HealthDegraded, HealthDegraded, HealthDown, HealthDown} {
+		if got := s.record(now, fmt.Errorf("mock failure")); got != want {
+			t.Errorf("unexpected health after %d failures, got %s, want %s", i+1, got, want)
+		}
+		if s.shouldQuery(now) {
+			t.Errorf("witness queried, despite backoff after %d failures", i+1)
+		}
+		if d := backoff(i + 1); !s.shouldQuery(now.Add(d)) {
+			t.Errorf("witness not queried %v after %d failures", d, i+1)
+		}
+	}
+	if status := s.status(); status.Health != HealthDown || status.Failures != 4 || status.LastError != "mock failure" {
+		t.Errorf("unexpected status: %v", status)
+	}
+	if got := s.record(now, nil); got != HealthHealthy {
+		t.Errorf("unexpected health after success, got %s", got)
+	}
+	if !s.shouldQuery(now) {
+		t.Errorf("witness not queried after success")
+	}
+	if status := s.status(); status.Failures != 0 || status.LastError != "" || !status.NextAttempt.IsZero() {
+		t.Errorf("unexpected status after success: %v", status)
+	}
+}
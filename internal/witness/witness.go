@@ -2,6 +2,8 @@ package witness
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -19,16 +21,65 @@ import (
 type WitnessMetrics interface {
 	RecordCheckpointRequest(witnessID string, retried bool, err error, elapsed time.Duration)
 	RecordQuorum(haveQuorum bool, d time.Duration)
+	// Time since the currently published tree head was published.
+	RecordPublishedAge(age time.Duration)
+	RecordWitnessHealth(witnessID string, health Health)
 }
 
 type noMetrics struct{}
 
 func (_ noMetrics) RecordCheckpointRequest(_ string, _ bool, _ error, _ time.Duration) {}
 func (_ noMetrics) RecordQuorum(_ bool, _ time.Duration)                               {}
+func (_ noMetrics) RecordPublishedAge(_ time.Duration)                                 {}
+func (_ noMetrics) RecordWitnessHealth(_ string, _ Health)                             {}
+
+// Cosignatures older than this are refreshed, even if the tree head
+// is unchanged, unless the timestamp window calls for more frequent
+// refresh.
+const maxCosignatureRefreshAge = time.Hour
+
+// Returned for cosignatures with a timestamp outside of the window.
+var ErrCosignatureTimestamp = errors.New("cosignature timestamp outside of window")
+
+// Bounds on how far a cosignature timestamp may be in the past or in
+// the future, relative to the start of the rotation. Zero means no
+// bound.
+type TimestampWindow struct {
+	MaxPast   time.Duration
+	MaxFuture time.Duration
+}
+
+func (tw *TimestampWindow) check(timestamp uint64, start time.Time) error {
+	t := time.Unix(int64(timestamp), 0)
+	if tw.MaxPast > 0 && t.Before(start.Add(-tw.MaxPast)) {
+		return fmt.Errorf("%w: %v is more than %v before rotation at %v",
+			ErrCosignatureTimestamp, t.UTC(), tw.MaxPast, start.UTC())
+	}
+	if tw.MaxFuture > 0 && t.After(start.Add(tw.MaxFuture)) {
+		return fmt.Errorf("%w: %v is more than %v after rotation at %v",
+			ErrCosignatureTimestamp, t.UTC(), tw.MaxFuture, start.UTC())
+	}
+	return nil
+}
+
+// Returns the age at which reused cosignatures are refreshed. With a
+// bound on the past, that is half of the bound, so that a reused
+// cosignature is replaced well before it falls outside the window.
+func (tw *TimestampWindow) refreshAge() time.Duration {
+	if tw.MaxPast > 0 {
+		return min(maxCosignatureRefreshAge, tw.MaxPast/2)
+	}
+	return maxCosignatureRefreshAge
+}
 
 type GetConsistencyProofFunc func(ctx context.Context, req *requests.ConsistencyProof) (types.ConsistencyProof, error)
 type QuorumPredicate func(cosignatures map[crypto.Hash]types.Cosignature) bool
 
+// Called with a copy of the cosignatures collected so far, as soon
+// as they satisfy the quorum, and then again for each late
+// cosignature.
+type PublishFunc func(cosignatures map[crypto.Hash]types.Cosignature)
+
 // Not concurrency safe, due to updates of prevSize.
 type witness struct {
 	client   api.Witness
@@ -37,14 +88,15 @@ type witness struct {
 	prevSize uint64
 	// Error from previous attempt.
 	prevError error
+	health    healthState
 }
 
-func newWitness(w *policy.Entity) *witness {
+func newWitness(w *policy.Entity, prevSize uint64) *witness {
 	return &witness{
 		client:   client.New(client.Config{URL: w.URL, UserAgent: "Sigsum log-go server"}),
 		entity:   *w,
 		keyHash:  crypto.HashBytes(w.PublicKey[:]),
-		prevSize: 0,
+		prevSize: prevSize,
 	}
 }
 
@@ -58,6 +110,13 @@ type cosignatureItem struct {
 
 func (w *witness) getCosignature(ctx context.Context, cp *checkpoint.Checkpoint, getConsistencyProof GetConsistencyProofFunc) (cosignatureItem, error) {
 	freshOldSize := false
+	if w.prevSize > cp.Size {
+		// Stale state, e.g., restored from file. Let the
+		// witness tell us its size.
+		log.Info("known size %d for witness %q is larger than tree size %d, ignoring",
+			w.prevSize, w.entity.URL, cp.Size)
+		w.prevSize = 0
+	}
 	for {
 		proof, err := getConsistencyProof(ctx, &requests.ConsistencyProof{
 			OldSize: w.prevSize,
@@ -99,10 +158,15 @@ type CosignatureCollector struct {
 	witnesses           []*witness
 	quorum              QuorumPredicate
 	metrics             WitnessMetrics
+	window              TimestampWindow
 }
 
+// The optional sizes map, indexed by witness key hash, holds the tree
+// size each witness is known to have cosigned, e.g., as returned by
+// WitnessSizes before a restart.
 func NewCosignatureCollector(logPublicKey *crypto.PublicKey, witnesses []policy.Entity,
-	getConsistencyProof GetConsistencyProofFunc, metrics WitnessMetrics, quorum QuorumPredicate) *CosignatureCollector {
+	getConsistencyProof GetConsistencyProofFunc, metrics WitnessMetrics, quorum QuorumPredicate,
+	sizes map[crypto.Hash]uint64, window TimestampWindow) *CosignatureCollector {
 	origin := types.SigsumCheckpointOrigin(logPublicKey)
 	if metrics == nil {
 		metrics = noMetrics{}
@@ -114,17 +178,73 @@ func NewCosignatureCollector(logPublicKey *crypto.PublicKey, witnesses []policy.
 		getConsistencyProof: getConsistencyProof,
 		quorum:              quorum,
 		metrics:             metrics,
+		window:              window,
 	}
 	for _, w := range witnesses {
 		collector.witnesses = append(collector.witnesses,
-			newWitness(&w))
+			newWitness(&w, sizes[crypto.HashBytes(w.PublicKey[:])]))
 	}
 	return &collector
 }
 
-// Queries all witnesses in parallel, blocks until we have result or error from each of them.
+// Returns a new collector for the given witnesses and quorum, e.g.,
+// after the policy is reloaded. State, such as known tree size and
+// health, is carried over for witnesses with unchanged key and url.
+// Must not be called concurrently with GetCosignatures.
+func (c *CosignatureCollector) WithWitnesses(witnesses []policy.Entity, quorum QuorumPredicate) *CosignatureCollector {
+	old := make(map[crypto.Hash]*witness)
+	for _, w := range c.witnesses {
+		old[w.keyHash] = w
+	}
+	collector := *c
+	collector.witnesses = nil
+	collector.quorum = quorum
+	for _, entity := range witnesses {
+		if w, ok := old[crypto.HashBytes(entity.PublicKey[:])]; ok && w.entity.URL == entity.URL {
+			collector.witnesses = append(collector.witnesses, w)
+		} else {
+			collector.witnesses = append(collector.witnesses, newWitness(&entity, 0))
+		}
+	}
+	return &collector
+}
+
+// Returns the tree size each witness is known to have cosigned,
+// indexed by witness key hash. Witnesses with unknown size are
+// omitted. Must not be called concurrently with GetCosignatures.
+func (c *CosignatureCollector) WitnessSizes() map[crypto.Hash]uint64 {
+	sizes := make(map[crypto.Hash]uint64)
+	for _, w := range c.witnesses {
+		if w.prevSize > 0 {
+			sizes[w.keyHash] = w.prevSize
+		}
+	}
+	return sizes
+}
+
+// Returns the status of each witness. Can be called concurrently with
+// GetCosignatures.
+func (c *CosignatureCollector) Status() []Status {
+	var status []Status
+	for _, w := range c.witnesses {
+		s := w.health.status()
+		s.URL = w.entity.URL
+		s.KeyHash = fmt.Sprintf("%x", w.keyHash)
+		status = append(status, s)
+	}
+	return status
+}
+
+// Queries all witnesses in parallel, blocks until we have result or
+// error from each of them. The prev cosignatures, if any, must be for
+// the same tree head; they are included in the result, and witnesses
+// with a recent enough cosignature are not queried again. If publish
+// is non-nil, it is called as soon as the quorum is satisfied
+// (immediately, if there's no quorum predicate), and then for each
+// additional cosignature.
 // Must not be concurrently called.
-func (c *CosignatureCollector) GetCosignatures(ctx context.Context, sth *types.SignedTreeHead) map[crypto.Hash]types.Cosignature {
+func (c *CosignatureCollector) GetCosignatures(ctx context.Context, sth *types.SignedTreeHead,
+	prev map[crypto.Hash]types.Cosignature, publish PublishFunc) map[crypto.Hash]types.Cosignature {
 	cp := checkpoint.Checkpoint{
 		SignedTreeHead: *sth,
 		Origin:         c.origin,
@@ -135,14 +255,46 @@ func (c *CosignatureCollector) GetCosignatures(ctx context.Context, sth *types.S
 
 	ch := make(chan cosignatureItem)
 
+	rotationStart := time.Now()
+	refreshTime := uint64(rotationStart.Add(-c.window.refreshAge()).Unix())
+
+	// Keep only previous cosignatures by current witnesses, and
+	// within the timestamp window, which may have changed since
+	// they were collected.
+	cosignatures := make(map[crypto.Hash]types.Cosignature)
+	for _, w := range c.witnesses {
+		if cs, ok := prev[w.keyHash]; ok {
+			if err := c.window.check(cs.Timestamp, rotationStart); err != nil {
+				log.Debug("dropping previous cosignature from witness %q: %v", w.entity.URL, err)
+				continue
+			}
+			cosignatures[w.keyHash] = cs
+		}
+	}
+
 	// Query witnesses in parallel
 	for i, w := range c.witnesses {
+		if cs, ok := cosignatures[w.keyHash]; ok && cs.Timestamp > refreshTime {
+			log.Debug("reusing cosignature from witness %q for size %d", w.entity.URL, sth.Size)
+			continue
+		}
+		if !w.health.shouldQuery(time.Now()) {
+			log.Debug("skipping witness %q, backing off after failures", w.entity.URL)
+			continue
+		}
 		wg.Add(1)
 		go func(i int, w *witness) {
 			start := time.Now()
 			cs, err := w.getCosignature(ctx, &cp, c.getConsistencyProof)
 			cs.latency = time.Since(start)
+			if err == nil {
+				// Reject, so that a witness with a
+				// broken clock can't publish misleading
+				// timestamps via the log.
+				err = c.window.check(cs.cs.Timestamp, rotationStart)
+			}
 			c.metrics.RecordCheckpointRequest(w.entity.URL, cs.retried, err, cs.latency)
+			c.metrics.RecordWitnessHealth(w.entity.URL, w.health.record(time.Now(), err))
 			// On logging of errors: api.ErrorStatusCode
 			// returns the explicitly associated status
 			// code, if any, otherwise 500. To reduce
@@ -168,18 +320,35 @@ func (c *CosignatureCollector) GetCosignatures(ctx context.Context, sth *types.S
 	}
 	go func() { wg.Wait(); close(ch) }()
 
-	cosignatures := make(map[crypto.Hash]types.Cosignature)
-	recordQuorum := c.quorum != nil
+	// No quorum metrics are recorded if there's no quorum predicate.
+	haveQuorum := c.quorum == nil
+	if !haveQuorum && len(cosignatures) > 0 && c.quorum(cosignatures) {
+		haveQuorum = true
+		c.metrics.RecordQuorum(true, 0)
+	}
+	if haveQuorum && publish != nil {
+		publish(copyCosignatures(cosignatures))
+	}
 	for i := range ch {
-		// TODO: Check that cosignature timestamp is reasonable?
 		cosignatures[i.keyHash] = i.cs
-		if recordQuorum && c.quorum(cosignatures) {
+		if !haveQuorum && c.quorum(cosignatures) {
+			haveQuorum = true
 			c.metrics.RecordQuorum(true, i.latency)
-			recordQuorum = false
+		}
+		if haveQuorum && publish != nil {
+			publish(copyCosignatures(cosignatures))
 		}
 	}
-	if recordQuorum {
+	if !haveQuorum {
 		c.metrics.RecordQuorum(false, 0)
 	}
 	return cosignatures
 }
+
+func copyCosignatures(cosignatures map[crypto.Hash]types.Cosignature) map[crypto.Hash]types.Cosignature {
+	c := make(map[crypto.Hash]types.Cosignature, len(cosignatures))
+	for keyHash, cs := range cosignatures {
+		c[keyHash] = cs
+	}
+	return c
+}
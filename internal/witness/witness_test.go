@@ -2,6 +2,7 @@ package witness
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -111,6 +112,36 @@ func TestWitnessBadSize(t *testing.T) {
 	}
 }
 
+func TestWitnessStaleSize(t *testing.T) {
+	testTimestamp := uint64(101010)
+	_, logSigner := mustKeyPair(t)
+
+	ctrl := gomock.NewController(t)
+	witnessSigner, cli, w := testWitness(t, ctrl)
+	// E.g., loaded from file, for a different tree.
+	w.prevSize = 10
+
+	log := db.NewMockClient(ctrl)
+
+	cp := mustSignTreehead(t, logSigner, 5)
+
+	log.EXPECT().GetConsistencyProof(gomock.Any(), Ptr(gomock.Eq(requests.ConsistencyProof{OldSize: 0, NewSize: 5}))).Return(types.ConsistencyProof{}, nil)
+	cli.EXPECT().AddCheckpoint(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, req requests.AddCheckpoint) ([]checkpoint.CosignatureLine, error) {
+			if req.OldSize != 0 {
+				t.Fatalf("unexpected add tree head req, got: %v", req)
+			}
+			return mustCosign(t, witnessSigner, &req.Checkpoint, testTimestamp), nil
+		})
+	if _, err := w.getCosignature(context.Background(), &cp, log.GetConsistencyProof); err != nil {
+		t.Fatal(err)
+	}
+	collector := CosignatureCollector{witnesses: []*witness{w}}
+	if got, want := collector.WitnessSizes(), map[crypto.Hash]uint64{w.keyHash: 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected witness sizes, got %v, want %v", got, want)
+	}
+}
+
 func TestGetCosignatures(t *testing.T) {
 	testTimestamp := uint64(101010)
 	_, logSigner := mustKeyPair(t)
@@ -129,6 +160,9 @@ func TestGetCosignatures(t *testing.T) {
 		getConsistencyProof: log.GetConsistencyProof,
 		witnesses:           []*witness{w1, w2, w3},
 		metrics:             noMetrics{},
+		quorum: func(cosignatures map[crypto.Hash]types.Cosignature) bool {
+			return len(cosignatures) >= 1
+		},
 	}
 
 	log.EXPECT().GetConsistencyProof(gomock.Any(), Ptr(gomock.Eq(requests.ConsistencyProof{OldSize: 0, NewSize: 5}))).Return(types.ConsistencyProof{}, nil).AnyTimes()
@@ -170,10 +204,112 @@ func TestGetCosignatures(t *testing.T) {
 			return mustCosign(t, signer3, &req.Checkpoint, testTimestamp), nil
 		})
 
-	cosignatures := collector.GetCosignatures(context.Background(), &cp.SignedTreeHead)
+	var published []int
+	cosignatures := collector.GetCosignatures(context.Background(), &cp.SignedTreeHead, nil,
+		func(cosignatures map[crypto.Hash]types.Cosignature) {
+			published = append(published, len(cosignatures))
+		})
 	if got, want := len(cosignatures), 2; got != want {
 		t.Errorf("unexpected number of cosignatures, got: %d, want: %d", got, want)
 	}
+	// Published when quorum is reached, and again for the late
+	// cosignature.
+	if got, want := published, []int{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected publish calls, got cosignature counts %v, want: %v", got, want)
+	}
+}
+
+func TestGetCosignaturesReuse(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	_, logSigner := mustKeyPair(t)
+
+	ctrl := gomock.NewController(t)
+	_, _, w1 := testWitness(t, ctrl)
+	_, cli2, w2 := testWitness(t, ctrl)
+	signer3, cli3, w3 := testWitness(t, ctrl)
+
+	log := db.NewMockClient(ctrl)
+
+	cp := mustSignTreehead(t, logSigner, 5)
+	collector := CosignatureCollector{
+		origin:              cp.Origin,
+		keyId:               cp.KeyId,
+		getConsistencyProof: log.GetConsistencyProof,
+		witnesses:           []*witness{w1, w2, w3},
+		metrics:             noMetrics{},
+	}
+	prev := map[crypto.Hash]types.Cosignature{
+		// Recent, not queried again.
+		w1.keyHash: types.Cosignature{Timestamp: now - 60},
+		// Stale, queried again.
+		w2.keyHash: types.Cosignature{Timestamp: now - 2*3600},
+	}
+
+	log.EXPECT().GetConsistencyProof(gomock.Any(), gomock.Any()).Return(types.ConsistencyProof{}, nil).AnyTimes()
+	// Second witness fails, previous cosignature is kept.
+	cli2.EXPECT().AddCheckpoint(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("mock failure"))
+	cli3.EXPECT().AddCheckpoint(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, req requests.AddCheckpoint) ([]checkpoint.CosignatureLine, error) {
+			return mustCosign(t, signer3, &req.Checkpoint, now), nil
+		})
+
+	cosignatures := collector.GetCosignatures(context.Background(), &cp.SignedTreeHead, prev, nil)
+	if got, want := len(cosignatures), 3; got != want {
+		t.Fatalf("unexpected number of cosignatures, got: %d, want: %d", got, want)
+	}
+	for _, w := range []*witness{w1, w2} {
+		if got, want := cosignatures[w.keyHash], prev[w.keyHash]; got != want {
+			t.Errorf("previous cosignature not kept, got: %v, want: %v", got, want)
+		}
+	}
+	if got := cosignatures[w3.keyHash].Timestamp; got != now {
+		t.Errorf("unexpected timestamp, got %d, want: %d", got, now)
+	}
+	if len(prev) != 2 {
+		t.Errorf("prev cosignatures modified")
+	}
+}
+
+func TestGetCosignaturesReuseWindow(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	_, logSigner := mustKeyPair(t)
+
+	ctrl := gomock.NewController(t)
+	signer1, cli1, w1 := testWitness(t, ctrl)
+	_, cli2, w2 := testWitness(t, ctrl)
+
+	log := db.NewMockClient(ctrl)
+
+	cp := mustSignTreehead(t, logSigner, 5)
+	collector := CosignatureCollector{
+		origin:              cp.Origin,
+		keyId:               cp.KeyId,
+		getConsistencyProof: log.GetConsistencyProof,
+		witnesses:           []*witness{w1, w2},
+		metrics:             noMetrics{},
+		window:              TimestampWindow{MaxPast: 10 * time.Minute},
+	}
+	// Recent enough for reuse, but outside of the window.
+	prev := map[crypto.Hash]types.Cosignature{
+		w1.keyHash: types.Cosignature{Timestamp: now - 30*60},
+		w2.keyHash: types.Cosignature{Timestamp: now - 30*60},
+	}
+
+	log.EXPECT().GetConsistencyProof(gomock.Any(), gomock.Any()).Return(types.ConsistencyProof{}, nil).AnyTimes()
+	cli1.EXPECT().AddCheckpoint(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, req requests.AddCheckpoint) ([]checkpoint.CosignatureLine, error) {
+			return mustCosign(t, signer1, &req.Checkpoint, now), nil
+		})
+	// Second witness fails, and previous cosignature is dropped.
+	cli2.EXPECT().AddCheckpoint(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("mock failure"))
+
+	cosignatures := collector.GetCosignatures(context.Background(), &cp.SignedTreeHead, prev, nil)
+	if got, want := len(cosignatures), 1; got != want {
+		t.Fatalf("unexpected number of cosignatures, got: %d, want: %d", got, want)
+	}
+	if got := cosignatures[w1.keyHash].Timestamp; got != now {
+		t.Errorf("unexpected timestamp, got %d, want: %d", got, now)
+	}
 }
 
 func mustKeyPair(t *testing.T) (crypto.PublicKey, crypto.Signer) {
@@ -223,3 +359,106 @@ func mustSignTreehead(t *testing.T,
 		KeyId:          checkpoint.NewLogKeyId(origin, &pub),
 	}
 }
+
+func TestTimestampWindow(t *testing.T) {
+	start := time.Unix(10000, 0)
+	for _, table := range []struct {
+		desc      string
+		window    TimestampWindow
+		timestamp uint64
+		wantErr   bool
+	}{
+		{"no bounds", TimestampWindow{}, 1, false},
+		{"within", TimestampWindow{time.Minute, time.Minute}, 10000 - 60, false},
+		{"too old", TimestampWindow{time.Minute, time.Minute}, 10000 - 61, true},
+		{"future", TimestampWindow{time.Minute, time.Minute}, 10000 + 60, false},
+		{"too far in future", TimestampWindow{time.Minute, time.Minute}, 10000 + 61, true},
+		{"no future bound", TimestampWindow{MaxPast: time.Minute}, 20000, false},
+	} {
+		err := table.window.check(table.timestamp, start)
+		if got := err != nil; got != table.wantErr {
+			t.Errorf("%s: unexpected result: %v", table.desc, err)
+		}
+		if err != nil && !errors.Is(err, ErrCosignatureTimestamp) {
+			t.Errorf("%s: unexpected error type: %v", table.desc, err)
+		}
+	}
+}
+
+func TestRefreshAge(t *testing.T) {
+	for _, table := range []struct {
+		window TimestampWindow
+		want   time.Duration
+	}{
+		{TimestampWindow{}, time.Hour},
+		{TimestampWindow{MaxPast: 10 * time.Minute}, 5 * time.Minute},
+		{TimestampWindow{MaxPast: 4 * time.Hour}, time.Hour},
+		{TimestampWindow{MaxFuture: time.Minute}, time.Hour},
+	} {
+		if got := table.window.refreshAge(); got != table.want {
+			t.Errorf("unexpected refresh age for %v, got %v, want %v", table.window, got, table.want)
+		}
+	}
+}
+
+func TestGetCosignaturesBadTimestamp(t *testing.T) {
+	_, logSigner := mustKeyPair(t)
+
+	ctrl := gomock.NewController(t)
+	signer, cli, w := testWitness(t, ctrl)
+
+	log := db.NewMockClient(ctrl)
+
+	cp := mustSignTreehead(t, logSigner, 5)
+	collector := CosignatureCollector{
+		origin:              cp.Origin,
+		keyId:               cp.KeyId,
+		getConsistencyProof: log.GetConsistencyProof,
+		witnesses:           []*witness{w},
+		metrics:             noMetrics{},
+		window:              TimestampWindow{MaxPast: time.Hour, MaxFuture: time.Hour},
+	}
+	log.EXPECT().GetConsistencyProof(gomock.Any(), gomock.Any()).Return(types.ConsistencyProof{}, nil)
+	cli.EXPECT().AddCheckpoint(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, req requests.AddCheckpoint) ([]checkpoint.CosignatureLine, error) {
+			// Witness with a broken clock.
+			return mustCosign(t, signer, &req.Checkpoint, uint64(time.Now().Add(-2*time.Hour).Unix())), nil
+		})
+	if cosignatures := collector.GetCosignatures(context.Background(), &cp.SignedTreeHead, nil, nil); len(cosignatures) > 0 {
+		t.Errorf("cosignature with bad timestamp was accepted")
+	}
+	if status := collector.Status(); status[0].Health != HealthDegraded {
+		t.Errorf("unexpected witness health %s", status[0].Health)
+	}
+}
+
+func TestWithWitnesses(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	_, _, w1 := testWitness(t, ctrl)
+	_, _, w2 := testWitness(t, ctrl)
+	w1.prevSize = 7
+	w2.prevSize = 8
+	collector := CosignatureCollector{witnesses: []*witness{w1, w2}}
+
+	w3Pub, _ := mustKeyPair(t)
+	updated := collector.WithWitnesses([]policy.Entity{
+		// Unchanged.
+		w1.entity,
+		// Changed url.
+		policy.Entity{PublicKey: w2.entity.PublicKey, URL: "test://other"},
+		// New witness.
+		policy.Entity{PublicKey: w3Pub, URL: "test://test"},
+	}, nil)
+	if len(updated.witnesses) != 3 {
+		t.Fatalf("unexpected number of witnesses %d", len(updated.witnesses))
+	}
+	if updated.witnesses[0] != w1 {
+		t.Errorf("state of unchanged witness not kept")
+	}
+	if got := updated.witnesses[1]; got == w2 || got.prevSize != 0 || got.entity.URL != "test://other" {
+		t.Errorf("unexpected state of changed witness: %v", got)
+	}
+	if len(collector.witnesses) != 2 {
+		t.Errorf("old collector modified")
+	}
+}